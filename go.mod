@@ -3,20 +3,20 @@ module github.com/portapps/discord-ptb-portable
 go 1.25.0
 
 require (
+	github.com/go-viper/mapstructure/v2 v2.4.0
 	github.com/kevinburke/go-bindata/v4 v4.0.2
 	github.com/portapps/portapps/v3 v3.17.0
+	github.com/rs/zerolog v1.34.0
+	golang.org/x/sys v0.36.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/akavel/rsrc v0.10.2 // indirect
 	github.com/go-ole/go-ole v1.3.0 // indirect
-	github.com/go-viper/mapstructure/v2 v2.4.0 // indirect
 	github.com/ilya1st/rotatewriter v0.0.0-20171126183947-3df0c1a3ed6d // indirect
 	github.com/josephspurrier/goversioninfo v1.5.0 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.19 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
-	github.com/rs/zerolog v1.34.0 // indirect
-	golang.org/x/sys v0.36.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
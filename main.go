@@ -18,6 +18,7 @@ import (
 type config struct {
 	Cleanup bool        `yaml:"cleanup" mapstructure:"cleanup"`
 	Proxy   ProxyConfig `yaml:"proxy" mapstructure:"proxy"`
+	VPN     VPNConfig   `yaml:"vpn" mapstructure:"vpn"`
 }
 
 var (
@@ -25,11 +26,6 @@ var (
 	cfg *config
 )
 
-const defaultSettings = `{
-  "SKIP_HOST_UPDATE": true,
-  "USE_PINNED_UPDATE_MANIFEST": true
-}`
-
 func init() {
 	var err error
 
@@ -39,6 +35,11 @@ func init() {
 		Proxy: ProxyConfig{
 			Mode: "system",
 		},
+		VPN: VPNConfig{
+			Inbound: VPNInboundConfig{
+				UDP: true,
+			},
+		},
 	}
 
 	// Init app
@@ -47,44 +48,14 @@ func init() {
 	}
 }
 
-func ensureSettings(settingsPath string) error {
-	if _, err := os.Stat(settingsPath); err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return os.WriteFile(settingsPath, []byte(defaultSettings), 0644)
+func main() {
+	if target, ok := explainRouteArg(os.Args[1:]); ok {
+		if err := explainRoute(cfg.VPN, target); err != nil {
+			log.Fatal().Err(err).Msg("Cannot explain route")
 		}
-		return err
-	}
-
-	rawSettings, err := os.ReadFile(settingsPath)
-	if err != nil {
-		return err
-	}
-
-	jsonMapSettings := make(map[string]interface{})
-	if err = json.Unmarshal(rawSettings, &jsonMapSettings); err != nil {
-		return err
-	}
-
-	jsonMapSettings["SKIP_HOST_UPDATE"] = true
-	jsonMapSettings["USE_PINNED_UPDATE_MANIFEST"] = true
-
-	jsonSettings, err := json.Marshal(jsonMapSettings)
-	if err != nil {
-		return err
+		return
 	}
 
-	return os.WriteFile(settingsPath, jsonSettings, 0644)
-}
-
-func writeAssetFile(assetName, destination string) error {
-	assetData, err := assets.Asset(assetName)
-	if err != nil {
-		return err
-	}
-	return os.WriteFile(destination, assetData, 0644)
-}
-
-func main() {
 	utl.CreateFolder(app.DataPath)
 	electronAppPath := app.ElectronAppPath()
 
@@ -93,6 +64,16 @@ func main() {
 		"--user-data-dir=" + app.DataPath,
 	}
 	app.WorkingDir = electronAppPath
+
+	// Start VPN core
+	if isVPNMode(cfg.Proxy) {
+		core, err := startVPN(cfg.VPN)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Cannot start VPN")
+		}
+		defer core.stop()
+		cfg.Proxy.Server = core.proxyServer()
+	}
 	applyProxyArgs(cfg.Proxy, &app.Args)
 
 	// Cleanup on exit
@@ -132,7 +113,7 @@ func main() {
 	}
 
 	// Update default shortcut
-	err = shortcut.Create(shortcut.Shortcut{
+	err := shortcut.Create(shortcut.Shortcut{
 		ShortcutPath:     shortcutPath,
 		TargetPath:       app.Process,
 		Arguments:        shortcut.Property{Clear: true},
@@ -21,7 +21,7 @@ func applyProxyArgs(proxy ProxyConfig, args *[]string) {
 			return
 		}
 		*args = append(*args, "--proxy-pac-url="+proxy.PACURL)
-	case "fixed", "fixed_servers":
+	case "fixed", "fixed_servers", "vpn":
 		if strings.TrimSpace(proxy.Server) == "" {
 			return
 		}
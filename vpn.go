@@ -0,0 +1,484 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/portapps/discord-ptb-portable/vpn"
+	"github.com/portapps/portapps/v3/pkg/log"
+	"github.com/portapps/portapps/v3/pkg/utl"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	vpnStartTimeout       = 30 * time.Second
+	vpnLatencyTimeout     = 3 * time.Second
+	vpnSelectionMargin    = 50 * time.Millisecond
+	vpnReevaluateInterval = 10 * time.Minute
+)
+
+type VPNConfig struct {
+	Core       string `yaml:"core" mapstructure:"core"`
+	CorePath   string `yaml:"core_path" mapstructure:"core_path"`
+	Server     string `yaml:"server" mapstructure:"server"`
+	AutoSelect bool   `yaml:"auto_select" mapstructure:"auto_select"`
+	Balancer   string `yaml:"balancer" mapstructure:"balancer"`
+
+	Inbound VPNInboundConfig `yaml:"inbound" mapstructure:"inbound"`
+	DNS     VPNDNSConfig     `yaml:"dns" mapstructure:"dns"`
+	Routing VPNRoutingConfig `yaml:"routing" mapstructure:"routing"`
+}
+
+// VPNRoutingConfig selects what goes through the VPN. Preset is minimal
+// (Discord web hosts only) or discord-full (every Discord host and voice).
+// The rules key is matched before the preset, or alone when replace is set,
+// and is read by vpnRoutingRules rather than decoded here.
+type VPNRoutingConfig struct {
+	Preset  string `yaml:"preset" mapstructure:"preset"`
+	Replace bool   `yaml:"replace" mapstructure:"replace"`
+}
+
+// VPNInboundConfig sets the local proxies exposed by the core. A port of 0
+// disables the inbound, and auto_port picks free ports at launch for the
+// enabled ones, or for a SOCKS inbound when none is.
+type VPNInboundConfig struct {
+	Listen       string   `yaml:"listen" mapstructure:"listen"`
+	SocksPort    int      `yaml:"socks_port" mapstructure:"socks_port"`
+	HTTPPort     int      `yaml:"http_port" mapstructure:"http_port"`
+	MixedPort    int      `yaml:"mixed_port" mapstructure:"mixed_port"`
+	AutoPort     bool     `yaml:"auto_port" mapstructure:"auto_port"`
+	Username     string   `yaml:"username" mapstructure:"username"`
+	Password     string   `yaml:"password" mapstructure:"password"`
+	UDP          bool     `yaml:"udp" mapstructure:"udp"`
+	Sniffing     bool     `yaml:"sniffing" mapstructure:"sniffing"`
+	DestOverride []string `yaml:"dest_override" mapstructure:"dest_override"`
+}
+
+// VPNDNSConfig sets the resolvers of the core. Preset is none, cloudflare,
+// google or quad9; servers replace the resolvers of the preset.
+type VPNDNSConfig struct {
+	Preset        string            `yaml:"preset" mapstructure:"preset"`
+	Servers       []VPNDNSServer    `yaml:"servers" mapstructure:"servers"`
+	QueryStrategy string            `yaml:"query_strategy" mapstructure:"query_strategy"`
+	Hosts         map[string]string `yaml:"hosts" mapstructure:"hosts"`
+}
+
+type VPNDNSServer struct {
+	Address string   `yaml:"address" mapstructure:"address"`
+	Domains []string `yaml:"domains" mapstructure:"domains"`
+}
+
+type vpnCore struct {
+	supervisor *vpn.Supervisor
+	options    vpn.CoreOptions
+	cancel     context.CancelFunc
+}
+
+func isVPNMode(proxy ProxyConfig) bool {
+	return strings.ToLower(strings.TrimSpace(proxy.Mode)) == "vpn"
+}
+
+// startVPN builds the core config for the selected server, starts the core
+// and waits until its local SOCKS inbound accepts connections. With
+// auto_select, the fastest server is picked and monitored for failover.
+func startVPN(vpnCfg VPNConfig) (*vpnCore, error) {
+	store, err := vpn.OpenStore(utl.PathJoin(app.DataPath, vpn.StoreFileName))
+	if err != nil {
+		return nil, err
+	}
+	server, err := selectVPNServer(store, vpnCfg.Server)
+	if err != nil {
+		return nil, err
+	}
+	if vpnCfg.AutoSelect {
+		if server, err = autoSelectVPNServer(store, server, vpnLatencyTester(vpnCfg)); err != nil {
+			return nil, err
+		}
+	}
+
+	core := vpnCoreName(vpnCfg)
+	inbound, err := vpnInboundOptions(vpnCfg.Inbound)
+	if err != nil {
+		return nil, err
+	}
+	dns, err := vpnDNSConfig(vpnCfg.DNS)
+	if err != nil {
+		return nil, err
+	}
+	if dns != nil && core != vpn.CoreXray {
+		log.Warn().Msgf("VPN dns settings are only applied to the %s core", vpn.CoreXray)
+	}
+	routing, err := vpnRouting(vpnCfg)
+	if err != nil {
+		return nil, err
+	}
+	options := vpn.CoreOptions{Inbound: inbound, DNS: dns, Routing: routing}
+	var config interface{}
+	switch {
+	case vpnCfg.Balancer != "" && core != vpn.CoreXray:
+		return nil, fmt.Errorf("balancer requires the %s core", vpn.CoreXray)
+	case vpnCfg.Balancer != "":
+		log.Info().Msgf("Balancing VPN servers with %s strategy", vpnCfg.Balancer)
+		var balanced vpn.XrayConfig
+		balanced, err = vpn.BuildBalancedXrayConfig(storeLinks(store.Servers()), vpn.BalancerOptions{
+			Strategy: vpnCfg.Balancer,
+			Routing:  routing,
+		})
+		balanced.Inbounds = vpn.XrayInbounds(inbound)
+		config = vpn.WithDNS(balanced, dns)
+	default:
+		log.Info().Msgf("Using VPN server %s (%s)", server.DisplayName(), server.Link.Protocol)
+		config, err = vpn.BuildCoreConfig(core, server.Link, options)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("build %s config: %w", core, err)
+	}
+	configPath := utl.PathJoin(app.DataPath, core, "config.json")
+	if err := vpn.WriteConfig(configPath, config); err != nil {
+		return nil, fmt.Errorf("write %s config: %w", core, err)
+	}
+
+	corePath := vpnCorePath(vpnCfg)
+	if !utl.Exists(corePath) {
+		return nil, fmt.Errorf("%s core not found in %s", core, corePath)
+	}
+	supervisor := vpn.NewSupervisor(vpn.SupervisorOptions{
+		CorePath:   corePath,
+		ConfigPath: configPath,
+		ReadyHost:  inbound.Host(),
+		ReadyPort:  inbound.Port(),
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), vpnStartTimeout)
+	defer cancel()
+	if err := supervisor.Start(ctx); err != nil {
+		return nil, err
+	}
+
+	running := &vpnCore{supervisor: supervisor, options: options, cancel: func() {}}
+	switch {
+	case !vpnCfg.AutoSelect || vpnCfg.Balancer != "":
+	case inbound.ProbePort() == 0:
+		log.Warn().Msg("VPN failover needs a SOCKS or mixed inbound, disabled")
+	default:
+		running.startFailover(store, server, core, configPath, vpnLatencyTester(vpnCfg))
+	}
+	return running, nil
+}
+
+func (c *vpnCore) startFailover(store *vpn.Store, current vpn.Server, core, configPath string, tester *vpn.LatencyTester) {
+	servers := store.Servers()
+	links := storeLinks(servers)
+
+	failover := vpn.NewFailover(c.supervisor, vpn.FailoverOptions{
+		Links:   links,
+		Current: current.Link,
+		BuildConfig: func(link vpn.Link) (interface{}, error) {
+			return vpn.BuildCoreConfig(core, link, c.options)
+		},
+		ConfigPath:         configPath,
+		SocksHost:          c.options.Inbound.Host(),
+		SocksPort:          c.options.Inbound.ProbePort(),
+		Margin:             vpnSelectionMargin,
+		Tester:             tester,
+		ReevaluateInterval: vpnReevaluateInterval,
+		OnSwitch: func(link vpn.Link) {
+			for _, server := range servers {
+				if !reflect.DeepEqual(server.Link, link) {
+					continue
+				}
+				if err := store.Select(server.ID); err != nil {
+					log.Error().Err(err).Msg("Cannot select VPN server")
+				} else if err := store.Save(); err != nil {
+					log.Error().Err(err).Msg("Cannot save VPN servers")
+				}
+				return
+			}
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	go failover.Run(ctx)
+}
+
+func (c *vpnCore) proxyServer() string {
+	return c.options.Inbound.ProxyURL()
+}
+
+func (c *vpnCore) stop() {
+	c.cancel()
+	c.supervisor.Stop()
+}
+
+// vpnInboundOptions converts the inbound config, allocating free ports in
+// auto_port mode.
+func vpnInboundOptions(inboundCfg VPNInboundConfig) (vpn.InboundOptions, error) {
+	opts := vpn.InboundOptions{
+		Listen:       inboundCfg.Listen,
+		SocksPort:    inboundCfg.SocksPort,
+		HTTPPort:     inboundCfg.HTTPPort,
+		MixedPort:    inboundCfg.MixedPort,
+		Username:     inboundCfg.Username,
+		Password:     inboundCfg.Password,
+		UDP:          inboundCfg.UDP,
+		Sniffing:     inboundCfg.Sniffing,
+		DestOverride: inboundCfg.DestOverride,
+	}
+	if opts.Username != "" || opts.Password != "" {
+		// Discord cannot authenticate to the SOCKS proxy, so it and the
+		// probes use an unauthenticated inbound on the loopback address.
+		local, err := vpn.FreePort()
+		if err != nil {
+			return vpn.InboundOptions{}, err
+		}
+		opts.LocalPort = local
+	}
+	if !inboundCfg.AutoPort {
+		return opts, nil
+	}
+
+	if opts.SocksPort == 0 && opts.HTTPPort == 0 && opts.MixedPort == 0 {
+		opts.SocksPort = vpn.DefaultLocalPort
+	}
+	for _, port := range []*int{&opts.SocksPort, &opts.HTTPPort, &opts.MixedPort} {
+		if *port == 0 {
+			continue
+		}
+		free, err := vpn.FreePort()
+		if err != nil {
+			return vpn.InboundOptions{}, err
+		}
+		*port = free
+	}
+	log.Info().Msgf("Using local VPN proxy %s", opts.ProxyURL())
+	return opts, nil
+}
+
+func vpnDNSConfig(dnsCfg VPNDNSConfig) (*vpn.DNSConfig, error) {
+	opts := vpn.DNSOptions{
+		Preset:        dnsCfg.Preset,
+		QueryStrategy: dnsCfg.QueryStrategy,
+		Hosts:         dnsCfg.Hosts,
+	}
+	for _, server := range dnsCfg.Servers {
+		opts.Servers = append(opts.Servers, vpn.DNSServerOptions{
+			Address: server.Address,
+			Domains: server.Domains,
+		})
+	}
+	dns, err := vpn.BuildDNSConfig(opts)
+	if err != nil {
+		return nil, fmt.Errorf("vpn dns: %w", err)
+	}
+	return dns, nil
+}
+
+func vpnRouting(vpnCfg VPNConfig) (*vpn.RoutingConfig, error) {
+	routingCfg := vpnCfg.Routing
+	preset, err := vpn.RoutingPreset(routingCfg.Preset)
+	if err != nil {
+		return nil, fmt.Errorf("vpn routing: %w", err)
+	}
+	rules, err := vpnRoutingRules(vpnGeoData(vpnCfg))
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 && !routingCfg.Replace {
+		return preset, nil
+	}
+	return vpn.MergeRouting(preset, rules, routingCfg.Replace), nil
+}
+
+// vpnRoutingRules reads the user routing rules from the config file itself,
+// as the decoded config has lost the line numbers needed to report errors.
+func vpnRoutingRules(geo *vpn.GeoData) ([]vpn.RoutingRule, error) {
+	cfgName := app.ID + ".yml"
+	raw, err := os.ReadFile(utl.PathJoin(app.RootPath, cfgName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("read %s: %w", cfgName, err)
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(raw, &root); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", cfgName, err)
+	}
+	rules, err := vpn.ParseRoutingRules(yamlChild(&root, "app", "vpn", "routing", "rules"), geo)
+	if err != nil {
+		return nil, fmt.Errorf("%s: vpn routing %w", cfgName, err)
+	}
+	return rules, nil
+}
+
+// explainRouteArg returns the target of "--explain-route target" or
+// "--explain-route=target".
+func explainRouteArg(args []string) (string, bool) {
+	for idx, arg := range args {
+		if target, ok := strings.CutPrefix(arg, "--explain-route="); ok {
+			return target, true
+		}
+		if arg == "--explain-route" && idx+1 < len(args) {
+			return args[idx+1], true
+		}
+	}
+	return "", false
+}
+
+// explainRoute logs which routing rule and outbound a connection to target
+// would take with the configured routing.
+func explainRoute(vpnCfg VPNConfig, target string) error {
+	req, err := vpn.ParseRouteRequest(target)
+	if err != nil {
+		return fmt.Errorf("parse %q: %w", target, err)
+	}
+	routing, err := vpnRouting(vpnCfg)
+	if err != nil {
+		return err
+	}
+	if vpnCfg.Balancer != "" {
+		routing = vpn.WithBalancerTag(vpn.XrayConfig{Routing: routing}, vpn.DefaultBalancerTag).Routing
+	}
+	match, err := vpn.ExplainRoute(routing, req, vpnGeoData(vpnCfg))
+	if err != nil {
+		return err
+	}
+
+	if match.Rule < 0 {
+		log.Info().Msgf("%s matches no rule and goes to the %s outbound", req, match.Outbound)
+		return nil
+	}
+	rule, err := json.Marshal(routing.Rules[match.Rule])
+	if err != nil {
+		return err
+	}
+	if match.Balancer != "" {
+		log.Info().Msgf("%s matches rule %d %s and goes to the %s balancer", req, match.Rule+1, rule, match.Balancer)
+		return nil
+	}
+	log.Info().Msgf("%s matches rule %d %s and goes to the %s outbound", req, match.Rule+1, rule, match.Outbound)
+	return nil
+}
+
+// yamlChild follows keys through nested mappings, nil when one is missing.
+func yamlChild(node *yaml.Node, keys ...string) *yaml.Node {
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	for _, key := range keys {
+		if node.Kind != yaml.MappingNode {
+			return nil
+		}
+		var child *yaml.Node
+		for idx := 0; idx+1 < len(node.Content); idx += 2 {
+			if node.Content[idx].Value == key {
+				child = node.Content[idx+1]
+				break
+			}
+		}
+		if child == nil {
+			return nil
+		}
+		node = child
+	}
+	return node
+}
+
+// vpnGeoData returns the geosite.dat and geoip.dat shipped next to the core.
+func vpnGeoData(vpnCfg VPNConfig) *vpn.GeoData {
+	return vpn.NewGeoData(filepath.Dir(vpnCorePath(vpnCfg)))
+}
+
+func vpnCoreName(vpnCfg VPNConfig) string {
+	if core := strings.ToLower(strings.TrimSpace(vpnCfg.Core)); core != "" {
+		return core
+	}
+	return vpn.CoreXray
+}
+
+func vpnCorePath(vpnCfg VPNConfig) string {
+	if strings.TrimSpace(vpnCfg.CorePath) != "" {
+		return vpnCfg.CorePath
+	}
+	core := vpnCoreName(vpnCfg)
+	return utl.PathJoin(app.RootPath, core, core+".exe")
+}
+
+// selectVPNServer picks the server named in the config by ID or name, then
+// the server selected in the store, then the first stored server.
+func selectVPNServer(store *vpn.Store, wanted string) (vpn.Server, error) {
+	servers := store.Servers()
+	if wanted = strings.TrimSpace(wanted); wanted != "" {
+		for _, server := range servers {
+			if server.ID == wanted || server.DisplayName() == wanted {
+				return server, nil
+			}
+		}
+		return vpn.Server{}, fmt.Errorf("vpn server %q: %w", wanted, vpn.ErrServerNotFound)
+	}
+	if server, ok := store.Selected(); ok {
+		return server, nil
+	}
+	if len(servers) == 0 {
+		return vpn.Server{}, errors.New("no vpn server imported")
+	}
+	return servers[0], nil
+}
+
+// vpnLatencyTester measures servers with the bundled core, so that UDP-only
+// servers get a URL test.
+func vpnLatencyTester(vpnCfg VPNConfig) *vpn.LatencyTester {
+	return &vpn.LatencyTester{
+		Timeout:  vpnLatencyTimeout,
+		CorePath: vpnCorePath(vpnCfg),
+		Core:     vpnCoreName(vpnCfg),
+	}
+}
+
+// autoSelectVPNServer measures every stored server, records the latencies
+// and selects the fastest one, keeping preferred when it is close enough or
+// when no server answered.
+func autoSelectVPNServer(store *vpn.Store, preferred vpn.Server, tester *vpn.LatencyTester) (vpn.Server, error) {
+	servers := store.Servers()
+	links := storeLinks(servers)
+
+	results := tester.Test(context.Background(), links)
+	now := time.Now()
+	for _, result := range results {
+		if err := store.RecordLatency(servers[result.Index].ID, result, now); err != nil {
+			return vpn.Server{}, err
+		}
+	}
+
+	best, err := vpn.SelectBest(results, &preferred.Link, vpnSelectionMargin)
+	if errors.Is(err, vpn.ErrNoHealthyServer) {
+		log.Warn().Msgf("No VPN server answered, keeping %s", preferred.DisplayName())
+		return preferred, nil
+	} else if err != nil {
+		return vpn.Server{}, err
+	}
+	selected := servers[best.Index]
+	if err := store.Select(selected.ID); err != nil {
+		return vpn.Server{}, err
+	}
+	if err := store.Save(); err != nil {
+		log.Error().Err(err).Msg("Cannot save VPN servers")
+	}
+	return selected, nil
+}
+
+func storeLinks(servers []vpn.Server) []vpn.Link {
+	links := make([]vpn.Link, len(servers))
+	for idx, server := range servers {
+		links[idx] = server.Link
+	}
+	return links
+}
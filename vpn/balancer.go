@@ -0,0 +1,147 @@
+package vpn
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/portapps/portapps/v3/pkg/log"
+)
+
+// DefaultBalancerTag names the balancer unless BalancerOptions.Tag is set.
+const DefaultBalancerTag = "proxy-balancer"
+
+const (
+	BalancerLeastPing  = "leastPing"
+	BalancerLeastLoad  = "leastLoad"
+	BalancerRandom     = "random"
+	BalancerRoundRobin = "roundRobin"
+)
+
+type BalancerConfig struct {
+	Tag         string                  `json:"tag"`
+	Selector    []string                `json:"selector"`
+	Strategy    *BalancerStrategyConfig `json:"strategy,omitempty"`
+	FallbackTag string                  `json:"fallbackTag,omitempty"`
+}
+
+type BalancerStrategyConfig struct {
+	Type string `json:"type"`
+}
+
+type ObservatoryConfig struct {
+	SubjectSelector   []string `json:"subjectSelector"`
+	ProbeURL          string   `json:"probeUrl,omitempty"`
+	ProbeInterval     string   `json:"probeInterval,omitempty"`
+	EnableConcurrency bool     `json:"enableConcurrency,omitempty"`
+}
+
+type BurstObservatoryConfig struct {
+	SubjectSelector []string         `json:"subjectSelector"`
+	PingConfig      *BurstPingConfig `json:"pingConfig,omitempty"`
+}
+
+type BurstPingConfig struct {
+	Destination  string `json:"destination,omitempty"`
+	Connectivity string `json:"connectivity,omitempty"`
+	Interval     string `json:"interval,omitempty"`
+	Sampling     int    `json:"sampling,omitempty"`
+	Timeout      string `json:"timeout,omitempty"`
+}
+
+type BalancerOptions struct {
+	// Tag names the balancer, DefaultBalancerTag by default.
+	Tag string
+	// Strategy is one of the Balancer* constants, leastPing by default.
+	Strategy string
+	// ProbeURL and ProbeInterval configure the observatory; intervals use
+	// Xray duration syntax such as "1m".
+	ProbeURL      string
+	ProbeInterval string
+	// Routing replaces DefaultRouting.
+	Routing *RoutingConfig
+}
+
+// BuildBalancedXrayConfig emits one outbound per link, tagged "proxy-<n>",
+// and a balancer over them so that Xray picks and fails over between
+// servers itself. Rules that target the proxy, and traffic no rule matches,
+// are routed to the balancer. Links Xray cannot run, such as hysteria2, are
+// skipped.
+func BuildBalancedXrayConfig(links []Link, opts BalancerOptions) (XrayConfig, error) {
+	tag := firstNonEmpty(opts.Tag, DefaultBalancerTag)
+	strategy := firstNonEmpty(opts.Strategy, BalancerLeastPing)
+	switch strategy {
+	case BalancerLeastPing, BalancerLeastLoad, BalancerRandom, BalancerRoundRobin:
+	default:
+		return XrayConfig{}, fmt.Errorf("unsupported balancer strategy %q", strategy)
+	}
+
+	outbounds := make([]OutboundConfig, 0, len(links)+1)
+	var first Link
+	for idx, link := range links {
+		outbound, err := buildBalancedOutbound(link)
+		if err != nil {
+			log.Warn().Err(err).Msgf("Skipping server %d (%s) in balancer", idx+1, link.Address)
+			continue
+		}
+		if len(outbounds) == 0 {
+			first = link
+		}
+		outbound.Tag = fmt.Sprintf("proxy-%d", len(outbounds))
+		outbounds = append(outbounds, outbound)
+	}
+	if len(outbounds) == 0 {
+		return XrayConfig{}, errors.New("no links to balance")
+	}
+
+	config, err := BuildXrayConfig(first)
+	if err != nil {
+		return XrayConfig{}, err
+	}
+	if opts.Routing != nil {
+		config.Routing = cloneRouting(opts.Routing)
+	}
+	for _, outbound := range config.Outbounds {
+		if outbound.Tag != "proxy" {
+			outbounds = append(outbounds, outbound)
+		}
+	}
+	config.Outbounds = outbounds
+
+	selector := []string{"proxy-"}
+	config.Routing.Balancers = append(config.Routing.Balancers, BalancerConfig{
+		Tag:         tag,
+		Selector:    selector,
+		Strategy:    &BalancerStrategyConfig{Type: strategy},
+		FallbackTag: "proxy-0",
+	})
+	config = WithBalancerTag(config, tag)
+
+	probeURL := firstNonEmpty(opts.ProbeURL, DefaultTestURL)
+	probeInterval := firstNonEmpty(opts.ProbeInterval, "1m")
+	if strategy == BalancerLeastLoad {
+		config.BurstObservatory = &BurstObservatoryConfig{
+			SubjectSelector: selector,
+			PingConfig: &BurstPingConfig{
+				Destination: probeURL,
+				Interval:    probeInterval,
+				Sampling:    3,
+				Timeout:     "5s",
+			},
+		}
+	} else {
+		config.Observatory = &ObservatoryConfig{
+			SubjectSelector:   selector,
+			ProbeURL:          probeURL,
+			ProbeInterval:     probeInterval,
+			EnableConcurrency: true,
+		}
+	}
+	return config, nil
+}
+
+func buildBalancedOutbound(link Link) (OutboundConfig, error) {
+	if err := validateLink(link); err != nil {
+		return OutboundConfig{}, err
+	}
+	return buildOutbound(link)
+}
@@ -0,0 +1,171 @@
+package vpn
+
+import (
+	"net"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func balancerTestLinks() []Link {
+	return []Link{
+		{Protocol: "trojan", Address: "a.example.com", Port: 443, Password: "secret", Security: "tls"},
+		{Protocol: "vless", Address: "b.example.com", Port: 443, UUID: testUUID, Security: "reality", PublicKey: testRealityKey},
+		{Protocol: "shadowsocks", Address: "c.example.com", Port: 8388, Method: "aes-128-gcm", Password: "secret"},
+	}
+}
+
+func TestBuildBalancedXrayConfig(t *testing.T) {
+	config, err := BuildBalancedXrayConfig(balancerTestLinks(), BalancerOptions{ProbeInterval: "30s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tags, protocols []string
+	for _, outbound := range config.Outbounds {
+		tags = append(tags, outbound.Tag)
+		protocols = append(protocols, outbound.Protocol)
+	}
+	if want := []string{"proxy-0", "proxy-1", "proxy-2", "direct", "block"}; !reflect.DeepEqual(tags, want) {
+		t.Errorf("outbound tags = %v, want %v", tags, want)
+	}
+	if want := []string{"trojan", "vless", "shadowsocks", "freedom", "blackhole"}; !reflect.DeepEqual(protocols, want) {
+		t.Errorf("outbound protocols = %v, want %v", protocols, want)
+	}
+
+	wantBalancer := BalancerConfig{
+		Tag:         "proxy-balancer",
+		Selector:    []string{"proxy-"},
+		Strategy:    &BalancerStrategyConfig{Type: BalancerLeastPing},
+		FallbackTag: "proxy-0",
+	}
+	if len(config.Routing.Balancers) != 1 || !reflect.DeepEqual(config.Routing.Balancers[0], wantBalancer) {
+		t.Errorf("balancers = %+v", config.Routing.Balancers)
+	}
+	wantObservatory := &ObservatoryConfig{
+		SubjectSelector:   []string{"proxy-"},
+		ProbeURL:          DefaultTestURL,
+		ProbeInterval:     "30s",
+		EnableConcurrency: true,
+	}
+	if !reflect.DeepEqual(config.Observatory, wantObservatory) || config.BurstObservatory != nil {
+		t.Errorf("observatory = %+v, burst = %+v", config.Observatory, config.BurstObservatory)
+	}
+
+	for _, rule := range config.Routing.Rules {
+		if rule.Outbound == "proxy" {
+			t.Errorf("rule %+v still targets the proxy outbound", rule)
+		}
+		if rule.BalancerTag != "" && rule.BalancerTag != "proxy-balancer" {
+			t.Errorf("rule %+v targets balancer %s", rule, rule.BalancerTag)
+		}
+	}
+}
+
+func TestBuildBalancedXrayConfigLeastLoad(t *testing.T) {
+	config, err := BuildBalancedXrayConfig(balancerTestLinks(), BalancerOptions{
+		Tag:      "servers",
+		Strategy: BalancerLeastLoad,
+		ProbeURL: "https://example.com/204",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balancer := config.Routing.Balancers[0]; balancer.Tag != "servers" || balancer.Strategy.Type != BalancerLeastLoad {
+		t.Errorf("balancer = %+v", balancer)
+	}
+	wantBurst := &BurstObservatoryConfig{
+		SubjectSelector: []string{"proxy-"},
+		PingConfig: &BurstPingConfig{
+			Destination: "https://example.com/204",
+			Interval:    "1m",
+			Sampling:    3,
+			Timeout:     "5s",
+		},
+	}
+	if !reflect.DeepEqual(config.BurstObservatory, wantBurst) || config.Observatory != nil {
+		t.Errorf("burst observatory = %+v, observatory = %+v", config.BurstObservatory, config.Observatory)
+	}
+}
+
+func TestBuildBalancedXrayConfigErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		links []Link
+		opts  BalancerOptions
+		want  string
+	}{
+		{"no links", nil, BalancerOptions{}, "no links to balance"},
+		{"unknown strategy", balancerTestLinks(), BalancerOptions{Strategy: "fastest"}, `unsupported balancer strategy "fastest"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := BuildBalancedXrayConfig(tt.links, tt.opts)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildBalancedXrayConfigSkipsUnsupportedLinks(t *testing.T) {
+	links := []Link{
+		formatTestLinks["hysteria2"],
+		formatTestLinks["vless reality"],
+		{Protocol: "vless", Address: "example.com"},
+		formatTestLinks["trojan tls"],
+	}
+	config, err := BuildBalancedXrayConfig(links, BalancerOptions{})
+	if err != nil {
+		t.Fatalf("BuildBalancedXrayConfig: %v", err)
+	}
+
+	var proxies []string
+	for _, outbound := range config.Outbounds {
+		if strings.HasPrefix(outbound.Tag, "proxy-") {
+			proxies = append(proxies, outbound.Tag)
+		}
+	}
+	if !slices.Equal(proxies, []string{"proxy-0", "proxy-1"}) {
+		t.Errorf("proxy outbounds = %v, want [proxy-0 proxy-1]", proxies)
+	}
+	if protocol := config.Outbounds[0].Protocol; protocol != "vless" {
+		t.Errorf("proxy-0 protocol = %s, want vless", protocol)
+	}
+	if fallback := config.Routing.Balancers[0].FallbackTag; fallback != "proxy-0" {
+		t.Errorf("fallback tag = %s, want proxy-0", fallback)
+	}
+
+	if _, err := BuildBalancedXrayConfig(links[:1], BalancerOptions{}); err == nil {
+		t.Error("BuildBalancedXrayConfig succeeded without a runnable link")
+	}
+}
+
+func TestBalancedRoutingCatchesUnmatchedTraffic(t *testing.T) {
+	config, err := BuildBalancedXrayConfig([]Link{formatTestLinks["vless reality"]}, BalancerOptions{})
+	if err != nil {
+		t.Fatalf("BuildBalancedXrayConfig: %v", err)
+	}
+	for _, req := range []RouteRequest{
+		{Domain: "discord.com", Port: 443, Network: "tcp"},
+		{Domain: "example.org", Port: 443, Network: "tcp"},
+		{IP: net.ParseIP("8.8.8.8"), Port: 53, Network: "udp"},
+	} {
+		match, err := ExplainRoute(config.Routing, req, nil)
+		if err != nil {
+			t.Fatalf("ExplainRoute(%s): %v", req, err)
+		}
+		if match.Balancer != DefaultBalancerTag {
+			t.Errorf("%s goes to outbound %q balancer %q, want balancer %s", req, match.Outbound, match.Balancer, DefaultBalancerTag)
+		}
+	}
+
+	match, err := ExplainRoute(config.Routing, RouteRequest{IP: net.ParseIP("192.168.1.1"), Port: 80, Network: "tcp"}, nil)
+	if err != nil {
+		t.Fatalf("ExplainRoute: %v", err)
+	}
+	if match.Outbound != "direct" {
+		t.Errorf("192.168.1.1 goes to %q, want direct", match.Outbound)
+	}
+}
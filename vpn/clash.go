@@ -0,0 +1,312 @@
+package vpn
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var clashProxiesKey = regexp.MustCompile(`(?m)^proxies:`)
+
+type clashConfig struct {
+	Proxies     []yaml.Node       `yaml:"proxies"`
+	ProxyGroups []clashProxyGroup `yaml:"proxy-groups"`
+}
+
+type clashProxyGroup struct {
+	Name    string   `yaml:"name"`
+	Type    string   `yaml:"type"`
+	Proxies []string `yaml:"proxies"`
+}
+
+type clashProxy struct {
+	Name              string            `yaml:"name"`
+	Type              string            `yaml:"type"`
+	Server            string            `yaml:"server"`
+	Port              clashPort         `yaml:"port"`
+	UUID              string            `yaml:"uuid"`
+	AlterID           clashPort         `yaml:"alterId"`
+	Password          string            `yaml:"password"`
+	Cipher            string            `yaml:"cipher"`
+	Flow              string            `yaml:"flow"`
+	TLS               bool              `yaml:"tls"`
+	SkipCertVerify    bool              `yaml:"skip-cert-verify"`
+	ServerName        string            `yaml:"servername"`
+	SNI               string            `yaml:"sni"`
+	ALPN              []string          `yaml:"alpn"`
+	ClientFingerprint string            `yaml:"client-fingerprint"`
+	Network           string            `yaml:"network"`
+	WSOpts            clashWSOpts       `yaml:"ws-opts"`
+	GRPCOpts          clashGRPCOpts     `yaml:"grpc-opts"`
+	H2Opts            clashH2Opts       `yaml:"h2-opts"`
+	RealityOpts       *clashRealityOpts `yaml:"reality-opts"`
+	Plugin            string            `yaml:"plugin"`
+	PluginOpts        clashPluginOpts   `yaml:"plugin-opts"`
+	Up                string            `yaml:"up"`
+	Down              string            `yaml:"down"`
+	Obfs              string            `yaml:"obfs"`
+	ObfsPassword      string            `yaml:"obfs-password"`
+}
+
+type clashWSOpts struct {
+	Path    string            `yaml:"path"`
+	Headers map[string]string `yaml:"headers"`
+}
+
+type clashGRPCOpts struct {
+	ServiceName string `yaml:"grpc-service-name"`
+}
+
+type clashH2Opts struct {
+	Host []string `yaml:"host"`
+	Path string   `yaml:"path"`
+}
+
+type clashRealityOpts struct {
+	PublicKey string `yaml:"public-key"`
+	ShortID   string `yaml:"short-id"`
+}
+
+// clashPluginOpts holds plugin-opts as written, since providers mix strings,
+// booleans and nested maps such as v2ray-plugin headers.
+type clashPluginOpts map[string]interface{}
+
+// get returns the option as a SIP003 value. Nested maps and lists have no
+// SIP003 form and read as empty.
+func (o clashPluginOpts) get(key string) string {
+	value, ok := o[key]
+	if !ok || value == nil {
+		return ""
+	}
+	switch reflect.TypeOf(value).Kind() {
+	case reflect.Map, reflect.Slice:
+		return ""
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// clashPort accepts both numbers and quoted strings, as providers emit both.
+type clashPort int
+
+func (p *clashPort) UnmarshalYAML(node *yaml.Node) error {
+	value, err := strconv.Atoi(strings.TrimSpace(node.Value))
+	if err != nil {
+		return fmt.Errorf("invalid number %q", node.Value)
+	}
+	*p = clashPort(value)
+	return nil
+}
+
+func isClashConfig(text string) bool {
+	return clashProxiesKey.MatchString(text)
+}
+
+// ParseClashConfig converts the proxies of a Clash or Mihomo config into
+// links. Proxies that cannot be converted are reported in Errors, and
+// proxy-groups are returned as Groups referencing links by name.
+func ParseClashConfig(data []byte) (ImportResult, error) {
+	var config clashConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return ImportResult{}, fmt.Errorf("unmarshal clash config: %w", err)
+	}
+	if len(config.Proxies) == 0 {
+		return ImportResult{}, errors.New("no proxies in clash config")
+	}
+
+	result := ImportResult{
+		Links:  make([]Link, 0, len(config.Proxies)),
+		Errors: []error{},
+	}
+	for idx := range config.Proxies {
+		node := &config.Proxies[idx]
+		var proxy clashProxy
+		if err := node.Decode(&proxy); err != nil {
+			result.Errors = append(result.Errors, fmt.Errorf("proxy %d (line %d): %w", idx+1, node.Line, err))
+			continue
+		}
+		link, err := proxy.toLink()
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Errorf("proxy %q (line %d): %w", proxy.Name, node.Line, err))
+			continue
+		}
+		result.Links = append(result.Links, link)
+	}
+
+	for _, group := range config.ProxyGroups {
+		result.Groups = append(result.Groups, ImportGroup{
+			Name:    group.Name,
+			Type:    group.Type,
+			Members: group.Proxies,
+		})
+	}
+	return result, nil
+}
+
+func (p clashProxy) toLink() (Link, error) {
+	if p.Server == "" || p.Port == 0 {
+		return Link{}, errors.New("missing server or port")
+	}
+	link := Link{
+		Name:          p.Name,
+		Address:       p.Server,
+		Port:          int(p.Port),
+		SNI:           firstNonEmpty(p.ServerName, p.SNI),
+		ALPN:          p.ALPN,
+		Fingerprint:   p.ClientFingerprint,
+		AllowInsecure: p.SkipCertVerify,
+	}
+	if p.TLS {
+		link.Security = "tls"
+	}
+	if p.RealityOpts != nil {
+		link.Security = "reality"
+		link.PublicKey = p.RealityOpts.PublicKey
+		link.ShortID = p.RealityOpts.ShortID
+	}
+
+	switch p.Type {
+	case "vless":
+		link.Protocol = "vless"
+		link.UUID = p.UUID
+		link.Flow = p.Flow
+	case "vmess":
+		link.Protocol = "vmess"
+		link.UUID = p.UUID
+		link.AlterID = int(p.AlterID)
+		link.Cipher = p.Cipher
+	case "trojan":
+		link.Protocol = "trojan"
+		link.Password = p.Password
+		link.Security = firstNonEmpty(link.Security, "tls")
+	case "ss":
+		return p.toShadowsocksLink(link)
+	case "hysteria2":
+		return p.toHysteria2Link(link)
+	default:
+		return Link{}, fmt.Errorf("%w: %q", ErrUnsupportedProtocol, p.Type)
+	}
+
+	if err := p.applyNetwork(&link); err != nil {
+		return Link{}, err
+	}
+	if err := validateRealityParams(link); err != nil {
+		return Link{}, err
+	}
+	return link, nil
+}
+
+func (p clashProxy) applyNetwork(link *Link) error {
+	link.Transport = firstNonEmpty(p.Network, "tcp")
+	switch link.Transport {
+	case "tcp":
+	case "ws":
+		link.Path = p.WSOpts.Path
+		link.Host = headerValue(p.WSOpts.Headers, "Host")
+	case "grpc":
+		link.ServiceName = p.GRPCOpts.ServiceName
+	case "h2":
+		link.Host = strings.Join(p.H2Opts.Host, ",")
+		link.Path = p.H2Opts.Path
+	default:
+		return fmt.Errorf("unsupported network %q", p.Network)
+	}
+	return nil
+}
+
+func (p clashProxy) toShadowsocksLink(link Link) (Link, error) {
+	link.Protocol = "shadowsocks"
+	link.Method = strings.ToLower(p.Cipher)
+	link.Password = p.Password
+	link.Transport = "tcp"
+	link.Security = ""
+	link.SNI = ""
+	if link.Method == "" || link.Password == "" {
+		return Link{}, errors.New("missing shadowsocks method or password")
+	}
+	if err := validateShadowsocksKey(link.Method, link.Password); err != nil {
+		return Link{}, err
+	}
+
+	switch p.Plugin {
+	case "":
+	case "obfs":
+		// Clash calls simple-obfs "obfs" and renames its options.
+		link.Plugin = "obfs-local"
+		link.PluginOpts = formatPluginOpts(map[string]string{
+			"obfs":      p.PluginOpts.get("mode"),
+			"obfs-host": p.PluginOpts.get("host"),
+		})
+	case "v2ray-plugin":
+		link.Plugin = p.Plugin
+		opts := map[string]string{
+			"mode": p.PluginOpts.get("mode"),
+			"host": p.PluginOpts.get("host"),
+			"path": p.PluginOpts.get("path"),
+		}
+		if parseBool(p.PluginOpts.get("tls")) {
+			opts["tls"] = ""
+		}
+		link.PluginOpts = formatPluginOpts(opts)
+	default:
+		link.Plugin = p.Plugin
+		opts := make(map[string]string, len(p.PluginOpts))
+		for key := range p.PluginOpts {
+			opts[key] = p.PluginOpts.get(key)
+		}
+		link.PluginOpts = formatPluginOpts(opts)
+	}
+	return link, nil
+}
+
+func (p clashProxy) toHysteria2Link(link Link) (Link, error) {
+	link.Protocol = "hysteria2"
+	link.Password = p.Password
+	link.Security = "tls"
+	link.Obfs = p.Obfs
+	link.ObfsPassword = p.ObfsPassword
+	var err error
+	if link.UpMbps, err = parseMbps(p.Up); err != nil {
+		return Link{}, fmt.Errorf("parse up bandwidth: %w", err)
+	}
+	if link.DownMbps, err = parseMbps(p.Down); err != nil {
+		return Link{}, fmt.Errorf("parse down bandwidth: %w", err)
+	}
+	return link, nil
+}
+
+// formatPluginOpts renders SIP003 plugin options, sorted for stable output.
+// Empty values are dropped unless the key is a flag such as "tls".
+func formatPluginOpts(opts map[string]string) string {
+	keys := make([]string, 0, len(opts))
+	for key := range opts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		switch value := opts[key]; {
+		case value != "":
+			parts = append(parts, key+"="+value)
+		case key == "tls":
+			parts = append(parts, key)
+		}
+	}
+	return strings.Join(parts, ";")
+}
+
+func headerValue(headers map[string]string, name string) string {
+	for key, value := range headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,258 @@
+package vpn
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testClashConfig = `
+port: 7890
+proxies:
+  - name: ss-obfs
+    type: ss
+    server: ss.example.com
+    port: 8388
+    cipher: AES-128-GCM
+    password: secret
+    plugin: obfs
+    plugin-opts:
+      mode: tls
+      host: bing.com
+  - name: ss-v2ray
+    type: ss
+    server: ss.example.com
+    port: "443"
+    cipher: chacha20-ietf-poly1305
+    password: secret
+    plugin: v2ray-plugin
+    plugin-opts:
+      mode: websocket
+      host: cdn.example.com
+      path: /ss
+      tls: true
+      mux: true
+      headers:
+        custom: value
+  - name: vmess-ws
+    type: vmess
+    server: vmess.example.com
+    port: 443
+    uuid: ` + testUUID + `
+    alterId: 0
+    cipher: auto
+    tls: true
+    servername: vmess.example.com
+    skip-cert-verify: true
+    network: ws
+    ws-opts:
+      path: /ws
+      headers:
+        host: cdn.example.com
+  - name: vless-grpc-reality
+    type: vless
+    server: vless.example.com
+    port: 443
+    uuid: ` + testUUID + `
+    flow: xtls-rprx-vision
+    servername: www.microsoft.com
+    client-fingerprint: chrome
+    network: grpc
+    grpc-opts:
+      grpc-service-name: svc
+    reality-opts:
+      public-key: ` + testRealityKey + `
+      short-id: 6ba85179e30d4fc2
+  - name: vless-ws
+    type: vless
+    server: vless.example.com
+    port: 8443
+    uuid: ` + testUUID + `
+    tls: true
+    alpn: [h2, http/1.1]
+    network: ws
+    ws-opts:
+      path: /vless
+  - name: trojan
+    type: trojan
+    server: trojan.example.com
+    port: 443
+    password: p@ss
+    sni: trojan.example.com
+  - name: vmess-grpc
+    type: vmess
+    server: vmess.example.com
+    port: 443
+    uuid: ` + testUUID + `
+    alterId: 0
+    cipher: aes-128-gcm
+    tls: true
+    network: grpc
+    grpc-opts:
+      grpc-service-name: vmess-svc
+  - name: ss-kcptun
+    type: ss
+    server: ss.example.com
+    port: 8388
+    cipher: aes-256-gcm
+    password: secret
+    plugin: kcptun
+    plugin-opts:
+      crypt: aes
+      nocomp: true
+      sndwnd: 1024
+      headers: {a: b}
+  - name: hysteria2
+    type: hysteria2
+    server: hy2.example.com
+    port: 8443
+    password: secret
+    up: 30 Mbps
+    down: 200
+    obfs: salamander
+    obfs-password: obfs
+    sni: hy2.example.com
+    skip-cert-verify: true
+  - name: no-server
+    type: trojan
+    port: 443
+    password: secret
+  - name: bad-port
+    type: trojan
+    server: trojan.example.com
+    port: https
+    password: secret
+  - name: snell
+    type: snell
+    server: snell.example.com
+    port: 443
+proxy-groups:
+  - name: auto
+    type: url-test
+    proxies: [vless-ws, trojan]
+`
+
+func TestParseClashConfig(t *testing.T) {
+	result, err := ParseClashConfig([]byte(testClashConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Link{
+		{
+			Protocol: "shadowsocks", Name: "ss-obfs", Address: "ss.example.com", Port: 8388,
+			Method: "aes-128-gcm", Password: "secret", Transport: "tcp",
+			Plugin: "obfs-local", PluginOpts: "obfs=tls;obfs-host=bing.com",
+		},
+		{
+			Protocol: "shadowsocks", Name: "ss-v2ray", Address: "ss.example.com", Port: 443,
+			Method: "chacha20-ietf-poly1305", Password: "secret", Transport: "tcp",
+			Plugin: "v2ray-plugin", PluginOpts: "host=cdn.example.com;mode=websocket;path=/ss;tls",
+		},
+		{
+			Protocol: "vmess", Name: "vmess-ws", Address: "vmess.example.com", Port: 443,
+			UUID: testUUID, Cipher: "auto", Security: "tls", SNI: "vmess.example.com",
+			AllowInsecure: true, Transport: "ws", Path: "/ws", Host: "cdn.example.com",
+		},
+		{
+			Protocol: "vless", Name: "vless-grpc-reality", Address: "vless.example.com", Port: 443,
+			UUID: testUUID, Flow: "xtls-rprx-vision", Security: "reality", SNI: "www.microsoft.com",
+			Fingerprint: "chrome", PublicKey: testRealityKey, ShortID: "6ba85179e30d4fc2",
+			Transport: "grpc", ServiceName: "svc",
+		},
+		{
+			Protocol: "vless", Name: "vless-ws", Address: "vless.example.com", Port: 8443,
+			UUID: testUUID, Security: "tls", ALPN: []string{"h2", "http/1.1"}, Transport: "ws", Path: "/vless",
+		},
+		{
+			Protocol: "trojan", Name: "trojan", Address: "trojan.example.com", Port: 443,
+			Password: "p@ss", Security: "tls", SNI: "trojan.example.com", Transport: "tcp",
+		},
+		{
+			Protocol: "vmess", Name: "vmess-grpc", Address: "vmess.example.com", Port: 443,
+			UUID: testUUID, Cipher: "aes-128-gcm", Security: "tls", Transport: "grpc", ServiceName: "vmess-svc",
+		},
+		{
+			Protocol: "shadowsocks", Name: "ss-kcptun", Address: "ss.example.com", Port: 8388,
+			Method: "aes-256-gcm", Password: "secret", Transport: "tcp",
+			Plugin: "kcptun", PluginOpts: "crypt=aes;nocomp=true;sndwnd=1024",
+		},
+		{
+			Protocol: "hysteria2", Name: "hysteria2", Address: "hy2.example.com", Port: 8443,
+			Password: "secret", Security: "tls", SNI: "hy2.example.com", AllowInsecure: true,
+			Obfs: "salamander", ObfsPassword: "obfs", UpMbps: 30, DownMbps: 200,
+		},
+	}
+	if len(result.Links) != len(want) {
+		t.Fatalf("got %d links, want %d: %+v", len(result.Links), len(want), result.Links)
+	}
+	for idx := range want {
+		if !reflect.DeepEqual(result.Links[idx], want[idx]) {
+			t.Errorf("link %d = %+v\nwant %+v", idx, result.Links[idx], want[idx])
+		}
+	}
+
+	// Bad proxies are reported without aborting the import.
+	wantErrors := []string{`proxy "no-server"`, "proxy 11 (line", `proxy "snell"`}
+	if len(result.Errors) != len(wantErrors) {
+		t.Fatalf("got errors %v, want %d", result.Errors, len(wantErrors))
+	}
+	for idx, want := range wantErrors {
+		if !strings.Contains(result.Errors[idx].Error(), want) {
+			t.Errorf("error %d = %v, want %q", idx, result.Errors[idx], want)
+		}
+	}
+	if !errors.Is(result.Errors[2], ErrUnsupportedProtocol) {
+		t.Errorf("snell error = %v, want ErrUnsupportedProtocol", result.Errors[2])
+	}
+
+	wantGroups := []ImportGroup{{Name: "auto", Type: "url-test", Members: []string{"vless-ws", "trojan"}}}
+	if !reflect.DeepEqual(result.Groups, wantGroups) {
+		t.Errorf("groups = %+v, want %+v", result.Groups, wantGroups)
+	}
+}
+
+func TestParseLinksFromTextDetectsClash(t *testing.T) {
+	result := ParseLinksFromText(testClashConfig)
+	if len(result.Links) != 9 || len(result.Errors) != 3 || len(result.Groups) != 1 {
+		t.Errorf("got %d links, %d errors and %d groups", len(result.Links), len(result.Errors), len(result.Groups))
+	}
+}
+
+func TestParseClashConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"invalid yaml", "proxies: [", "unmarshal clash config"},
+		{"no proxies", "proxies: []\n", "no proxies in clash config"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseClashConfig([]byte(tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+
+	result, err := ParseClashConfig([]byte(`proxies:
+  - {name: bad-psk, type: ss, server: example.com, port: 8388, cipher: 2022-blake3-aes-128-gcm, password: c2hvcnQ=}
+  - {name: bad-reality, type: vless, server: example.com, port: 443, uuid: ` + testUUID + `, reality-opts: {public-key: abc}}
+  - {name: bad-network, type: vless, server: example.com, port: 443, uuid: ` + testUUID + `, network: kcp}
+  - {name: bad-bandwidth, type: hysteria2, server: example.com, port: 443, password: secret, up: fast}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Links) != 0 || len(result.Errors) != 4 {
+		t.Fatalf("got %d links and errors %v", len(result.Links), result.Errors)
+	}
+	for idx, want := range []string{"expected 16 bytes", "reality public key", `unsupported network "kcp"`, `invalid bandwidth "fast"`} {
+		if !strings.Contains(result.Errors[idx].Error(), want) {
+			t.Errorf("error %d = %v, want %q", idx, result.Errors[idx], want)
+		}
+	}
+}
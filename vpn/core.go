@@ -0,0 +1,108 @@
+package vpn
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const (
+	CoreXray    = "xray"
+	CoreSingBox = "sing-box"
+)
+
+type CoreOptions struct {
+	Inbound InboundOptions
+	// DNS is only applied to Xray; sing-box keeps the system resolver.
+	DNS *DNSConfig
+	// Routing replaces DefaultRouting.
+	Routing *RoutingConfig
+}
+
+// BuildCoreConfig builds the config of the given core for link.
+func BuildCoreConfig(core string, link Link, opts CoreOptions) (interface{}, error) {
+	switch core {
+	case "", CoreXray:
+		config, err := BuildXrayConfig(link)
+		if err != nil {
+			return nil, err
+		}
+		config.Inbounds = XrayInbounds(opts.Inbound)
+		if opts.Routing != nil {
+			config.Routing = cloneRouting(opts.Routing)
+		}
+		return WithDNS(config, opts.DNS), nil
+	case CoreSingBox:
+		config, err := BuildSingBoxConfig(link, opts.Routing)
+		if err != nil {
+			return nil, err
+		}
+		config.Inbounds = SingBoxInbounds(opts.Inbound)
+		return WithSingBoxSniffing(config, opts.Inbound), nil
+	default:
+		return nil, fmt.Errorf("unsupported core %q", core)
+	}
+}
+
+// WriteConfig marshals a core config to path, creating parent directories.
+func WriteConfig(path string, config interface{}) error {
+	raw, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal core config: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("create core config dir: %w", err)
+	}
+	return os.WriteFile(path, raw, 0644)
+}
+
+// CoreArgs returns the command line used to run a core with a config file.
+func CoreArgs(configPath string) []string {
+	return []string{"run", "-c", configPath}
+}
+
+// LocalSocksPort returns the port of the first SOCKS inbound of config.
+func LocalSocksPort(config XrayConfig) (int, error) {
+	for _, inbound := range config.Inbounds {
+		if inbound.Protocol == "socks" {
+			return inbound.Port, nil
+		}
+	}
+	return 0, fmt.Errorf("no socks inbound in config")
+}
+
+// FreePort asks the OS for an unused local TCP port.
+func FreePort() (int, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, fmt.Errorf("find free port: %w", err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
+
+// WaitForPort blocks until a TCP listener accepts connections on host:port
+// or ctx is done. An empty host means 127.0.0.1.
+func WaitForPort(ctx context.Context, host string, port int) error {
+	address := net.JoinHostPort(firstNonEmpty(host, "127.0.0.1"), strconv.Itoa(port))
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	dialer := net.Dialer{Timeout: time.Second}
+	for {
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		if err == nil {
+			return conn.Close()
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("wait for %s: %w", address, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package vpn
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWriteConfig(t *testing.T) {
+	config, err := BuildXrayConfig(Link{Protocol: "trojan", Address: "example.com", Port: 443, Password: "secret", Security: "tls"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "xray", "config.json")
+	if err := WriteConfig(path, config); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written XrayConfig
+	if err := json.Unmarshal(raw, &written); err != nil {
+		t.Fatal(err)
+	}
+	if len(written.Outbounds) != 3 || written.Outbounds[0].Protocol != "trojan" || written.Outbounds[1].Tag != OutboundDirect ||
+		written.Outbounds[2].Tag != OutboundBlock {
+		t.Errorf("written outbounds = %+v", written.Outbounds)
+	}
+
+	port, err := LocalSocksPort(written)
+	if err != nil || port != 10808 {
+		t.Errorf("LocalSocksPort = %d, %v", port, err)
+	}
+	if _, err := LocalSocksPort(XrayConfig{}); err == nil {
+		t.Error("LocalSocksPort found a port in an empty config")
+	}
+}
+
+func TestCoreArgs(t *testing.T) {
+	if got, want := CoreArgs("config.json"), []string{"run", "-c", "config.json"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("CoreArgs = %v, want %v", got, want)
+	}
+}
+
+func TestWaitForPort(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := WaitForPort(ctx, "", port); err != nil {
+		t.Fatalf("WaitForPort on a listener: %v", err)
+	}
+	if err := WaitForPort(ctx, "127.0.0.1", port); err != nil {
+		t.Fatalf("WaitForPort on 127.0.0.1: %v", err)
+	}
+
+	listener.Close()
+	ctx, cancel = context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	if err := WaitForPort(ctx, "", port); err == nil {
+		t.Fatal("WaitForPort succeeded on a closed port")
+	}
+}
@@ -0,0 +1,228 @@
+package vpn
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	DNSPresetNone       = "none"
+	DNSPresetCloudflare = "cloudflare"
+	DNSPresetGoogle     = "google"
+	DNSPresetQuad9      = "quad9"
+
+	// DNSTag tags the queries of the Xray DNS module so that routing can
+	// send them through the proxy.
+	DNSTag = "dns-internal"
+)
+
+// LocalDomains are resolved by the system resolver in every preset.
+var LocalDomains = []string{
+	"domain:lan",
+	"domain:local",
+	"domain:localhost",
+	"domain:home.arpa",
+}
+
+var dnsPresets = map[string][]string{
+	DNSPresetCloudflare: {"https://1.1.1.1/dns-query", "https://1.0.0.1/dns-query"},
+	DNSPresetGoogle:     {"https://8.8.8.8/dns-query", "https://8.8.4.4/dns-query"},
+	DNSPresetQuad9:      {"https://9.9.9.9/dns-query", "https://149.112.112.112/dns-query"},
+}
+
+type DNSConfig struct {
+	Servers         []DNSServer         `json:"servers"`
+	Hosts           map[string][]string `json:"hosts,omitempty"`
+	QueryStrategy   string              `json:"queryStrategy,omitempty"`
+	DisableFallback bool                `json:"disableFallback,omitempty"`
+	Tag             string              `json:"tag,omitempty"`
+}
+
+type DNSServer struct {
+	Address      string   `json:"address"`
+	Port         int      `json:"port,omitempty"`
+	Domains      []string `json:"domains,omitempty"`
+	SkipFallback bool     `json:"skipFallback,omitempty"`
+}
+
+// MarshalJSON writes servers without options as a plain address, like
+// hand-written Xray configs do.
+func (s DNSServer) MarshalJSON() ([]byte, error) {
+	if s.Port == 0 && len(s.Domains) == 0 && !s.SkipFallback {
+		return json.Marshal(s.Address)
+	}
+	type plain DNSServer
+	return json.Marshal(plain(s))
+}
+
+// UnmarshalJSON accepts a plain address or a server object.
+func (s *DNSServer) UnmarshalJSON(data []byte) error {
+	var address string
+	if err := json.Unmarshal(data, &address); err == nil {
+		*s = DNSServer{Address: address}
+		return nil
+	}
+	type plain DNSServer
+	var server plain
+	if err := json.Unmarshal(data, &server); err != nil {
+		return err
+	}
+	*s = DNSServer(server)
+	return nil
+}
+
+type DNSOptions struct {
+	// Preset is one of the DNSPreset* constants; an empty preset without
+	// Servers disables the DNS section.
+	Preset string
+	// Servers replace the servers of the preset.
+	Servers []DNSServerOptions
+	// QueryStrategy is "ipv4", "ipv6" or "dual", or the Xray names
+	// UseIPv4, UseIPv6 and UseIP.
+	QueryStrategy string
+	Hosts         map[string]string
+}
+
+type DNSServerOptions struct {
+	// Address is an Xray DNS URL such as https://1.1.1.1/dns-query, a plain
+	// IP with an optional port, or "localhost" for the system resolver.
+	Address string
+	// Domains restricts the server to these domain matchers.
+	Domains []string
+}
+
+// BuildDNSConfig builds the Xray DNS section for opts, nil when DNS is left
+// to the system. Discord domains are assigned to the first preset server
+// and local domains to the system resolver.
+func BuildDNSConfig(opts DNSOptions) (*DNSConfig, error) {
+	preset := strings.ToLower(strings.TrimSpace(opts.Preset))
+	if (preset == "" || preset == DNSPresetNone) && len(opts.Servers) == 0 {
+		return nil, nil
+	}
+
+	var servers []DNSServer
+	if len(opts.Servers) > 0 {
+		for _, serverOpts := range opts.Servers {
+			server, err := parseDNSServer(serverOpts.Address)
+			if err != nil {
+				return nil, err
+			}
+			server.Domains = serverOpts.Domains
+			servers = append(servers, server)
+		}
+	} else {
+		addresses, ok := dnsPresets[preset]
+		if !ok {
+			return nil, fmt.Errorf("unknown dns preset %q", opts.Preset)
+		}
+		servers = append(servers, DNSServer{Address: addresses[0], Domains: DiscordDomains})
+		for _, address := range addresses {
+			servers = append(servers, DNSServer{Address: address})
+		}
+	}
+	servers = append(servers, DNSServer{Address: "localhost", Domains: LocalDomains, SkipFallback: true})
+
+	strategy, err := dnsQueryStrategy(opts.QueryStrategy)
+	if err != nil {
+		return nil, err
+	}
+	config := &DNSConfig{
+		Servers:       servers,
+		QueryStrategy: strategy,
+		Tag:           DNSTag,
+	}
+
+	for domain, addresses := range opts.Hosts {
+		if config.Hosts == nil {
+			config.Hosts = map[string][]string{}
+		}
+		config.Hosts[domain] = splitCSV(addresses)
+	}
+	return config, nil
+}
+
+func parseDNSServer(address string) (DNSServer, error) {
+	address = strings.TrimSpace(address)
+	scheme, _, hasScheme := strings.Cut(address, "://")
+	switch {
+	case address == "":
+		return DNSServer{}, errors.New("missing dns server address")
+	case address == "localhost" || address == "fakedns":
+		return DNSServer{Address: address}, nil
+	case hasScheme:
+		switch scheme {
+		case "https", "https+local", "tcp", "tcp+local", "quic+local":
+			return DNSServer{Address: address}, nil
+		case "tls":
+			return DNSServer{}, fmt.Errorf("dns server %q: DoT is not supported by xray, use DoH", address)
+		default:
+			return DNSServer{}, fmt.Errorf("dns server %q: unsupported scheme %q", address, scheme)
+		}
+	}
+
+	if ip := net.ParseIP(address); ip != nil {
+		return DNSServer{Address: address}, nil
+	}
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil || net.ParseIP(host) == nil {
+		return DNSServer{}, fmt.Errorf("dns server %q: expected an IP address or URL", address)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return DNSServer{}, fmt.Errorf("dns server %q: invalid port", address)
+	}
+	return DNSServer{Address: host, Port: port}, nil
+}
+
+func dnsQueryStrategy(value string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "":
+		return "", nil
+	case "ipv4", "useipv4":
+		return "UseIPv4", nil
+	case "ipv6", "useipv6":
+		return "UseIPv6", nil
+	case "dual", "useip":
+		return "UseIP", nil
+	default:
+		return "", fmt.Errorf("unsupported dns query strategy %q", value)
+	}
+}
+
+// WithDNS sets the DNS section of config and routes the queries of the DNS
+// module like proxied traffic, so that they do not leak to the local network.
+// With the AsIs routing strategy only the direct outbound resolves domains,
+// so it is switched to the DNS module following the query strategy.
+func WithDNS(config XrayConfig, dns *DNSConfig) XrayConfig {
+	config.DNS = dns
+	if dns == nil {
+		return config
+	}
+	outbounds := make([]OutboundConfig, len(config.Outbounds))
+	for idx, outbound := range config.Outbounds {
+		if settings, ok := outbound.Settings.(FreedomSettings); ok && outbound.Tag == OutboundDirect && settings.DomainStrategy == "" {
+			settings.DomainStrategy = firstNonEmpty(dns.QueryStrategy, "UseIP")
+			outbound.Settings = settings
+		}
+		outbounds[idx] = outbound
+	}
+	config.Outbounds = outbounds
+	if dns.Tag == "" {
+		return config
+	}
+	if config.Routing == nil {
+		config.Routing = DefaultRouting()
+	}
+
+	rule := RoutingRule{Type: "field", InboundTag: []string{dns.Tag}, Outbound: "proxy"}
+	if len(config.Routing.Balancers) > 0 {
+		rule.Outbound = ""
+		rule.BalancerTag = config.Routing.Balancers[0].Tag
+	}
+	config.Routing.Rules = append([]RoutingRule{rule}, config.Routing.Rules...)
+	return config
+}
@@ -0,0 +1,202 @@
+package vpn
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuildDNSConfig(t *testing.T) {
+	local := DNSServer{Address: "localhost", Domains: LocalDomains, SkipFallback: true}
+	for _, test := range []struct {
+		name string
+		opts DNSOptions
+		want *DNSConfig
+	}{
+		{"system", DNSOptions{}, nil},
+		{"none", DNSOptions{Preset: "None", QueryStrategy: "ipv4"}, nil},
+		{
+			name: "preset",
+			opts: DNSOptions{Preset: " Cloudflare ", QueryStrategy: "ipv4", Hosts: map[string]string{"example.com": "1.2.3.4, 5.6.7.8"}},
+			want: &DNSConfig{
+				Servers: []DNSServer{
+					{Address: "https://1.1.1.1/dns-query", Domains: DiscordDomains},
+					{Address: "https://1.1.1.1/dns-query"},
+					{Address: "https://1.0.0.1/dns-query"},
+					local,
+				},
+				Hosts:         map[string][]string{"example.com": {"1.2.3.4", "5.6.7.8"}},
+				QueryStrategy: "UseIPv4",
+				Tag:           DNSTag,
+			},
+		},
+		{
+			name: "servers",
+			opts: DNSOptions{Preset: DNSPresetGoogle, QueryStrategy: "UseIP", Servers: []DNSServerOptions{
+				{Address: "https://dns.example.com/dns-query", Domains: []string{"domain:example.com"}},
+				{Address: "9.9.9.9"},
+				{Address: "[2620:fe::fe]:5353"},
+				{Address: "localhost"},
+			}},
+			want: &DNSConfig{
+				Servers: []DNSServer{
+					{Address: "https://dns.example.com/dns-query", Domains: []string{"domain:example.com"}},
+					{Address: "9.9.9.9"},
+					{Address: "2620:fe::fe", Port: 5353},
+					{Address: "localhost"},
+					local,
+				},
+				QueryStrategy: "UseIP",
+				Tag:           DNSTag,
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := BuildDNSConfig(test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("config = %+v\nwant %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestBuildDNSConfigErrors(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		opts DNSOptions
+		want string
+	}{
+		{"preset", DNSOptions{Preset: "opendns"}, `unknown dns preset "opendns"`},
+		{"strategy", DNSOptions{Preset: DNSPresetQuad9, QueryStrategy: "ipv5"}, `unsupported dns query strategy "ipv5"`},
+		{"empty", DNSOptions{Servers: []DNSServerOptions{{Address: " "}}}, "missing dns server address"},
+		{"dot", DNSOptions{Servers: []DNSServerOptions{{Address: "tls://1.1.1.1"}}}, "DoT is not supported"},
+		{"scheme", DNSOptions{Servers: []DNSServerOptions{{Address: "udp://1.1.1.1"}}}, `unsupported scheme "udp"`},
+		{"hostname", DNSOptions{Servers: []DNSServerOptions{{Address: "dns.google"}}}, "expected an IP address or URL"},
+		{"port", DNSOptions{Servers: []DNSServerOptions{{Address: "1.1.1.1:dns"}}}, "invalid port"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := BuildDNSConfig(test.opts)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got error %v, want %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestDNSServerJSON(t *testing.T) {
+	servers := []DNSServer{
+		{Address: "https://1.1.1.1/dns-query"},
+		{Address: "2620:fe::fe", Port: 5353, Domains: []string{"domain:example.com"}, SkipFallback: true},
+	}
+	data, err := json.Marshal(servers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `["https://1.1.1.1/dns-query",{"address":"2620:fe::fe","port":5353,"domains":["domain:example.com"],"skipFallback":true}]`
+	if string(data) != want {
+		t.Errorf("got %s, want %s", data, want)
+	}
+
+	var decoded []DNSServer
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, servers) {
+		t.Errorf("decoded = %+v, want %+v", decoded, servers)
+	}
+}
+
+func TestWithDNS(t *testing.T) {
+	config, err := BuildXrayConfig(Link{Protocol: "trojan", Address: "example.com", Port: 443, Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := WithDNS(config, nil); got.DNS != nil || !reflect.DeepEqual(got.Routing, config.Routing) {
+		t.Errorf("WithDNS(nil) changed the config: %+v", got)
+	}
+
+	dns, err := BuildDNSConfig(DNSOptions{Preset: DNSPresetCloudflare})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Routing = nil
+	got := WithDNS(config, dns)
+	if got.DNS != dns || got.Routing == nil {
+		t.Fatalf("config = %+v", got)
+	}
+	wantRule := RoutingRule{Type: "field", InboundTag: []string{DNSTag}, Outbound: "proxy"}
+	if !reflect.DeepEqual(got.Routing.Rules[0], wantRule) || len(got.Routing.Rules) != len(DefaultRouting().Rules)+1 {
+		t.Errorf("rules = %+v", got.Routing.Rules)
+	}
+
+	balanced, err := BuildBalancedXrayConfig(balancerTestLinks(), BalancerOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = WithDNS(balanced, dns)
+	wantRule = RoutingRule{Type: "field", InboundTag: []string{DNSTag}, BalancerTag: "proxy-balancer"}
+	if !reflect.DeepEqual(got.Routing.Rules[0], wantRule) {
+		t.Errorf("balanced dns rule = %+v, want %+v", got.Routing.Rules[0], wantRule)
+	}
+}
+
+func TestWithDNSResolvesDirectTraffic(t *testing.T) {
+	for _, test := range []struct {
+		queryStrategy string
+		want          string
+	}{
+		{"", "UseIP"},
+		{"ipv4", "UseIPv4"},
+		{"ipv6", "UseIPv6"},
+	} {
+		t.Run(test.want, func(t *testing.T) {
+			dns, err := BuildDNSConfig(DNSOptions{Preset: DNSPresetCloudflare, QueryStrategy: test.queryStrategy})
+			if err != nil {
+				t.Fatalf("BuildDNSConfig: %v", err)
+			}
+			config, err := BuildCoreConfig(CoreXray, formatTestLinks["vless reality"], CoreOptions{DNS: dns})
+			if err != nil {
+				t.Fatalf("BuildCoreConfig: %v", err)
+			}
+
+			// Decode the written form, as that is what Xray reads.
+			raw, err := json.Marshal(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var written XrayConfig
+			if err := json.Unmarshal(raw, &written); err != nil {
+				t.Fatal(err)
+			}
+			if written.DNS == nil {
+				t.Fatal("config has no dns section")
+			}
+			for _, outbound := range written.Outbounds {
+				if outbound.Tag != OutboundDirect {
+					continue
+				}
+				if settings := outbound.Settings.(FreedomSettings); settings.DomainStrategy != test.want {
+					t.Errorf("direct domainStrategy = %q, want %q", settings.DomainStrategy, test.want)
+				}
+				return
+			}
+			t.Error("config has no direct outbound")
+		})
+	}
+}
+
+func TestWithoutDNSKeepsDirectAsIs(t *testing.T) {
+	config, err := BuildCoreConfig(CoreXray, formatTestLinks["vless reality"], CoreOptions{})
+	if err != nil {
+		t.Fatalf("BuildCoreConfig: %v", err)
+	}
+	for _, outbound := range config.(XrayConfig).Outbounds {
+		if outbound.Tag == OutboundDirect && outbound.Settings.(FreedomSettings).DomainStrategy != "" {
+			t.Errorf("direct domainStrategy = %q without dns", outbound.Settings.(FreedomSettings).DomainStrategy)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package vpn
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// privateNetworks mirrors the geoip:private list shipped with Xray.
+var privateNetworks = mustParseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.0.2.0/24",
+	"192.88.99.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"198.51.100.0/24",
+	"203.0.113.0/24",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"255.255.255.255/32",
+	"::/128",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+)
+
+// RouteRequest describes a connection to evaluate against routing rules.
+// Domain and IP are exclusive, as the generated configs route with the AsIs
+// domain strategy and never resolve domains for matching.
+type RouteRequest struct {
+	Domain     string
+	IP         net.IP
+	Port       int
+	Network    string
+	Protocol   string
+	InboundTag string
+}
+
+func (r RouteRequest) String() string {
+	host := r.Domain
+	if r.IP != nil {
+		host = r.IP.String()
+	}
+	return fmt.Sprintf("%s/%s", net.JoinHostPort(host, strconv.Itoa(r.Port)), r.Network)
+}
+
+type RouteMatch struct {
+	// Rule is the index of the matching rule, -1 when none matched and the
+	// connection takes the first outbound, which is always the proxy in
+	// generated configs.
+	Rule     int
+	Outbound string
+	Balancer string
+}
+
+// ParseRouteRequest parses "host:port/network", where the port defaults to
+// 443 and the network to tcp, e.g. "discord.media:443/udp" or "[::1]:80".
+func ParseRouteRequest(value string) (RouteRequest, error) {
+	value = strings.TrimSpace(value)
+	req := RouteRequest{Port: 443, Network: "tcp"}
+	if address, network, ok := strings.Cut(value, "/"); ok {
+		value = address
+		req.Network = strings.ToLower(network)
+		if req.Network != "tcp" && req.Network != "udp" {
+			return RouteRequest{}, fmt.Errorf("unknown network %q", network)
+		}
+	}
+
+	host := value
+	if h, port, err := net.SplitHostPort(value); err == nil {
+		host = h
+		if req.Port, err = parseRulePort(port); err != nil {
+			return RouteRequest{}, err
+		}
+	}
+	host = strings.Trim(host, "[]")
+	if host == "" {
+		return RouteRequest{}, errors.New("missing host")
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		req.IP = ip
+	} else {
+		req.Domain = strings.ToLower(strings.TrimSuffix(host, "."))
+	}
+	return req, nil
+}
+
+// ExplainRoute walks routing rules in order with Xray matching semantics and
+// returns the first one matching req. geosite and geoip references other
+// than geoip:private are resolved through geo, which may be nil.
+func ExplainRoute(routing *RoutingConfig, req RouteRequest, geo *GeoData) (RouteMatch, error) {
+	req.Domain = strings.ToLower(req.Domain)
+	req.Network = firstNonEmpty(strings.ToLower(req.Network), "tcp")
+	for idx, rule := range routing.Rules {
+		matched, err := matchRule(rule, req, geo)
+		if err != nil {
+			return RouteMatch{}, fmt.Errorf("routing rule %d: %w", idx+1, err)
+		}
+		if matched {
+			return RouteMatch{Rule: idx, Outbound: rule.Outbound, Balancer: rule.BalancerTag}, nil
+		}
+	}
+	return RouteMatch{Rule: -1, Outbound: OutboundProxy}, nil
+}
+
+// matchRule reports whether every condition set on rule matches req.
+func matchRule(rule RoutingRule, req RouteRequest, geo *GeoData) (bool, error) {
+	if len(rule.InboundTag) > 0 && !slices.Contains(rule.InboundTag, req.InboundTag) {
+		return false, nil
+	}
+	if rule.Network != "" && !slices.Contains(splitCSV(rule.Network), req.Network) {
+		return false, nil
+	}
+	if len(rule.Protocol) > 0 && !slices.Contains(rule.Protocol, req.Protocol) {
+		return false, nil
+	}
+	if rule.Port != "" {
+		matched, err := matchPort(rule.Port, req.Port)
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+	if len(rule.Domain) > 0 {
+		matched, err := matchAny(rule.Domain, func(matcher string) (bool, error) {
+			return matchDomain(matcher, req.Domain, geo)
+		})
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+	if len(rule.IP) > 0 {
+		matched, err := matchAny(rule.IP, func(matcher string) (bool, error) {
+			return matchIP(matcher, req.IP, geo)
+		})
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchAny(matchers []string, match func(string) (bool, error)) (bool, error) {
+	for _, matcher := range matchers {
+		matched, err := match(matcher)
+		if err != nil || matched {
+			return matched, err
+		}
+	}
+	return false, nil
+}
+
+func matchDomain(matcher, domain string, geo *GeoData) (bool, error) {
+	if domain == "" {
+		return false, nil
+	}
+	prefix, value, ok := strings.Cut(matcher, ":")
+	if !ok {
+		prefix, value = "", matcher
+	}
+	value = strings.ToLower(value)
+	switch prefix {
+	case "", "keyword":
+		return strings.Contains(domain, value), nil
+	case "domain":
+		return domain == value || strings.HasSuffix(domain, "."+value), nil
+	case "full":
+		return domain == value, nil
+	case "regexp":
+		re, err := regexp.Compile(matcher[len("regexp:"):])
+		if err != nil {
+			return false, fmt.Errorf("invalid regexp %q: %w", matcher, err)
+		}
+		return re.MatchString(domain), nil
+	case "geosite":
+		sites, err := geo.Sites()
+		if err != nil {
+			return false, fmt.Errorf("resolve %q: %w", matcher, err)
+		}
+		domains, err := sites.lookup(matcher)
+		if err != nil {
+			return false, err
+		}
+		for _, entry := range domains {
+			if matched, err := matchDomain(entry.Matcher(), domain, nil); err != nil || matched {
+				return matched, err
+			}
+		}
+		return false, nil
+	default:
+		return false, fmt.Errorf("cannot evaluate domain matcher %q", matcher)
+	}
+}
+
+func matchIP(matcher string, ip net.IP, geo *GeoData) (bool, error) {
+	if ip == nil {
+		return false, nil
+	}
+	if code, ok := strings.CutPrefix(matcher, "geoip:!"); ok {
+		// Xray negates a geoip category with a leading "!".
+		matched, err := matchIP("geoip:"+code, ip, geo)
+		return !matched, err
+	}
+	switch {
+	case matcher == "geoip:private":
+		for _, network := range privateNetworks {
+			if network.Contains(ip) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case strings.HasPrefix(matcher, "geoip:"):
+		ips, err := geo.IPs()
+		if err != nil {
+			return false, fmt.Errorf("resolve %q: %w", matcher, err)
+		}
+		category, err := ips.lookup(matcher)
+		if err != nil {
+			return false, err
+		}
+		return category.Contains(ip), nil
+	case strings.Contains(matcher, "/"):
+		_, network, err := net.ParseCIDR(matcher)
+		if err != nil {
+			return false, fmt.Errorf("invalid cidr %q", matcher)
+		}
+		return network.Contains(ip), nil
+	default:
+		return ip.Equal(net.ParseIP(matcher)), nil
+	}
+}
+
+func matchPort(ports string, port int) (bool, error) {
+	for _, part := range splitCSV(ports) {
+		from, to, isRange := strings.Cut(part, "-")
+		first, err := parseRulePort(from)
+		if err != nil {
+			return false, err
+		}
+		last := first
+		if isRange {
+			if last, err = parseRulePort(to); err != nil {
+				return false, err
+			}
+		}
+		if port >= first && port <= last {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for idx, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[idx] = network
+	}
+	return networks
+}
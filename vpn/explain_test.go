@@ -0,0 +1,144 @@
+package vpn
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestExplainRoute(t *testing.T) {
+	routing := &RoutingConfig{Rules: []RoutingRule{
+		{Type: "field", InboundTag: []string{DNSTag}, Outbound: OutboundProxy},
+		{Type: "field", Domain: []string{"full:ads.example.com", "regexp:^tracker[0-9]+\\."}, Outbound: OutboundBlock},
+		{Type: "field", Domain: []string{"domain:example.com"}, Network: "tcp", Outbound: OutboundDirect},
+		{Type: "field", Domain: []string{"keyword:torrent"}, Protocol: []string{"bittorrent"}, Outbound: OutboundBlock},
+		{Type: "field", IP: []string{"geoip:private", "203.0.114.0/24", "2001:db8::1"}, Outbound: OutboundDirect},
+		{Type: "field", Port: "3478,50000-50100", Network: "udp", BalancerTag: "pool"},
+	}}
+	for _, test := range []struct {
+		req      RouteRequest
+		rule     int
+		outbound string
+		balancer string
+	}{
+		{RouteRequest{Domain: "example.com", Port: 53, Network: "udp", InboundTag: DNSTag}, 0, OutboundProxy, ""},
+		{RouteRequest{Domain: "ADS.example.com", Port: 443}, 1, OutboundBlock, ""},
+		{RouteRequest{Domain: "tracker42.example.org", Port: 443}, 1, OutboundBlock, ""},
+		{RouteRequest{Domain: "www.example.com", Port: 443}, 2, OutboundDirect, ""},
+		{RouteRequest{Domain: "www.example.com", Port: 443, Network: "udp"}, -1, OutboundProxy, ""},
+		{RouteRequest{Domain: "notexample.com", Port: 443}, -1, OutboundProxy, ""},
+		{RouteRequest{Domain: "torrent.example.org", Port: 6881, Protocol: "bittorrent"}, 3, OutboundBlock, ""},
+		{RouteRequest{Domain: "torrent.example.org", Port: 443, Protocol: "tls"}, -1, OutboundProxy, ""},
+		{RouteRequest{IP: net.ParseIP("10.1.2.3"), Port: 80}, 4, OutboundDirect, ""},
+		{RouteRequest{IP: net.ParseIP("203.0.114.7"), Port: 80}, 4, OutboundDirect, ""},
+		{RouteRequest{IP: net.ParseIP("2001:db8::1"), Port: 80}, 4, OutboundDirect, ""},
+		{RouteRequest{IP: net.ParseIP("1.1.1.1"), Port: 50050, Network: "udp"}, 5, "", "pool"},
+		{RouteRequest{IP: net.ParseIP("1.1.1.1"), Port: 3478, Network: "udp"}, 5, "", "pool"},
+		{RouteRequest{IP: net.ParseIP("1.1.1.1"), Port: 50050, Network: "tcp"}, -1, OutboundProxy, ""},
+	} {
+		t.Run(test.req.String(), func(t *testing.T) {
+			match, err := ExplainRoute(routing, test.req, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := RouteMatch{Rule: test.rule, Outbound: test.outbound, Balancer: test.balancer}
+			if match != want {
+				t.Errorf("got %+v, want %+v", match, want)
+			}
+		})
+	}
+}
+
+func TestExplainRoutePresets(t *testing.T) {
+	for _, test := range []struct {
+		preset   string
+		target   string
+		rule     int
+		outbound string
+	}{
+		{RoutingPresetMinimal, "discord.com", 0, OutboundProxy},
+		{RoutingPresetMinimal, "gateway.discord.gg:443", 0, OutboundProxy},
+		{RoutingPresetMinimal, "vk.com:443", 1, OutboundDirect},
+		{RoutingPresetMinimal, "192.168.1.1:80", 2, OutboundDirect},
+		{RoutingPresetMinimal, "discord.media:443/udp", -1, OutboundProxy},
+		{RoutingPresetMinimal, "example.org:443", -1, OutboundProxy},
+
+		{RoutingPresetDiscordFull, "192.168.1.1:80", 0, OutboundDirect},
+		{RoutingPresetDiscordFull, "192.168.1.1:50000/udp", 0, OutboundDirect},
+		{RoutingPresetDiscordFull, "[fe80::1]:443", 0, OutboundDirect},
+		{RoutingPresetDiscordFull, "gateway.discord.gg:443", 1, OutboundProxy},
+		{RoutingPresetDiscordFull, "cdn.discordapp.com", 1, OutboundProxy},
+		{RoutingPresetDiscordFull, "discord.media:443/udp", 1, OutboundProxy},
+		{RoutingPresetDiscordFull, "162.159.128.233:50001/udp", 2, OutboundProxy},
+		{RoutingPresetDiscordFull, "162.159.128.233:19300/udp", 2, OutboundProxy},
+		{RoutingPresetDiscordFull, "162.159.128.233:50001/tcp", -1, OutboundProxy},
+		{RoutingPresetDiscordFull, "notdiscord.com:443", -1, OutboundProxy},
+	} {
+		t.Run(test.preset+" "+test.target, func(t *testing.T) {
+			routing, err := RoutingPreset(test.preset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req, err := ParseRouteRequest(test.target)
+			if err != nil {
+				t.Fatalf("ParseRouteRequest: %v", err)
+			}
+			match, err := ExplainRoute(routing, req, nil)
+			if err != nil {
+				t.Fatalf("ExplainRoute: %v", err)
+			}
+			if match.Rule != test.rule || match.Outbound != test.outbound {
+				t.Errorf("got rule %d to %s, want rule %d to %s", match.Rule, match.Outbound, test.rule, test.outbound)
+			}
+		})
+	}
+}
+
+func TestExplainRouteErrors(t *testing.T) {
+	for _, test := range []struct {
+		rule RoutingRule
+		want string
+	}{
+		{RoutingRule{Domain: []string{"geosite:cn"}}, `routing rule 2: resolve "geosite:cn": no geo data`},
+		{RoutingRule{Domain: []string{"regexp:(["}}, `routing rule 2: invalid regexp "regexp:(["`},
+		{RoutingRule{IP: []string{"geoip:cn"}}, `routing rule 2: resolve "geoip:cn": no geo data`},
+		{RoutingRule{Port: "https"}, `routing rule 2: invalid port "https"`},
+	} {
+		t.Run(test.want, func(t *testing.T) {
+			routing := &RoutingConfig{Rules: []RoutingRule{
+				{Domain: []string{"full:other.example.com"}, Outbound: OutboundDirect},
+				test.rule,
+			}}
+			req := RouteRequest{Domain: "example.com", IP: net.ParseIP("1.1.1.1"), Port: 443}
+			if _, err := ExplainRoute(routing, req, nil); err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got error %v, want %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestParseRouteRequest(t *testing.T) {
+	for _, test := range []struct {
+		value string
+		want  string
+	}{
+		{"discord.media:443/udp", "discord.media:443/udp"},
+		{"Gateway.Discord.gg.", "gateway.discord.gg:443/tcp"},
+		{"192.168.1.1", "192.168.1.1:443/tcp"},
+		{"[::1]:80", "[::1]:80/tcp"},
+	} {
+		req, err := ParseRouteRequest(test.value)
+		if err != nil {
+			t.Errorf("ParseRouteRequest(%q): %v", test.value, err)
+			continue
+		}
+		if got := req.String(); got != test.want {
+			t.Errorf("ParseRouteRequest(%q) = %s, want %s", test.value, got, test.want)
+		}
+	}
+	for _, value := range []string{"", "discord.com:443/icmp", "discord.com:0", ":443"} {
+		if _, err := ParseRouteRequest(value); err == nil {
+			t.Errorf("ParseRouteRequest(%q) succeeded", value)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package vpn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/portapps/portapps/v3/pkg/log"
+)
+
+var ErrNoHealthyServer = errors.New("no healthy server")
+
+type FailoverOptions struct {
+	// Links are the candidate servers and Current the one the core was
+	// started with.
+	Links   []Link
+	Current Link
+	// BuildConfig renders the core config written to ConfigPath when
+	// switching servers. It must keep the SOCKS inbound on SocksPort.
+	BuildConfig func(link Link) (interface{}, error)
+	ConfigPath  string
+	// SocksHost is the host the probes dial, 127.0.0.1 by default.
+	SocksHost string
+	SocksPort int
+
+	ProbeInterval time.Duration
+	ProbeTimeout  time.Duration
+	TestURL       string
+	// MaxFailures is the number of consecutive failed probes that trigger a
+	// switch to the next-best server.
+	MaxFailures int
+	// ReevaluateInterval is how often every server is tested again while
+	// the current one works, 0 to only switch on failures.
+	ReevaluateInterval time.Duration
+	// Margin is how much faster another server must be before it replaces a
+	// healthy current one, and Cooldown how long a server that failed is
+	// skipped. Together they keep selection from flapping.
+	Margin   time.Duration
+	Cooldown time.Duration
+	Tester   *LatencyTester
+	// OnSwitch is called after the core was restarted with a new server.
+	OnSwitch func(link Link)
+}
+
+// Failover probes the active server through the local SOCKS inbound and
+// hot-restarts the core with the next-best server when it stops answering,
+// or when a re-evaluation finds one faster by more than the margin.
+type Failover struct {
+	opts       FailoverOptions
+	supervisor *Supervisor
+	current    Link
+	failedAt   map[string]time.Time
+}
+
+func NewFailover(supervisor *Supervisor, opts FailoverOptions) *Failover {
+	if opts.ProbeInterval == 0 {
+		opts.ProbeInterval = 30 * time.Second
+	}
+	if opts.ProbeTimeout == 0 {
+		opts.ProbeTimeout = 10 * time.Second
+	}
+	if opts.MaxFailures == 0 {
+		opts.MaxFailures = 3
+	}
+	if opts.Margin == 0 {
+		opts.Margin = 50 * time.Millisecond
+	}
+	if opts.Cooldown == 0 {
+		opts.Cooldown = 10 * time.Minute
+	}
+	if opts.SocksHost == "" {
+		opts.SocksHost = "127.0.0.1"
+	}
+	if opts.Tester == nil {
+		opts.Tester = &LatencyTester{Timeout: 3 * time.Second}
+	}
+	if opts.BuildConfig == nil {
+		opts.BuildConfig = func(link Link) (interface{}, error) {
+			return BuildCoreConfig(CoreXray, link, CoreOptions{Inbound: InboundOptions{SocksPort: opts.SocksPort}})
+		}
+	}
+	return &Failover{
+		opts:       opts,
+		supervisor: supervisor,
+		current:    opts.Current,
+		failedAt:   map[string]time.Time{},
+	}
+}
+
+// SelectBest returns the healthy result with the lowest latency from results
+// sorted by SortLatencyResults. A healthy current server is kept unless
+// another one is faster by more than margin.
+func SelectBest(results []LatencyResult, current *Link, margin time.Duration) (LatencyResult, error) {
+	if len(results) == 0 || results[0].Err != nil {
+		return LatencyResult{}, ErrNoHealthyServer
+	}
+	best := results[0]
+	if current == nil {
+		return best, nil
+	}
+	for _, result := range results {
+		if result.Err == nil && serverKey(result.Link) == serverKey(*current) {
+			if result.Latency() <= best.Latency()+margin {
+				return result, nil
+			}
+			break
+		}
+	}
+	return best, nil
+}
+
+// Run probes until ctx is done.
+func (f *Failover) Run(ctx context.Context) {
+	ticker := time.NewTicker(f.opts.ProbeInterval)
+	defer ticker.Stop()
+	var reevaluate <-chan time.Time
+	if f.opts.ReevaluateInterval > 0 {
+		reevaluateTicker := time.NewTicker(f.opts.ReevaluateInterval)
+		defer reevaluateTicker.Stop()
+		reevaluate = reevaluateTicker.C
+	}
+
+	failures := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-reevaluate:
+			if f.supervisor.State() != CoreRunning {
+				continue
+			}
+			switched, err := f.reevaluate(ctx)
+			if err != nil && ctx.Err() == nil {
+				log.Warn().Err(err).Msg("Cannot re-evaluate servers")
+			}
+			if switched {
+				failures = 0
+			}
+			continue
+		case <-ticker.C:
+		}
+		switch f.supervisor.State() {
+		case CoreRunning:
+		case CoreFailed:
+			// The supervisor gave up on the current server.
+			log.Warn().Err(f.supervisor.Err()).Msgf("Core failed with %s", f.current.Address)
+			if err := f.switchServer(ctx); err != nil {
+				log.Error().Err(err).Msg("Cannot switch to another server")
+			}
+			failures = 0
+			continue
+		default:
+			continue
+		}
+
+		probeCtx, cancel := context.WithTimeout(ctx, f.opts.ProbeTimeout)
+		_, err := HTTPLatency(probeCtx, net.JoinHostPort(f.opts.SocksHost, strconv.Itoa(f.opts.SocksPort)), firstNonEmpty(f.opts.TestURL, DefaultTestURL))
+		cancel()
+		if err == nil {
+			failures = 0
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		failures++
+		log.Warn().Err(err).Msgf("Probe through %s failed (%d/%d)", f.current.Address, failures, f.opts.MaxFailures)
+		if failures < f.opts.MaxFailures {
+			continue
+		}
+		if err := f.switchServer(ctx); err != nil {
+			log.Error().Err(err).Msg("Cannot switch to another server")
+			continue
+		}
+		failures = 0
+	}
+}
+
+// switchServer marks the current server as failed and moves to the best of
+// the others.
+func (f *Failover) switchServer(ctx context.Context) error {
+	now := time.Now()
+	f.failedAt[serverKey(f.current)] = now
+
+	best, err := SelectBest(f.opts.Tester.Test(ctx, f.candidates(now)), &f.current, f.opts.Margin)
+	if err != nil {
+		return err
+	}
+	return f.use(best)
+}
+
+// reevaluate tests every server, the current one included, and moves to the
+// best one when it beats the current server by more than the margin.
+func (f *Failover) reevaluate(ctx context.Context) (bool, error) {
+	best, err := SelectBest(f.opts.Tester.Test(ctx, f.candidates(time.Now())), &f.current, f.opts.Margin)
+	if err != nil {
+		return false, err
+	}
+	if serverKey(best.Link) == serverKey(f.current) {
+		return false, nil
+	}
+	return true, f.use(best)
+}
+
+// candidates returns the servers that are not cooling down.
+func (f *Failover) candidates(now time.Time) []Link {
+	candidates := make([]Link, 0, len(f.opts.Links))
+	for _, link := range f.opts.Links {
+		if failedAt, ok := f.failedAt[serverKey(link)]; ok && now.Sub(failedAt) < f.opts.Cooldown {
+			continue
+		}
+		candidates = append(candidates, link)
+	}
+	return candidates
+}
+
+func (f *Failover) use(best LatencyResult) error {
+	config, err := f.opts.BuildConfig(best.Link)
+	if err != nil {
+		return fmt.Errorf("build config for %s: %w", best.Link.Address, err)
+	}
+	if err := WriteConfig(f.opts.ConfigPath, config); err != nil {
+		return err
+	}
+
+	log.Info().Msgf("Switching from %s to %s (%s)", f.current.Address, best.Link.Address, best.Latency())
+	f.current = best.Link
+	f.supervisor.Restart()
+	if f.opts.OnSwitch != nil {
+		f.opts.OnSwitch(best.Link)
+	}
+	return nil
+}
@@ -0,0 +1,260 @@
+package vpn
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSelectBest(t *testing.T) {
+	fast := LatencyResult{Link: Link{Protocol: "trojan", Address: "fast.example.com", Port: 443}, TCP: 20 * time.Millisecond}
+	current := LatencyResult{Link: Link{Protocol: "trojan", Address: "current.example.com", Port: 443}, TCP: 60 * time.Millisecond}
+	failed := LatencyResult{Link: Link{Protocol: "trojan", Address: "failed.example.com", Port: 443}, Err: errors.New("timeout")}
+	results := []LatencyResult{fast, current, failed}
+
+	tests := []struct {
+		name    string
+		current *Link
+		margin  time.Duration
+		want    string
+	}{
+		{"no current", nil, time.Second, "fast.example.com"},
+		{"current within margin", &current.Link, 50 * time.Millisecond, "current.example.com"},
+		{"current at margin", &current.Link, 40 * time.Millisecond, "current.example.com"},
+		{"current beyond margin", &current.Link, 10 * time.Millisecond, "fast.example.com"},
+		{"current failed", &failed.Link, time.Second, "fast.example.com"},
+		{"current not tested", &Link{Protocol: "trojan", Address: "gone.example.com", Port: 443}, time.Second, "fast.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			best, err := SelectBest(results, tt.current, tt.margin)
+			if err != nil || best.Link.Address != tt.want {
+				t.Errorf("SelectBest = %s, %v, want %s", best.Link.Address, err, tt.want)
+			}
+		})
+	}
+
+	if _, err := SelectBest([]LatencyResult{failed}, nil, 0); !errors.Is(err, ErrNoHealthyServer) {
+		t.Errorf("SelectBest without healthy servers = %v", err)
+	}
+	if _, err := SelectBest(nil, nil, 0); !errors.Is(err, ErrNoHealthyServer) {
+		t.Errorf("SelectBest without results = %v", err)
+	}
+}
+
+// startFailoverCore runs the fake SOCKS core with the config of current and
+// returns failover options probing through it. Links whose password is
+// "unreachable" fail every probe.
+func startFailoverCore(t *testing.T, current Link, links []Link) (*Supervisor, FailoverOptions) {
+	t.Helper()
+	probe := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(probe.Close)
+
+	socksPort, err := FreePort()
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := FailoverOptions{
+		Links:         links,
+		Current:       current,
+		ConfigPath:    filepath.Join(t.TempDir(), "config.json"),
+		SocksPort:     socksPort,
+		ProbeInterval: 20 * time.Millisecond,
+		ProbeTimeout:  2 * time.Second,
+		TestURL:       probe.URL,
+		MaxFailures:   2,
+		Tester:        &LatencyTester{Timeout: time.Second},
+	}
+	config, err := BuildXrayConfig(current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Inbounds[0].Port = socksPort
+	if err := WriteConfig(opts.ConfigPath, config); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("VPN_FAKE_CORE", "socks")
+	t.Setenv("VPN_FAKE_CORE_LOG", "")
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	supervisor := NewSupervisor(SupervisorOptions{
+		CorePath:   executable,
+		ConfigPath: opts.ConfigPath,
+		ReadyPort:  socksPort,
+	})
+	if err := supervisor.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(supervisor.Stop)
+	return supervisor, opts
+}
+
+func runFailover(t *testing.T, supervisor *Supervisor, opts FailoverOptions) <-chan Link {
+	t.Helper()
+	switched := make(chan Link, 8)
+	opts.OnSwitch = func(link Link) { switched <- link }
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		NewFailover(supervisor, opts).Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return switched
+}
+
+func TestFailoverSwitchesOnProbeFailure(t *testing.T) {
+	dead, closeDead := listenerLink(t)
+	defer closeDead()
+	healthy, closeHealthy := listenerLink(t)
+	defer closeHealthy()
+	dead.Password = "unreachable"
+
+	supervisor, opts := startFailoverCore(t, dead, []Link{dead, healthy})
+	switched := runFailover(t, supervisor, opts)
+
+	select {
+	case link := <-switched:
+		if link.Port != healthy.Port {
+			t.Fatalf("switched to port %d, want %d", link.Port, healthy.Port)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("failover did not switch away from the dead server")
+	}
+	// The healthy server answers the probes, so there is no further switch.
+	select {
+	case link := <-switched:
+		t.Errorf("switched again to port %d", link.Port)
+	case <-time.After(500 * time.Millisecond):
+	}
+}
+
+func TestFailoverCooldown(t *testing.T) {
+	first, closeFirst := listenerLink(t)
+	defer closeFirst()
+	second, closeSecond := listenerLink(t)
+	defer closeSecond()
+	first.Password = "unreachable"
+	second.Password = "unreachable"
+
+	t.Run("skips failed servers", func(t *testing.T) {
+		supervisor, opts := startFailoverCore(t, first, []Link{first, second})
+		opts.Cooldown = time.Hour
+		switched := runFailover(t, supervisor, opts)
+
+		select {
+		case link := <-switched:
+			if link.Port != second.Port {
+				t.Fatalf("switched to port %d, want %d", link.Port, second.Port)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("failover did not switch away from the dead server")
+		}
+		// Both servers are cooling down, so failover stays put.
+		select {
+		case link := <-switched:
+			t.Errorf("switched back to port %d during the cooldown", link.Port)
+		case <-time.After(time.Second):
+		}
+	})
+
+	t.Run("retries after cooldown", func(t *testing.T) {
+		supervisor, opts := startFailoverCore(t, first, []Link{first, second})
+		opts.Cooldown = time.Millisecond
+		switched := runFailover(t, supervisor, opts)
+
+		for count := 0; count < 2; count++ {
+			select {
+			case <-switched:
+			case <-time.After(10 * time.Second):
+				t.Fatalf("got %d switches, want 2", count)
+			}
+		}
+	})
+}
+
+func TestFailoverSwitchesFromFailedCore(t *testing.T) {
+	supervisor, _ := newFakeCoreSupervisor(t, "serve-crash", SupervisorOptions{
+		MaxRestarts:    -1,
+		InitialBackoff: 10 * time.Millisecond,
+	})
+	if err := supervisor.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(supervisor.Stop)
+
+	healthy, closeHealthy := listenerLink(t)
+	defer closeHealthy()
+	current := Link{Protocol: "trojan", Address: "failed.example.com", Port: 443, Password: "secret"}
+	switched := runFailover(t, supervisor, FailoverOptions{
+		Links:         []Link{current, healthy},
+		Current:       current,
+		ConfigPath:    filepath.Join(t.TempDir(), "config.json"),
+		ProbeInterval: 20 * time.Millisecond,
+		// Only the failed core may trigger the switch.
+		MaxFailures: 1000,
+		Tester:      &LatencyTester{Timeout: time.Second},
+	})
+
+	select {
+	case link := <-switched:
+		if link.Port != healthy.Port {
+			t.Fatalf("switched to port %d, want %d", link.Port, healthy.Port)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("failover did not switch away from the failed core")
+	}
+}
+
+func TestFailoverReevaluateMargin(t *testing.T) {
+	slow, closeSlow := listenerLink(t)
+	defer closeSlow()
+	fast, closeFast := listenerLink(t)
+	defer closeFast()
+	slow.Password = "slow"
+
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name   string
+		margin time.Duration
+		want   int
+	}{
+		{"keeps the current server within the margin", time.Hour, 0},
+		{"moves to a server faster by more than the margin", fakeSlowDelay / 3, fast.Port},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			supervisor, opts := startFailoverCore(t, slow, []Link{slow, fast})
+			opts.Margin = test.margin
+			opts.ReevaluateInterval = 50 * time.Millisecond
+			opts.Tester = &LatencyTester{CorePath: executable, TestURL: opts.TestURL, Timeout: 5 * time.Second}
+			switched := runFailover(t, supervisor, opts)
+
+			select {
+			case link := <-switched:
+				if link.Port != test.want {
+					t.Fatalf("switched to port %d, want %d", link.Port, test.want)
+				}
+			case <-time.After(5 * time.Second):
+				if test.want != 0 {
+					t.Fatalf("failover did not move to port %d", test.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package vpn
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// FormatLink is the inverse of ParseLink: it renders link as a canonical
+// share URI. Parsing the result yields link again, except for Raw, which
+// ParseLink sets to the URI it was given, and for fields the scheme has no
+// place for, such as REALITY keys on vmess links or transports on
+// shadowsocks ones. Shadowrocket vmess links are written in the v2rayN form.
+func FormatLink(link Link) (string, error) {
+	if link.Address == "" || link.Port == 0 {
+		return "", errors.New("missing required link fields")
+	}
+	switch link.Protocol {
+	case "vless":
+		return formatVLESS(link)
+	case "vmess":
+		return formatVMess(link)
+	case "trojan":
+		return formatTrojan(link)
+	case "shadowsocks":
+		return formatShadowsocks(link)
+	case "hysteria2":
+		return formatHysteria2(link), nil
+	case "tuic":
+		return formatTUIC(link)
+	case "wireguard":
+		return formatWireGuard(link), nil
+	default:
+		return "", ErrUnsupportedProtocol
+	}
+}
+
+func formatVLESS(link Link) (string, error) {
+	if link.UUID == "" {
+		return "", errors.New("missing uuid")
+	}
+	query := url.Values{}
+	setQuery(query, "encryption", link.Encryption)
+	setQuery(query, "security", link.Security)
+	setQuery(query, "flow", link.Flow)
+	formatTransportQuery(query, link)
+
+	return formatURL("vless", url.User(link.UUID), link, query), nil
+}
+
+func formatTrojan(link Link) (string, error) {
+	if link.Password == "" {
+		return "", errors.New("missing password")
+	}
+	query := url.Values{}
+	setQuery(query, "security", firstNonEmpty(link.Security, "none"))
+	setQuery(query, "flow", link.Flow)
+	formatTransportQuery(query, link)
+
+	return formatURL("trojan", url.User(link.Password), link, query), nil
+}
+
+func formatHysteria2(link Link) string {
+	query := url.Values{}
+	setQuery(query, "sni", link.SNI)
+	setQuery(query, "alpn", strings.Join(link.ALPN, ","))
+	if link.AllowInsecure {
+		query.Set("insecure", "1")
+	}
+	setQuery(query, "obfs", link.Obfs)
+	setQuery(query, "obfs-password", link.ObfsPassword)
+	if link.UpMbps > 0 {
+		query.Set("upmbps", strconv.Itoa(link.UpMbps))
+	}
+	if link.DownMbps > 0 {
+		query.Set("downmbps", strconv.Itoa(link.DownMbps))
+	}
+
+	var user *url.Userinfo
+	if link.Password != "" {
+		user = url.User(link.Password)
+	}
+	return formatURL("hysteria2", user, link, query)
+}
+
+func formatTUIC(link Link) (string, error) {
+	if link.UUID == "" {
+		return "", errors.New("missing uuid")
+	}
+	query := url.Values{}
+	setQuery(query, "sni", link.SNI)
+	setQuery(query, "alpn", strings.Join(link.ALPN, ","))
+	if link.AllowInsecure {
+		query.Set("allow_insecure", "1")
+	}
+	setQuery(query, "congestion_control", link.CongestionControl)
+	setQuery(query, "udp_relay_mode", link.UDPRelayMode)
+
+	return formatURL("tuic", url.UserPassword(link.UUID, link.Password), link, query), nil
+}
+
+func formatVMess(link Link) (string, error) {
+	if link.UUID == "" {
+		return "", errors.New("missing uuid")
+	}
+	payload := vmessPayload{
+		Version:     2,
+		Name:        link.Name,
+		Address:     link.Address,
+		Port:        vmessNumber(link.Port),
+		ID:          link.UUID,
+		AlterID:     vmessNumber(link.AlterID),
+		Cipher:      link.Cipher,
+		Network:     link.Transport,
+		Type:        firstNonEmpty(link.HeaderType, "none"),
+		Host:        link.Host,
+		Path:        link.Path,
+		TLS:         link.Security,
+		SNI:         link.SNI,
+		ALPN:        strings.Join(link.ALPN, ","),
+		Fingerprint: link.Fingerprint,
+		Insecure:    vmessBool(link.AllowInsecure),
+		Authority:   link.Authority,
+		Extra:       link.Extra,
+	}
+	switch normalizeTransport(link.Transport) {
+	case "kcp":
+		payload.Path = link.Seed
+	case "quic":
+		payload.Host, payload.Path = link.QUICSecurity, link.QUICKey
+	case "grpc":
+		payload.Path = link.ServiceName
+		payload.Type = firstNonEmpty(link.Mode, "none")
+	case "xhttp":
+		payload.Type = firstNonEmpty(link.Mode, "none")
+	}
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return "", fmt.Errorf("marshal vmess payload: %w", err)
+	}
+	return "vmess://" + base64.StdEncoding.EncodeToString(encoded), nil
+}
+
+func formatShadowsocks(link Link) (string, error) {
+	if link.Method == "" || link.Password == "" {
+		return "", errors.New("missing shadowsocks method or password")
+	}
+
+	// SIP002 requires plain percent-encoded userinfo for 2022 ciphers and
+	// base64url for everything else.
+	var userinfo string
+	if _, ok := shadowsocks2022KeySizes[link.Method]; ok {
+		userinfo = url.PathEscape(link.Method) + ":" + url.PathEscape(link.Password)
+	} else {
+		userinfo = base64.RawURLEncoding.EncodeToString([]byte(link.Method + ":" + link.Password))
+	}
+
+	var builder strings.Builder
+	builder.WriteString("ss://")
+	builder.WriteString(userinfo)
+	builder.WriteString("@")
+	builder.WriteString(net.JoinHostPort(link.Address, strconv.Itoa(link.Port)))
+	if link.Plugin != "" {
+		plugin := link.Plugin
+		if link.PluginOpts != "" {
+			plugin += ";" + link.PluginOpts
+		}
+		builder.WriteString("/?plugin=")
+		builder.WriteString(url.QueryEscape(plugin))
+	}
+	if link.Name != "" {
+		builder.WriteString("#")
+		builder.WriteString(url.PathEscape(link.Name))
+	}
+	return builder.String(), nil
+}
+
+func formatURL(scheme string, user *url.Userinfo, link Link, query url.Values) string {
+	formatted := url.URL{
+		Scheme:   scheme,
+		User:     user,
+		Host:     net.JoinHostPort(link.Address, strconv.Itoa(link.Port)),
+		RawQuery: query.Encode(),
+		Fragment: link.Name,
+	}
+	return formatted.String()
+}
+
+func formatTransportQuery(query url.Values, link Link) {
+	setQuery(query, "type", firstNonEmpty(link.Transport, "tcp"))
+	setQuery(query, "sni", link.SNI)
+	setQuery(query, "host", link.Host)
+	setQuery(query, "path", link.Path)
+	setQuery(query, "fp", link.Fingerprint)
+	setQuery(query, "alpn", strings.Join(link.ALPN, ","))
+	setQuery(query, "serviceName", link.ServiceName)
+	setQuery(query, "authority", link.Authority)
+	setQuery(query, "mode", link.Mode)
+	setQuery(query, "extra", link.Extra)
+	setQuery(query, "headerType", link.HeaderType)
+	setQuery(query, "seed", link.Seed)
+	setQuery(query, "quicSecurity", link.QUICSecurity)
+	setQuery(query, "key", link.QUICKey)
+	if link.AllowInsecure {
+		query.Set("allowInsecure", "1")
+	}
+	setQuery(query, "pbk", link.PublicKey)
+	setQuery(query, "sid", link.ShortID)
+	setQuery(query, "spx", link.SpiderX)
+	setQuery(query, "pqv", link.MLDSA65Verify)
+}
+
+func setQuery(query url.Values, key, value string) {
+	if value != "" {
+		query.Set(key, value)
+	}
+}
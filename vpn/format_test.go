@@ -0,0 +1,437 @@
+package vpn
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math/rand/v2"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// linkGenerator builds random links that populate every field a protocol
+// serializes, with names, passwords and paths that need escaping.
+type linkGenerator struct {
+	rand *rand.Rand
+}
+
+const escapingAlphabet = "abcXYZ019 -_.~!$&'()*+,;=:@/?#%[]\"<>\\^`{|}é中🙂"
+
+func (g linkGenerator) pick(values ...string) string {
+	return values[g.rand.IntN(len(values))]
+}
+
+func (g linkGenerator) maybe(value string) string {
+	if g.rand.IntN(3) == 0 {
+		return ""
+	}
+	return value
+}
+
+func (g linkGenerator) text(alphabet string, maxLen int) string {
+	runes := []rune(alphabet)
+	out := make([]rune, 1+g.rand.IntN(maxLen))
+	for idx := range out {
+		out[idx] = runes[g.rand.IntN(len(runes))]
+	}
+	return string(out)
+}
+
+func (g linkGenerator) escaping() string {
+	return g.text(escapingAlphabet, 16)
+}
+
+func (g linkGenerator) bytes(size int) []byte {
+	out := make([]byte, size)
+	for idx := range out {
+		out[idx] = byte(g.rand.UintN(256))
+	}
+	return out
+}
+
+func (g linkGenerator) uuid() string {
+	value := hex.EncodeToString(g.bytes(16))
+	return value[:8] + "-" + value[8:12] + "-" + value[12:16] + "-" + value[16:20] + "-" + value[20:]
+}
+
+func (g linkGenerator) address() string {
+	switch g.rand.IntN(3) {
+	case 0:
+		return fmt.Sprintf("%d.%d.%d.%d", 1+g.rand.IntN(223), g.rand.IntN(256), g.rand.IntN(256), 1+g.rand.IntN(254))
+	case 1:
+		return fmt.Sprintf("2001:db8::%x", 1+g.rand.IntN(0xffff))
+	default:
+		return g.text("abcdefghijklmnopqrstuvwxyz0123456789", 12) + ".example.com"
+	}
+}
+
+func (g linkGenerator) alpn() []string {
+	count := g.rand.IntN(3)
+	if count == 0 {
+		return nil
+	}
+	return []string{"h3", "h2", "http/1.1"}[:count]
+}
+
+// transport fills the fields applyTransportQuery reads.
+func (g linkGenerator) transport(link *Link) {
+	link.Transport = g.pick("tcp", "ws", "grpc", "h2", "httpupgrade", "xhttp", "kcp", "quic")
+	switch link.Transport {
+	case "tcp":
+		link.HeaderType = g.pick("", "none", "http")
+	case "grpc":
+		link.Mode = g.pick("", "gun", "multi")
+		link.Authority = g.maybe(g.address())
+	case "xhttp":
+		link.Mode = g.pick("", "auto", "packet-up", "stream-up", "stream-one")
+		link.Extra = g.maybe(`{"xPaddingBytes":"100-1000"}`)
+	case "kcp":
+		link.HeaderType = g.pick("", "none", "srtp", "utp", "wechat-video", "dtls", "wireguard")
+		link.Seed = g.maybe(g.escaping())
+	case "quic":
+		link.HeaderType = g.pick("", "none", "srtp", "dtls")
+		link.QUICSecurity = g.pick("", "none", "aes-128-gcm", "chacha20-poly1305")
+		link.QUICKey = g.maybe(g.escaping())
+	}
+	link.SNI = g.maybe(g.address())
+	link.Host = g.maybe(g.escaping())
+	link.Path = g.maybe("/" + g.escaping())
+	link.Fingerprint = g.maybe(g.pick("chrome", "firefox", "safari", "randomized"))
+	link.ALPN = g.alpn()
+	link.ServiceName = g.maybe(g.escaping())
+	link.AllowInsecure = g.rand.IntN(2) == 0
+}
+
+func (g linkGenerator) reality(link *Link) {
+	link.Security = "reality"
+	link.PublicKey = base64.RawURLEncoding.EncodeToString(g.bytes(32))
+	link.ShortID = hex.EncodeToString(g.bytes(g.rand.IntN(9)))
+	link.SpiderX = g.maybe("/" + g.escaping())
+	if g.rand.IntN(4) == 0 {
+		link.MLDSA65Verify = base64.RawURLEncoding.EncodeToString(g.bytes(1952))
+	}
+}
+
+func (g linkGenerator) link(protocol string) Link {
+	link := Link{
+		Protocol: protocol,
+		Name:     g.maybe(g.escaping()),
+		Address:  g.address(),
+		Port:     1 + g.rand.IntN(65535),
+	}
+	switch protocol {
+	case "vless":
+		link.UUID = g.uuid()
+		link.Encryption = g.maybe("none")
+		link.Flow = g.maybe("xtls-rprx-vision")
+		g.transport(&link)
+		link.Security = g.pick("", "tls", "none")
+		if g.rand.IntN(3) == 0 {
+			g.reality(&link)
+		}
+	case "vmess":
+		link.UUID = g.uuid()
+		link.AlterID = g.rand.IntN(64)
+		link.Cipher = g.pick("", "auto", "aes-128-gcm", "chacha20-poly1305", "none", "zero")
+		link.Security = g.pick("", "tls")
+		link.AllowInsecure = g.rand.IntN(2) == 0
+		link.Transport = g.pick("tcp", "ws", "grpc", "h2", "xhttp", "kcp", "quic")
+		switch link.Transport {
+		case "tcp":
+			link.HeaderType = g.pick("", "http")
+			link.Host = g.maybe(g.address())
+		case "grpc":
+			link.ServiceName = g.maybe(g.escaping())
+			link.Mode = g.pick("", "gun", "multi")
+			link.Authority = g.maybe(g.address())
+		case "xhttp":
+			link.Mode = g.pick("", "auto", "packet-up")
+			link.Extra = g.maybe(`{"noGRPCHeader":true}`)
+			link.Host = g.maybe(g.escaping())
+			link.Path = g.maybe("/" + g.escaping())
+		case "kcp":
+			link.HeaderType = g.pick("", "srtp", "wechat-video")
+			link.Seed = g.maybe(g.escaping())
+		case "quic":
+			link.HeaderType = g.pick("", "utp", "dtls")
+			link.QUICSecurity = g.pick("", "aes-128-gcm", "chacha20-poly1305")
+			link.QUICKey = g.maybe(g.escaping())
+		default:
+			link.Host = g.maybe(g.escaping())
+			link.Path = g.maybe("/" + g.escaping())
+		}
+		link.SNI = g.maybe(g.address())
+		link.Fingerprint = g.maybe("chrome")
+		link.ALPN = g.alpn()
+	case "trojan":
+		link.Password = g.escaping()
+		link.Flow = g.maybe("xtls-rprx-vision")
+		g.transport(&link)
+		link.Security = g.pick("tls", "")
+		if g.rand.IntN(3) == 0 {
+			g.reality(&link)
+		}
+	case "shadowsocks":
+		link.Transport = "tcp"
+		link.Method = g.pick("aes-128-gcm", "chacha20-ietf-poly1305", "2022-blake3-aes-128-gcm", "2022-blake3-aes-256-gcm")
+		if size, ok := shadowsocks2022KeySizes[link.Method]; ok {
+			keys := make([]string, 1+g.rand.IntN(2))
+			for idx := range keys {
+				keys[idx] = base64.StdEncoding.EncodeToString(g.bytes(size))
+			}
+			link.Password = strings.Join(keys, ":")
+		} else {
+			link.Password = g.escaping()
+		}
+		if g.rand.IntN(2) == 0 {
+			link.Plugin = "v2ray-plugin"
+			link.PluginOpts = g.maybe("mode=websocket;host=" + g.address() + ";path=/" + g.escaping() + ";tls")
+		}
+	case "hysteria2":
+		link.Password = g.maybe(g.escaping())
+		link.Security = "tls"
+		link.SNI = g.maybe(g.address())
+		link.ALPN = g.alpn()
+		link.AllowInsecure = g.rand.IntN(2) == 0
+		if g.rand.IntN(2) == 0 {
+			link.Obfs = "salamander"
+			link.ObfsPassword = g.escaping()
+		}
+		link.UpMbps = g.rand.IntN(1000)
+		link.DownMbps = g.rand.IntN(1000)
+	case "tuic":
+		link.UUID = g.uuid()
+		link.Password = g.escaping()
+		link.Security = "tls"
+		link.SNI = g.maybe(g.address())
+		link.ALPN = g.alpn()
+		link.AllowInsecure = g.rand.IntN(2) == 0
+		link.CongestionControl = g.pick("", "cubic", "new_reno", "bbr")
+		link.UDPRelayMode = g.pick("", "native", "quic")
+	case "wireguard":
+		link.PrivateKey = base64.StdEncoding.EncodeToString(g.bytes(32))
+		link.PeerPublicKey = base64.StdEncoding.EncodeToString(g.bytes(32))
+		link.PresharedKey = g.maybe(base64.StdEncoding.EncodeToString(g.bytes(32)))
+		link.LocalAddress = []string{fmt.Sprintf("10.%d.%d.%d/32", g.rand.IntN(256), g.rand.IntN(256), g.rand.IntN(256))}
+		if g.rand.IntN(2) == 0 {
+			link.LocalAddress = append(link.LocalAddress, fmt.Sprintf("fd00::%x/128", 1+g.rand.IntN(0xffff)))
+		}
+		if g.rand.IntN(2) == 0 {
+			link.AllowedIPs = []string{"0.0.0.0/0", "::/0"}
+		}
+		if g.rand.IntN(2) == 0 {
+			link.DNS = []string{"1.1.1.1", "2606:4700:4700::1111"}[:1+g.rand.IntN(2)]
+		}
+		if g.rand.IntN(2) == 0 {
+			link.MTU = 1280 + g.rand.IntN(140)
+		}
+		if g.rand.IntN(2) == 0 {
+			link.Reserved = []int{g.rand.IntN(256), g.rand.IntN(256), g.rand.IntN(256)}
+		}
+	}
+	return link
+}
+
+func TestFormatLinkRoundTrip(t *testing.T) {
+	gen := linkGenerator{rand: rand.New(rand.NewPCG(4, 2))}
+	for _, protocol := range []string{"vless", "vmess", "trojan", "shadowsocks", "hysteria2", "tuic", "wireguard"} {
+		for idx := 0; idx < 200; idx++ {
+			link := gen.link(protocol)
+			formatted, err := FormatLink(link)
+			if err != nil {
+				t.Fatalf("%s #%d: format %+v: %v", protocol, idx, link, err)
+			}
+			parsed, err := ParseLink(formatted)
+			if err != nil {
+				t.Fatalf("%s #%d: parse %s: %v", protocol, idx, formatted, err)
+			}
+			parsed.Raw = ""
+			if !reflect.DeepEqual(parsed, link) {
+				t.Fatalf("%s #%d: round trip through %s\ngot  %+v\nwant %+v", protocol, idx, formatted, parsed, link)
+			}
+		}
+	}
+}
+
+const (
+	testWireGuardKey1 = "q+P/JIN0uh5TJOzEEijt6H1FLuaa1qYeklQmzEoueu8="
+	testWireGuardKey2 = "jQYp8TobusudqxrXukfB7AqRRwYPkvmAVxG8tUkgN+A="
+)
+
+// formatTestLinks populates every field each protocol serializes.
+var formatTestLinks = map[string]Link{
+	"vless reality": {
+		Protocol: "vless", Name: "vless reality", Address: "example.com", Port: 443,
+		UUID: testUUID, Encryption: "none", Security: "reality", Flow: "xtls-rprx-vision",
+		Transport: "tcp", SNI: "www.microsoft.com", Fingerprint: "chrome",
+		PublicKey: testRealityKey, ShortID: "6ba85179e30d4fc2", SpiderX: "/",
+	},
+	"vless ws tls": {
+		Protocol: "vless", Name: "ws", Address: "203.0.113.7", Port: 8443,
+		UUID: testUUID, Security: "tls", Transport: "ws", SNI: "cdn.example.com",
+		Host: "cdn.example.com", Path: "/ws?ed=2048", ALPN: []string{"h2", "http/1.1"},
+		AllowInsecure: true,
+	},
+	"vless grpc multi": {
+		Protocol: "vless", Address: "example.com", Port: 443, UUID: testUUID,
+		Security: "tls", Transport: "grpc", ServiceName: "svc", Mode: "multi",
+		Authority: "grpc.example.com",
+	},
+	"vless xhttp": {
+		Protocol: "vless", Address: "example.com", Port: 443, UUID: testUUID,
+		Security: "tls", Transport: "xhttp", Host: "example.com", Path: "/x",
+		Mode: "packet-up", Extra: `{"xPaddingBytes":"100-1000"}`,
+	},
+	"vless kcp": {
+		Protocol: "vless", Address: "example.com", Port: 2052, UUID: testUUID,
+		Transport: "kcp", HeaderType: "wechat-video", Seed: "seed",
+	},
+	"vless quic": {
+		Protocol: "vless", Address: "example.com", Port: 443, UUID: testUUID,
+		Security: "tls", Transport: "quic", HeaderType: "srtp",
+		QUICSecurity: "aes-128-gcm", QUICKey: "key",
+	},
+	"vmess tcp http": {
+		Protocol: "vmess", Name: "vmess", Address: "example.com", Port: 80,
+		UUID: testUUID, AlterID: 4, Cipher: "aes-128-gcm", Transport: "tcp",
+		HeaderType: "http", Host: "a.example.com,b.example.com", Path: "/p",
+	},
+	"vmess ws insecure": {
+		Protocol: "vmess", Address: "example.com", Port: 443, UUID: testUUID,
+		Cipher: "auto", Security: "tls", Transport: "ws", Host: "example.com",
+		Path: "/ws", SNI: "example.com", ALPN: []string{"h2"}, Fingerprint: "firefox",
+		AllowInsecure: true,
+	},
+	"vmess grpc": {
+		Protocol: "vmess", Address: "example.com", Port: 443, UUID: testUUID,
+		Security: "tls", Transport: "grpc", ServiceName: "svc", Mode: "multi",
+		Authority: "grpc.example.com",
+	},
+	"vmess xhttp": {
+		Protocol: "vmess", Address: "example.com", Port: 443, UUID: testUUID,
+		Security: "tls", Transport: "xhttp", Path: "/x", Mode: "stream-one",
+		Extra: `{"noGRPCHeader":true}`,
+	},
+	"vmess kcp": {
+		Protocol: "vmess", Address: "example.com", Port: 2052, UUID: testUUID,
+		Transport: "kcp", HeaderType: "dtls", Seed: "seed",
+	},
+	"vmess quic": {
+		Protocol: "vmess", Address: "example.com", Port: 443, UUID: testUUID,
+		Transport: "quic", HeaderType: "utp", QUICSecurity: "chacha20-poly1305", QUICKey: "key",
+	},
+	"trojan tls": {
+		Protocol: "trojan", Name: "trojan", Address: "example.com", Port: 443,
+		Password: "p@ss word", Security: "tls", Transport: "ws", SNI: "example.com",
+		Host: "example.com", Path: "/t", AllowInsecure: true,
+	},
+	"trojan plain": {
+		Protocol: "trojan", Address: "example.com", Port: 8080, Password: "secret",
+		Transport: "tcp",
+	},
+	"shadowsocks": {
+		Protocol: "shadowsocks", Name: "ss", Address: "example.com", Port: 8388,
+		Method: "chacha20-ietf-poly1305", Password: "secret", Transport: "tcp",
+	},
+	"shadowsocks 2022 plugin": {
+		Protocol: "shadowsocks", Address: "2001:db8::1", Port: 8388,
+		Method: "2022-blake3-aes-128-gcm", Password: "AAECAwQFBgcICQoLDA0ODw==",
+		Plugin: "v2ray-plugin", PluginOpts: "mode=websocket;host=example.com;tls",
+		Transport: "tcp",
+	},
+	"hysteria2": {
+		Protocol: "hysteria2", Name: "hy2", Address: "example.com", Port: 8443,
+		Password: "user:pass", Security: "tls", SNI: "example.com", ALPN: []string{"h3"},
+		AllowInsecure: true, Obfs: "salamander", ObfsPassword: "obfs",
+		UpMbps: 50, DownMbps: 200,
+	},
+	"tuic": {
+		Protocol: "tuic", Name: "tuic", Address: "example.com", Port: 443,
+		UUID: testUUID, Password: "secret", Security: "tls", SNI: "example.com",
+		ALPN: []string{"h3"}, AllowInsecure: true, CongestionControl: "bbr",
+		UDPRelayMode: "quic",
+	},
+	"wireguard": {
+		Protocol: "wireguard", Name: "warp", Address: "engage.cloudflareclient.com", Port: 2408,
+		PrivateKey: testWireGuardKey1, PeerPublicKey: testWireGuardKey2,
+		PresharedKey: testWireGuardKey2, LocalAddress: []string{"172.16.0.2/32", "2606:4700::2/128"},
+		AllowedIPs: []string{"0.0.0.0/0", "::/0"}, Reserved: []int{1, 2, 3}, MTU: 1280,
+		DNS: []string{"1.1.1.1"},
+	},
+}
+
+func TestFormatLinkExamples(t *testing.T) {
+	for name, link := range formatTestLinks {
+		t.Run(name, func(t *testing.T) {
+			raw, err := FormatLink(link)
+			if err != nil {
+				t.Fatalf("FormatLink: %v", err)
+			}
+			parsed, err := ParseLink(raw)
+			if err != nil {
+				t.Fatalf("ParseLink(%q): %v", raw, err)
+			}
+			if parsed.Raw != raw {
+				t.Errorf("Raw = %q, want %q", parsed.Raw, raw)
+			}
+			parsed.Raw = ""
+			if !reflect.DeepEqual(parsed, link) {
+				t.Errorf("round trip of %q\n got %+v\nwant %+v", raw, parsed, link)
+			}
+		})
+	}
+}
+
+// TestFormatLinkIdempotent checks that formatting a parsed link yields the
+// same URI, for links written by other clients.
+func TestFormatLinkIdempotent(t *testing.T) {
+	for _, raw := range []string{
+		"vless://" + testUUID + "@example.com:443?security=none&type=tcp#plain",
+		"trojan://secret@example.com:443?peer=example.com",
+		"ss://YWVzLTI1Ni1nY206c2VjcmV0@example.com:8388#legacy",
+		"hy2://secret@example.com?obfs=salamander&obfs-password=o&up=10%20mbps&down=1%20gbps",
+		"vmess://" + "YXV0bzowZDRlM2M2YS0xMTExLTIyMjItMzMzMy00NDQ0NTU1NTY2NjZAZXhhbXBsZS5jb206NDQz" + "?remarks=sr&obfs=websocket&obfsParam=example.com&path=/ws&tls=1&allowInsecure=1",
+	} {
+		link, err := ParseLink(raw)
+		if err != nil {
+			t.Fatalf("ParseLink(%q): %v", raw, err)
+		}
+		formatted, err := FormatLink(link)
+		if err != nil {
+			t.Fatalf("FormatLink(%q): %v", raw, err)
+		}
+		again, err := ParseLink(formatted)
+		if err != nil {
+			t.Fatalf("ParseLink(%q): %v", formatted, err)
+		}
+		link.Raw, again.Raw = "", ""
+		if !reflect.DeepEqual(again, link) {
+			t.Errorf("%q reformatted as %q\n got %+v\nwant %+v", raw, formatted, again, link)
+		}
+	}
+}
+
+func TestFormatLinkErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		link Link
+		want string
+	}{
+		{"missing address", Link{Protocol: "vless", Port: 443, UUID: testUUID}, "missing required link fields"},
+		{"vless without uuid", Link{Protocol: "vless", Address: "example.com", Port: 443}, "missing uuid"},
+		{"vmess without uuid", Link{Protocol: "vmess", Address: "example.com", Port: 443}, "missing uuid"},
+		{"trojan without password", Link{Protocol: "trojan", Address: "example.com", Port: 443}, "missing password"},
+		{"ss without method", Link{Protocol: "shadowsocks", Address: "example.com", Port: 8388, Password: "secret"}, "missing shadowsocks method or password"},
+		{"tuic without uuid", Link{Protocol: "tuic", Address: "example.com", Port: 443, Password: "secret"}, "missing uuid"},
+		{"unsupported", Link{Protocol: "socks", Address: "example.com", Port: 1080}, "unsupported protocol"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := FormatLink(tt.link)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
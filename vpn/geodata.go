@@ -0,0 +1,392 @@
+package vpn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	GeoSiteFileName = "geosite.dat"
+	GeoIPFileName   = "geoip.dat"
+)
+
+var (
+	ErrGeoCategoryNotFound = errors.New("geo category not found")
+	ErrNoGeoData           = errors.New("no geo data")
+)
+
+// GeoDomain is a domain entry of a geosite category.
+type GeoDomain struct {
+	// Type is the Xray matcher prefix: keyword, regexp, domain or full.
+	Type       string
+	Value      string
+	Attributes []string
+}
+
+// Matcher returns the entry as an Xray domain matcher such as
+// "domain:discord.com".
+func (d GeoDomain) Matcher() string {
+	return d.Type + ":" + d.Value
+}
+
+// GeoSiteList maps upper-case category codes to their domains.
+type GeoSiteList map[string][]GeoDomain
+
+// GeoIP is a geoip category. With ReverseMatch set it matches the
+// addresses outside of Networks.
+type GeoIP struct {
+	Networks     []*net.IPNet
+	ReverseMatch bool
+}
+
+// Contains reports whether the category matches ip.
+func (g GeoIP) Contains(ip net.IP) bool {
+	for _, network := range g.Networks {
+		if network.Contains(ip) {
+			return !g.ReverseMatch
+		}
+	}
+	return g.ReverseMatch
+}
+
+// GeoIPList maps upper-case category codes to their categories.
+type GeoIPList map[string]GeoIP
+
+var geoDomainTypes = map[uint64]string{
+	0: "keyword",
+	1: "regexp",
+	2: "domain",
+	3: "full",
+}
+
+// LoadGeoSite reads a V2Ray geosite.dat file.
+func LoadGeoSite(path string) (GeoSiteList, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read geosite: %w", err)
+	}
+	return ParseGeoSite(data)
+}
+
+// LoadGeoIP reads a V2Ray geoip.dat file.
+func LoadGeoIP(path string) (GeoIPList, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read geoip: %w", err)
+	}
+	return ParseGeoIP(data)
+}
+
+// ParseGeoSite decodes the GeoSiteList protobuf message.
+func ParseGeoSite(data []byte) (GeoSiteList, error) {
+	list := GeoSiteList{}
+	err := walkProto(data, func(num int, _ uint64, entry []byte) error {
+		if num != 1 {
+			return nil
+		}
+		var code string
+		var domains []GeoDomain
+		err := walkProto(entry, func(num int, _ uint64, value []byte) error {
+			switch num {
+			case 1:
+				code = strings.ToUpper(string(value))
+			case 2:
+				domain, err := parseGeoDomain(value)
+				if err != nil {
+					return err
+				}
+				domains = append(domains, domain)
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("geosite %s: %w", code, err)
+		}
+		list[code] = append(list[code], domains...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("parse geosite: %w", err)
+	}
+	return list, nil
+}
+
+func parseGeoDomain(data []byte) (GeoDomain, error) {
+	domain := GeoDomain{Type: geoDomainTypes[0]}
+	err := walkProto(data, func(num int, varint uint64, value []byte) error {
+		switch num {
+		case 1:
+			kind, ok := geoDomainTypes[varint]
+			if !ok {
+				return fmt.Errorf("unknown domain type %d", varint)
+			}
+			domain.Type = kind
+		case 2:
+			domain.Value = string(value)
+		case 3:
+			return walkProto(value, func(num int, _ uint64, key []byte) error {
+				if num == 1 {
+					domain.Attributes = append(domain.Attributes, string(key))
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return domain, err
+}
+
+// ParseGeoIP decodes the GeoIPList protobuf message.
+func ParseGeoIP(data []byte) (GeoIPList, error) {
+	list := GeoIPList{}
+	err := walkProto(data, func(num int, _ uint64, entry []byte) error {
+		if num != 1 {
+			return nil
+		}
+		var code string
+		var category GeoIP
+		err := walkProto(entry, func(num int, varint uint64, value []byte) error {
+			switch num {
+			case 1:
+				code = strings.ToUpper(string(value))
+			case 2:
+				network, err := parseGeoCIDR(value)
+				if err != nil {
+					return err
+				}
+				category.Networks = append(category.Networks, network)
+			case 3:
+				category.ReverseMatch = varint != 0
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("geoip %s: %w", code, err)
+		}
+		existing := list[code]
+		category.Networks = append(existing.Networks, category.Networks...)
+		category.ReverseMatch = category.ReverseMatch || existing.ReverseMatch
+		list[code] = category
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("parse geoip: %w", err)
+	}
+	return list, nil
+}
+
+func parseGeoCIDR(data []byte) (*net.IPNet, error) {
+	var ip net.IP
+	var prefix uint64
+	err := walkProto(data, func(num int, varint uint64, value []byte) error {
+		switch num {
+		case 1:
+			ip = append(net.IP(nil), value...)
+		case 2:
+			prefix = varint
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(ip) != net.IPv4len && len(ip) != net.IPv6len {
+		return nil, fmt.Errorf("invalid cidr ip of %d bytes", len(ip))
+	}
+	bits := len(ip) * 8
+	if prefix > uint64(bits) {
+		return nil, fmt.Errorf("invalid cidr prefix /%d", prefix)
+	}
+	mask := net.CIDRMask(int(prefix), bits)
+	return &net.IPNet{IP: ip.Mask(mask), Mask: mask}, nil
+}
+
+// walkProto calls fn for every field of a protobuf message, with the value
+// of varint fields or the payload of length-delimited ones. Fixed-size
+// fields are skipped, as the geo formats do not use them.
+func walkProto(data []byte, fn func(num int, varint uint64, value []byte) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errors.New("truncated field key")
+		}
+		data = data[n:]
+		num, wire := int(key>>3), key&7
+
+		var varint uint64
+		var value []byte
+		switch wire {
+		case 0:
+			if varint, n = binary.Uvarint(data); n <= 0 {
+				return errors.New("truncated varint")
+			}
+			data = data[n:]
+		case 1:
+			if len(data) < 8 {
+				return errors.New("truncated fixed64")
+			}
+			data = data[8:]
+		case 2:
+			size, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < size {
+				return errors.New("truncated bytes")
+			}
+			value = data[n : n+int(size)]
+			data = data[n+int(size):]
+		case 5:
+			if len(data) < 4 {
+				return errors.New("truncated fixed32")
+			}
+			data = data[4:]
+		default:
+			return fmt.Errorf("unsupported wire type %d", wire)
+		}
+
+		if err := fn(num, varint, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Categories returns the sorted category codes.
+func (l GeoSiteList) Categories() []string {
+	return sortedKeys(l)
+}
+
+// Expand resolves a "geosite:code" reference, optionally filtered by
+// "@attribute" suffixes, into Xray domain matchers.
+func (l GeoSiteList) Expand(ref string) ([]string, error) {
+	domains, err := l.lookup(ref)
+	if err != nil {
+		return nil, err
+	}
+	matchers := make([]string, len(domains))
+	for idx, domain := range domains {
+		matchers[idx] = domain.Matcher()
+	}
+	return matchers, nil
+}
+
+func (l GeoSiteList) lookup(ref string) ([]GeoDomain, error) {
+	code, attrs, _ := strings.Cut(strings.TrimPrefix(ref, "geosite:"), "@")
+	domains, ok := l[strings.ToUpper(code)]
+	if !ok {
+		return nil, fmt.Errorf("geosite %q: %w", code, ErrGeoCategoryNotFound)
+	}
+	if attrs == "" {
+		return domains, nil
+	}
+
+	var filtered []GeoDomain
+	for _, domain := range domains {
+		matched := true
+		for _, attr := range strings.Split(attrs, "@") {
+			if !containsFold(domain.Attributes, attr) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			filtered = append(filtered, domain)
+		}
+	}
+	return filtered, nil
+}
+
+// Categories returns the sorted category codes.
+func (l GeoIPList) Categories() []string {
+	return sortedKeys(l)
+}
+
+// Expand resolves a "geoip:code" reference into CIDRs.
+func (l GeoIPList) Expand(ref string) ([]string, error) {
+	category, err := l.lookup(ref)
+	if err != nil {
+		return nil, err
+	}
+	if category.ReverseMatch {
+		return nil, fmt.Errorf("%s: reverse matched categories cannot be expanded", ref)
+	}
+	cidrs := make([]string, len(category.Networks))
+	for idx, network := range category.Networks {
+		cidrs[idx] = network.String()
+	}
+	return cidrs, nil
+}
+
+func (l GeoIPList) lookup(ref string) (GeoIP, error) {
+	code := strings.TrimPrefix(ref, "geoip:")
+	if strings.HasPrefix(code, "!") {
+		return GeoIP{}, fmt.Errorf("geoip %q: negated categories cannot be expanded", code)
+	}
+	category, ok := l[strings.ToUpper(code)]
+	if !ok {
+		return GeoIP{}, fmt.Errorf("geoip %q: %w", code, ErrGeoCategoryNotFound)
+	}
+	return category, nil
+}
+
+// GeoData loads geosite.dat and geoip.dat from a directory on first use, so
+// that rules without geo references never read the large files. A nil
+// GeoData reports ErrNoGeoData.
+type GeoData struct {
+	dir string
+
+	siteOnce sync.Once
+	sites    GeoSiteList
+	siteErr  error
+	ipOnce   sync.Once
+	ips      GeoIPList
+	ipErr    error
+}
+
+// NewGeoData returns the geo data found in dir, usually the core directory.
+func NewGeoData(dir string) *GeoData {
+	return &GeoData{dir: dir}
+}
+
+func (g *GeoData) Sites() (GeoSiteList, error) {
+	if g == nil {
+		return nil, ErrNoGeoData
+	}
+	g.siteOnce.Do(func() {
+		g.sites, g.siteErr = LoadGeoSite(filepath.Join(g.dir, GeoSiteFileName))
+	})
+	return g.sites, g.siteErr
+}
+
+func (g *GeoData) IPs() (GeoIPList, error) {
+	if g == nil {
+		return nil, ErrNoGeoData
+	}
+	g.ipOnce.Do(func() {
+		g.ips, g.ipErr = LoadGeoIP(filepath.Join(g.dir, GeoIPFileName))
+	})
+	return g.ips, g.ipErr
+}
+
+func sortedKeys[T any](values map[string]T) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func containsFold(values []string, value string) bool {
+	for _, candidate := range values {
+		if strings.EqualFold(candidate, value) {
+			return true
+		}
+	}
+	return false
+}
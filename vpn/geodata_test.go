@@ -0,0 +1,351 @@
+package vpn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func protoField(num int, value []byte) []byte {
+	data := binary.AppendUvarint(nil, uint64(num)<<3|2)
+	data = binary.AppendUvarint(data, uint64(len(value)))
+	return append(data, value...)
+}
+
+func protoVarint(num int, value uint64) []byte {
+	return binary.AppendUvarint(binary.AppendUvarint(nil, uint64(num)<<3), value)
+}
+
+func geoIPEntry(code string, reverse bool, cidrs ...string) []byte {
+	entry := protoField(1, []byte(code))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		ip := network.IP
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		prefix, _ := network.Mask.Size()
+		entry = append(entry, protoField(2, append(protoField(1, ip), protoVarint(2, uint64(prefix))...))...)
+	}
+	if reverse {
+		entry = append(entry, protoVarint(3, 1)...)
+	}
+	return protoField(1, entry)
+}
+
+// geoSiteDomain encodes a domain entry; kind is the geoDomainTypes number.
+func geoSiteDomain(kind uint64, value string, attrs ...string) []byte {
+	domain := append(protoVarint(1, kind), protoField(2, []byte(value))...)
+	for _, attr := range attrs {
+		domain = append(domain, protoField(3, append(protoField(1, []byte(attr)), protoVarint(2, 1)...))...)
+	}
+	return protoField(2, domain)
+}
+
+func geoSiteEntry(code string, domains ...[]byte) []byte {
+	entry := protoField(1, []byte(code))
+	for _, domain := range domains {
+		entry = append(entry, domain...)
+	}
+	return protoField(1, entry)
+}
+
+func testGeoSite() []byte {
+	var data []byte
+	data = append(data, geoSiteEntry("discord",
+		geoSiteDomain(2, "discord.com"),
+		geoSiteDomain(3, "discord.gg", "cdn"),
+		geoSiteDomain(0, "discordapp"),
+	)...)
+	data = append(data, geoSiteEntry("category-ads", geoSiteDomain(1, `^ads\d*\.`, "ads"))...)
+	return data
+}
+
+func testGeoIP() []byte {
+	return append(geoIPEntry("cn", false, "1.0.1.0/24", "2400:3200::/32"), geoIPEntry("private", false, "10.0.0.0/8")...)
+}
+
+// writeTestGeoData writes both geo files to a temporary directory.
+func writeTestGeoData(t *testing.T) *GeoData {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, GeoSiteFileName), testGeoSite(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, GeoIPFileName), testGeoIP(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return NewGeoData(dir)
+}
+
+func TestParseGeoSite(t *testing.T) {
+	list, err := ParseGeoSite(testGeoSite())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if categories := list.Categories(); !reflect.DeepEqual(categories, []string{"CATEGORY-ADS", "DISCORD"}) {
+		t.Errorf("categories = %v", categories)
+	}
+	want := []GeoDomain{
+		{Type: "domain", Value: "discord.com"},
+		{Type: "full", Value: "discord.gg", Attributes: []string{"cdn"}},
+		{Type: "keyword", Value: "discordapp"},
+	}
+	if !reflect.DeepEqual(list["DISCORD"], want) {
+		t.Errorf("discord = %+v, want %+v", list["DISCORD"], want)
+	}
+
+	for _, test := range []struct {
+		ref  string
+		want []string
+	}{
+		{"geosite:discord", []string{"domain:discord.com", "full:discord.gg", "keyword:discordapp"}},
+		{"geosite:Discord@CDN", []string{"full:discord.gg"}},
+		{"geosite:discord@cdn@ads", []string{}},
+		{"geosite:category-ads", []string{`regexp:^ads\d*\.`}},
+	} {
+		matchers, err := list.Expand(test.ref)
+		if err != nil {
+			t.Errorf("Expand(%s): %v", test.ref, err)
+			continue
+		}
+		if !reflect.DeepEqual(matchers, test.want) {
+			t.Errorf("Expand(%s) = %v, want %v", test.ref, matchers, test.want)
+		}
+	}
+	if _, err := list.Expand("geosite:cn"); !errors.Is(err, ErrGeoCategoryNotFound) {
+		t.Errorf("Expand(geosite:cn) = %v, want ErrGeoCategoryNotFound", err)
+	}
+}
+
+func TestParseGeoIP(t *testing.T) {
+	list, err := ParseGeoIP(testGeoIP())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if categories := list.Categories(); !reflect.DeepEqual(categories, []string{"CN", "PRIVATE"}) {
+		t.Errorf("categories = %v", categories)
+	}
+	cidrs, err := list.Expand("geoip:CN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"1.0.1.0/24", "2400:3200::/32"}; !reflect.DeepEqual(cidrs, want) {
+		t.Errorf("Expand(geoip:CN) = %v, want %v", cidrs, want)
+	}
+	if _, err := list.Expand("geoip:us"); !errors.Is(err, ErrGeoCategoryNotFound) {
+		t.Errorf("Expand(geoip:us) = %v, want ErrGeoCategoryNotFound", err)
+	}
+	if _, err := list.Expand("geoip:!cn"); err == nil {
+		t.Error("Expand succeeded for a negated category")
+	}
+}
+
+func TestParseGeoErrors(t *testing.T) {
+	badPrefix := protoField(1, append(protoField(1, []byte("cn")),
+		protoField(2, append(protoField(1, net.IPv4(1, 0, 1, 0).To4()), protoVarint(2, 33)...))...))
+	badDomain := geoSiteEntry("cn", protoField(2, protoVarint(1, 7)))
+	for _, test := range []struct {
+		name  string
+		parse func([]byte) error
+		data  []byte
+		want  string
+	}{
+		{"truncated key", parseGeoIPErr, []byte{0x80}, "truncated field key"},
+		{"truncated bytes", parseGeoIPErr, []byte{0x0a, 0x05, 0x01}, "truncated bytes"},
+		{"wire type", parseGeoSiteErr, []byte{0x0b}, "unsupported wire type 3"},
+		{"cidr prefix", parseGeoIPErr, badPrefix, "invalid cidr prefix /33"},
+		{"cidr ip", parseGeoIPErr, geoIPEntryRaw("cn", []byte{1, 2, 3}), "invalid cidr ip of 3 bytes"},
+		{"domain type", parseGeoSiteErr, badDomain, "unknown domain type 7"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.parse(test.data); err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got error %v, want %q", err, test.want)
+			}
+		})
+	}
+}
+
+func parseGeoIPErr(data []byte) error {
+	_, err := ParseGeoIP(data)
+	return err
+}
+
+func parseGeoSiteErr(data []byte) error {
+	_, err := ParseGeoSite(data)
+	return err
+}
+
+func geoIPEntryRaw(code string, ip []byte) []byte {
+	return protoField(1, append(protoField(1, []byte(code)), protoField(2, protoField(1, ip))...))
+}
+
+func TestGeoData(t *testing.T) {
+	geo := writeTestGeoData(t)
+	sites, err := geo.Sites()
+	if err != nil || len(sites) != 2 {
+		t.Errorf("Sites() = %v, %v", sites, err)
+	}
+	ips, err := geo.IPs()
+	if err != nil || len(ips) != 2 {
+		t.Errorf("IPs() = %v, %v", ips, err)
+	}
+
+	var none *GeoData
+	if _, err := none.Sites(); !errors.Is(err, ErrNoGeoData) {
+		t.Errorf("nil Sites() = %v, want ErrNoGeoData", err)
+	}
+	if _, err := none.IPs(); !errors.Is(err, ErrNoGeoData) {
+		t.Errorf("nil IPs() = %v, want ErrNoGeoData", err)
+	}
+	missing := NewGeoData(t.TempDir())
+	if _, err := missing.Sites(); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing Sites() = %v, want os.ErrNotExist", err)
+	}
+}
+
+func TestExplainRouteGeoData(t *testing.T) {
+	geo := writeTestGeoData(t)
+	routing := &RoutingConfig{Rules: []RoutingRule{
+		{Type: "field", Domain: []string{"geosite:category-ads"}, Outbound: OutboundBlock},
+		{Type: "field", Domain: []string{"geosite:discord"}, Outbound: OutboundProxy},
+		{Type: "field", IP: []string{"geoip:cn"}, Outbound: OutboundDirect},
+	}}
+	for _, test := range []struct {
+		target string
+		rule   int
+	}{
+		{"ads2.example.com", 0},
+		{"cdn.discord.com", 1},
+		{"discord.gg", 1},
+		{"www.discord.gg", -1},
+		{"cdn.discordapp.net", 1},
+		{"1.0.1.1", 2},
+		{"[2400:3200::1]:443", 2},
+		{"8.8.8.8", -1},
+	} {
+		req, err := ParseRouteRequest(test.target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		match, err := ExplainRoute(routing, req, geo)
+		if err != nil {
+			t.Fatalf("ExplainRoute(%s): %v", req, err)
+		}
+		if match.Rule != test.rule {
+			t.Errorf("%s matches rule %d, want %d", req, match.Rule, test.rule)
+		}
+	}
+
+	routing.Rules[0].Domain = []string{"geosite:cn"}
+	req := RouteRequest{Domain: "example.com", Port: 443}
+	if _, err := ExplainRoute(routing, req, geo); !errors.Is(err, ErrGeoCategoryNotFound) {
+		t.Errorf("unknown category: %v, want ErrGeoCategoryNotFound", err)
+	}
+}
+
+func TestParseRoutingRulesGeoRefs(t *testing.T) {
+	geo := writeTestGeoData(t)
+	rules, err := parseTestRules(t, "- {domain: 'geosite:discord@cdn', ip: [geoip:cn, geoip:private], outbound: proxy}\n", geo)
+	if err != nil || len(rules) != 1 {
+		t.Fatalf("ParseRoutingRules = %v, %v", rules, err)
+	}
+
+	_, err = parseTestRules(t, "- outbound: proxy\n  domain:\n    - geosite:discord\n    - geosite:unknown\n", geo)
+	if !errors.Is(err, ErrGeoCategoryNotFound) || !strings.Contains(err.Error(), "rule 1 (line 4)") {
+		t.Errorf("unknown geosite: %v", err)
+	}
+	_, err = parseTestRules(t, "- {ip: 'geoip:us', outbound: direct}\n", geo)
+	if !errors.Is(err, ErrGeoCategoryNotFound) {
+		t.Errorf("unknown geoip: %v", err)
+	}
+	// Without geo data references are only checked for syntax.
+	if _, err := parseTestRules(t, "- {ip: 'geoip:us', outbound: direct}\n", nil); err != nil {
+		t.Errorf("ParseRoutingRules without geo data: %v", err)
+	}
+}
+
+// testGeoIPFixture is the content of testdata/geoip.dat, rewritten by
+// running the tests with -update.
+func testGeoIPFixture() []byte {
+	var data []byte
+	data = append(data, geoIPEntry("cn", false, "1.0.1.0/24", "2400:3200::/32")...)
+	data = append(data, geoIPEntry("not-cn", true, "1.0.1.0/24", "2400:3200::/32")...)
+	return data
+}
+
+func TestGeoIPFixture(t *testing.T) {
+	path := filepath.Join("testdata", GeoIPFileName)
+	want := testGeoIPFixture()
+	if *updateGolden {
+		if err := os.WriteFile(path, want, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s is outdated, run the tests with -update", path)
+	}
+}
+
+func TestLoadGeoIP(t *testing.T) {
+	list, err := LoadGeoIP(filepath.Join("testdata", GeoIPFileName))
+	if err != nil {
+		t.Fatalf("LoadGeoIP: %v", err)
+	}
+	if categories := list.Categories(); !reflect.DeepEqual(categories, []string{"CN", "NOT-CN"}) {
+		t.Errorf("categories = %v", categories)
+	}
+	if list["CN"].ReverseMatch || !list["NOT-CN"].ReverseMatch {
+		t.Errorf("reverse match of CN = %t, NOT-CN = %t", list["CN"].ReverseMatch, list["NOT-CN"].ReverseMatch)
+	}
+
+	cidrs, err := list.Expand("geoip:cn")
+	if err != nil {
+		t.Fatalf("Expand: %v", err)
+	}
+	if want := []string{"1.0.1.0/24", "2400:3200::/32"}; !reflect.DeepEqual(cidrs, want) {
+		t.Errorf("Expand(geoip:cn) = %v, want %v", cidrs, want)
+	}
+	if _, err := list.Expand("geoip:not-cn"); err == nil {
+		t.Error("Expand succeeded for a reverse matched category")
+	}
+}
+
+func TestExplainRouteGeoIPReverseMatch(t *testing.T) {
+	geo := NewGeoData("testdata")
+	routing := &RoutingConfig{Rules: []RoutingRule{
+		{Type: "field", IP: []string{"geoip:not-cn"}, Outbound: OutboundProxy},
+		{Type: "field", IP: []string{"geoip:cn"}, Outbound: OutboundDirect},
+	}}
+	for _, test := range []struct {
+		ip   string
+		want string
+	}{
+		{"1.0.1.1", OutboundDirect},
+		{"2400:3200::1", OutboundDirect},
+		{"8.8.8.8", OutboundProxy},
+		{"2001:4860::8888", OutboundProxy},
+	} {
+		req := RouteRequest{IP: net.ParseIP(test.ip), Port: 443, Network: "tcp"}
+		match, err := ExplainRoute(routing, req, geo)
+		if err != nil {
+			t.Fatalf("ExplainRoute(%s): %v", req, err)
+		}
+		if match.Outbound != test.want {
+			t.Errorf("%s goes to %s, want %s", req, match.Outbound, test.want)
+		}
+	}
+}
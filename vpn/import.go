@@ -11,6 +11,30 @@ func ParseLinksFromText(input string) ImportResult {
 		return ImportResult{}
 	}
 
+	if isXrayJSON(trimmed) {
+		result, err := ParseXrayJSON([]byte(trimmed))
+		if err != nil {
+			return ImportResult{Errors: []error{err}}
+		}
+		return result.ImportResult
+	}
+
+	if isClashConfig(trimmed) {
+		result, err := ParseClashConfig([]byte(trimmed))
+		if err != nil {
+			return ImportResult{Errors: []error{err}}
+		}
+		return result
+	}
+
+	if isWireGuardConf(trimmed) {
+		result, err := ParseWireGuardConf([]byte(trimmed))
+		if err != nil {
+			return ImportResult{Errors: []error{err}}
+		}
+		return result
+	}
+
 	if !strings.Contains(trimmed, "://") {
 		if decoded, err := decodeBase64(trimmed); err == nil {
 			decodedText := strings.TrimSpace(string(decoded))
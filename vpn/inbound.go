@@ -0,0 +1,221 @@
+package vpn
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// DefaultDestOverride is the sniffing destOverride used when none is set.
+var DefaultDestOverride = []string{"http", "tls"}
+
+// InboundOptions describes the local inbounds of a core. Each non-zero port
+// enables the matching inbound; without any, a SOCKS inbound listens on
+// DefaultLocalPort.
+type InboundOptions struct {
+	Listen    string
+	SocksPort int
+	HTTPPort  int
+	// MixedPort serves SOCKS and HTTP on a single port.
+	MixedPort int
+	// Username and Password enable authentication on every inbound but the
+	// LocalPort one.
+	Username string
+	Password string
+	// LocalPort adds an unauthenticated SOCKS inbound on 127.0.0.1 when
+	// authentication is enabled, used by Discord and the probes as Chromium
+	// cannot authenticate to a SOCKS proxy.
+	LocalPort int
+	UDP       bool
+	Sniffing  bool
+	// DestOverride lists the sniffed protocols whose domain replaces the
+	// destination, DefaultDestOverride by default.
+	DestOverride []string
+}
+
+func (o InboundOptions) withDefaults() InboundOptions {
+	o.Listen = firstNonEmpty(o.Listen, "127.0.0.1")
+	if o.SocksPort == 0 && o.HTTPPort == 0 && o.MixedPort == 0 {
+		o.SocksPort = DefaultLocalPort
+	}
+	if o.Sniffing && len(o.DestOverride) == 0 {
+		o.DestOverride = DefaultDestOverride
+	}
+	return o
+}
+
+func (o InboundOptions) authenticated() bool {
+	return o.Username != "" || o.Password != ""
+}
+
+// local reports whether clients use the unauthenticated loopback inbound.
+func (o InboundOptions) local() bool {
+	return o.authenticated() && o.LocalPort != 0
+}
+
+// Port returns the port clients should use: the loopback inbound, then the
+// mixed one, then the SOCKS one, then the HTTP one.
+func (o InboundOptions) Port() int {
+	o = o.withDefaults()
+	switch {
+	case o.local():
+		return o.LocalPort
+	case o.MixedPort != 0:
+		return o.MixedPort
+	case o.SocksPort != 0:
+		return o.SocksPort
+	default:
+		return o.HTTPPort
+	}
+}
+
+// ProbePort returns the port of the SOCKS capable inbound used for latency
+// probes, zero when only an HTTP inbound is enabled.
+func (o InboundOptions) ProbePort() int {
+	o = o.withDefaults()
+	if o.local() {
+		return o.LocalPort
+	}
+	if o.MixedPort != 0 {
+		return o.MixedPort
+	}
+	return o.SocksPort
+}
+
+// Host returns the host clients dial to reach the inbounds returned by Port
+// and ProbePort.
+func (o InboundOptions) Host() string {
+	o = o.withDefaults()
+	if o.local() {
+		return "127.0.0.1"
+	}
+	return dialHost(o.Listen)
+}
+
+// ProxyURL returns the proxy address of the inbound returned by Port.
+func (o InboundOptions) ProxyURL() string {
+	o = o.withDefaults()
+	scheme := "socks5"
+	if !o.local() && o.MixedPort == 0 && o.SocksPort == 0 {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(o.Host(), strconv.Itoa(o.Port())))
+}
+
+// dialHost returns the host to dial for a listener on listen, the loopback
+// address for unspecified ones.
+func dialHost(listen string) string {
+	ip := net.ParseIP(listen)
+	switch {
+	case ip == nil || !ip.IsUnspecified():
+		return listen
+	case ip.To4() == nil:
+		return "::1"
+	default:
+		return "127.0.0.1"
+	}
+}
+
+// XrayInbounds builds the Xray inbounds for opts.
+func XrayInbounds(opts InboundOptions) []InboundConfig {
+	opts = opts.withDefaults()
+
+	var sniffing *SniffingConfig
+	if opts.Sniffing {
+		sniffing = &SniffingConfig{Enabled: true, DestOverride: opts.DestOverride}
+	}
+	var accounts []InboundAccount
+	if opts.Username != "" || opts.Password != "" {
+		accounts = []InboundAccount{{User: opts.Username, Pass: opts.Password}}
+	}
+	socksSettings := &InboundSettings{Auth: "noauth", UDP: opts.UDP}
+	if accounts != nil {
+		socksSettings.Auth = "password"
+		socksSettings.Accounts = accounts
+	}
+
+	var inbounds []InboundConfig
+	if opts.MixedPort != 0 {
+		// The Xray socks inbound also answers plain HTTP proxy requests.
+		inbounds = append(inbounds, InboundConfig{
+			Tag:      "mixed-in",
+			Port:     opts.MixedPort,
+			Listen:   opts.Listen,
+			Protocol: "socks",
+			Settings: socksSettings,
+			Sniffing: sniffing,
+		})
+	}
+	if opts.SocksPort != 0 {
+		inbounds = append(inbounds, InboundConfig{
+			Tag:      "socks-in",
+			Port:     opts.SocksPort,
+			Listen:   opts.Listen,
+			Protocol: "socks",
+			Settings: socksSettings,
+			Sniffing: sniffing,
+		})
+	}
+	if opts.HTTPPort != 0 {
+		inbounds = append(inbounds, InboundConfig{
+			Tag:      "http-in",
+			Port:     opts.HTTPPort,
+			Listen:   opts.Listen,
+			Protocol: "http",
+			Settings: &InboundSettings{Accounts: accounts},
+			Sniffing: sniffing,
+		})
+	}
+	if opts.local() {
+		inbounds = append(inbounds, InboundConfig{
+			Tag:      "local-in",
+			Port:     opts.LocalPort,
+			Listen:   "127.0.0.1",
+			Protocol: "socks",
+			Settings: &InboundSettings{Auth: "noauth", UDP: opts.UDP},
+			Sniffing: sniffing,
+		})
+	}
+	return inbounds
+}
+
+// SingBoxInbounds builds the sing-box inbounds for opts. sing-box always
+// relays UDP over SOCKS, so opts.UDP is ignored, and sniffing is a route
+// rule added by WithSingBoxSniffing.
+func SingBoxInbounds(opts InboundOptions) []SingBoxInbound {
+	opts = opts.withDefaults()
+
+	var users []SingBoxUser
+	if opts.Username != "" || opts.Password != "" {
+		users = []SingBoxUser{{Username: opts.Username, Password: opts.Password}}
+	}
+	inbound := func(kind string, port int) SingBoxInbound {
+		return SingBoxInbound{
+			Type:       kind,
+			Tag:        kind + "-in",
+			Listen:     opts.Listen,
+			ListenPort: port,
+			Users:      users,
+		}
+	}
+
+	var inbounds []SingBoxInbound
+	if opts.MixedPort != 0 {
+		inbounds = append(inbounds, inbound("mixed", opts.MixedPort))
+	}
+	if opts.SocksPort != 0 {
+		inbounds = append(inbounds, inbound("socks", opts.SocksPort))
+	}
+	if opts.HTTPPort != 0 {
+		inbounds = append(inbounds, inbound("http", opts.HTTPPort))
+	}
+	if opts.local() {
+		inbounds = append(inbounds, SingBoxInbound{
+			Type:       "socks",
+			Tag:        "local-in",
+			Listen:     "127.0.0.1",
+			ListenPort: opts.LocalPort,
+		})
+	}
+	return inbounds
+}
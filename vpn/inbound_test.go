@@ -0,0 +1,123 @@
+package vpn
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInboundOptionsPorts(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		opts      InboundOptions
+		port      int
+		probePort int
+		proxyURL  string
+	}{
+		{"default", InboundOptions{}, DefaultLocalPort, DefaultLocalPort, "socks5://127.0.0.1:10808"},
+		{"mixed first", InboundOptions{SocksPort: 1080, HTTPPort: 8080, MixedPort: 2080}, 2080, 2080, "socks5://127.0.0.1:2080"},
+		{"socks before http", InboundOptions{SocksPort: 1080, HTTPPort: 8080}, 1080, 1080, "socks5://127.0.0.1:1080"},
+		{"http only", InboundOptions{Listen: "0.0.0.0", HTTPPort: 8080}, 8080, 0, "http://127.0.0.1:8080"},
+		{"listen", InboundOptions{Listen: "192.168.1.5", MixedPort: 2080}, 2080, 2080, "socks5://192.168.1.5:2080"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.opts.Port(); got != test.port {
+				t.Errorf("Port() = %d, want %d", got, test.port)
+			}
+			if got := test.opts.ProbePort(); got != test.probePort {
+				t.Errorf("ProbePort() = %d, want %d", got, test.probePort)
+			}
+			if got := test.opts.ProxyURL(); got != test.proxyURL {
+				t.Errorf("ProxyURL() = %s, want %s", got, test.proxyURL)
+			}
+		})
+	}
+}
+
+func TestXrayInbounds(t *testing.T) {
+	opts := InboundOptions{
+		SocksPort: 1080, HTTPPort: 8080, MixedPort: 2080,
+		Username: "user", Password: "pass", UDP: true, Sniffing: true,
+	}
+	accounts := []InboundAccount{{User: "user", Pass: "pass"}}
+	socks := &InboundSettings{Auth: "password", Accounts: accounts, UDP: true}
+	sniffing := &SniffingConfig{Enabled: true, DestOverride: DefaultDestOverride}
+	want := []InboundConfig{
+		{Tag: "mixed-in", Port: 2080, Listen: "127.0.0.1", Protocol: "socks", Settings: socks, Sniffing: sniffing},
+		{Tag: "socks-in", Port: 1080, Listen: "127.0.0.1", Protocol: "socks", Settings: socks, Sniffing: sniffing},
+		{Tag: "http-in", Port: 8080, Listen: "127.0.0.1", Protocol: "http", Settings: &InboundSettings{Accounts: accounts}, Sniffing: sniffing},
+	}
+	if got := XrayInbounds(opts); !reflect.DeepEqual(got, want) {
+		t.Errorf("inbounds = %+v\nwant %+v", got, want)
+	}
+
+	want = []InboundConfig{{Tag: "socks-in", Port: DefaultLocalPort, Listen: "127.0.0.1", Protocol: "socks", Settings: &InboundSettings{Auth: "noauth"}}}
+	if got := XrayInbounds(InboundOptions{}); !reflect.DeepEqual(got, want) {
+		t.Errorf("default inbounds = %+v\nwant %+v", got, want)
+	}
+}
+
+func TestSingBoxInbounds(t *testing.T) {
+	opts := InboundOptions{
+		Listen: "0.0.0.0", SocksPort: 1080, MixedPort: 2080,
+		Username: "user", Password: "pass", Sniffing: true, DestOverride: []string{"tls"},
+	}
+	users := []SingBoxUser{{Username: "user", Password: "pass"}}
+	want := []SingBoxInbound{
+		{Type: "mixed", Tag: "mixed-in", Listen: "0.0.0.0", ListenPort: 2080, Users: users},
+		{Type: "socks", Tag: "socks-in", Listen: "0.0.0.0", ListenPort: 1080, Users: users},
+	}
+	if got := SingBoxInbounds(opts); !reflect.DeepEqual(got, want) {
+		t.Errorf("inbounds = %+v\nwant %+v", got, want)
+	}
+
+	want = []SingBoxInbound{{Type: "http", Tag: "http-in", Listen: "127.0.0.1", ListenPort: 8080}}
+	if got := SingBoxInbounds(InboundOptions{HTTPPort: 8080}); !reflect.DeepEqual(got, want) {
+		t.Errorf("http inbounds = %+v\nwant %+v", got, want)
+	}
+}
+
+func TestInboundOptionsClientAddress(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		opts InboundOptions
+		want string
+	}{
+		{"default", InboundOptions{}, "socks5://127.0.0.1:10808"},
+		{"listen", InboundOptions{Listen: "192.168.1.5", MixedPort: 2080}, "socks5://192.168.1.5:2080"},
+		{"unspecified", InboundOptions{Listen: "0.0.0.0", HTTPPort: 8080}, "http://127.0.0.1:8080"},
+		{"unspecified ipv6", InboundOptions{Listen: "::", SocksPort: 1080}, "socks5://[::1]:1080"},
+		{"auth", InboundOptions{Listen: "192.168.1.5", HTTPPort: 8080, Username: "user", Password: "pass", LocalPort: 20000}, "socks5://127.0.0.1:20000"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.opts.ProxyURL(); got != test.want {
+				t.Errorf("ProxyURL() = %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestInboundsKeepLocalInboundUnauthenticated(t *testing.T) {
+	opts := InboundOptions{Listen: "0.0.0.0", MixedPort: 2080, Username: "user", Password: "pass", LocalPort: 20000}
+	if port := opts.ProbePort(); port != 20000 {
+		t.Errorf("ProbePort() = %d, want 20000", port)
+	}
+
+	xray := XrayInbounds(opts)
+	if len(xray) != 2 {
+		t.Fatalf("got %d xray inbounds, want 2", len(xray))
+	}
+	if settings := xray[0].Settings; settings.Auth != "password" || len(settings.Accounts) != 1 {
+		t.Errorf("mixed inbound settings = %+v, want password auth", settings)
+	}
+	if local := xray[1]; local.Listen != "127.0.0.1" || local.Port != 20000 || local.Settings.Auth != "noauth" {
+		t.Errorf("local inbound = %+v, want noauth on 127.0.0.1:20000", local)
+	}
+
+	singBox := SingBoxInbounds(opts)
+	if len(singBox) != 2 {
+		t.Fatalf("got %d sing-box inbounds, want 2", len(singBox))
+	}
+	if local := singBox[1]; local.Listen != "127.0.0.1" || local.ListenPort != 20000 || local.Users != nil {
+		t.Errorf("local inbound = %+v, want no users on 127.0.0.1:20000", local)
+	}
+}
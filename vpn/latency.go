@@ -0,0 +1,207 @@
+package vpn
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const DefaultTestURL = "https://www.gstatic.com/generate_204"
+
+type LatencyResult struct {
+	// Index is the position of Link in the slice given to Test.
+	Index int
+	Link  Link
+	TCP   time.Duration
+	HTTP  time.Duration
+	Err   error
+}
+
+// Latency is the HTTP round trip when it was measured, the TCP connect time
+// otherwise.
+func (r LatencyResult) Latency() time.Duration {
+	if r.HTTP > 0 {
+		return r.HTTP
+	}
+	return r.TCP
+}
+
+type LatencyTester struct {
+	Concurrency int
+	Timeout     time.Duration
+	// CorePath enables the URL test: each server is started in a temporary
+	// core and TestURL is fetched through its SOCKS inbound.
+	CorePath string
+	Core     string
+	TestURL  string
+	// WorkDir holds the temporary core configs, os.TempDir by default.
+	WorkDir string
+}
+
+// Test measures every link and returns the results sorted by latency, with
+// failed servers last in input order.
+func (t *LatencyTester) Test(ctx context.Context, links []Link) []LatencyResult {
+	concurrency := t.Concurrency
+	if concurrency <= 0 {
+		concurrency = 8
+	}
+
+	results := make([]LatencyResult, len(links))
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for idx, link := range links {
+		results[idx] = LatencyResult{Index: idx, Link: link}
+		wg.Add(1)
+		go func(result *LatencyResult) {
+			defer wg.Done()
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+			case <-ctx.Done():
+				result.Err = ctx.Err()
+				return
+			}
+			t.test(ctx, result)
+		}(&results[idx])
+	}
+	wg.Wait()
+
+	SortLatencyResults(results)
+	return results
+}
+
+func SortLatencyResults(results []LatencyResult) {
+	sort.SliceStable(results, func(i, j int) bool {
+		if (results[i].Err == nil) != (results[j].Err == nil) {
+			return results[i].Err == nil
+		}
+		if results[i].Err != nil {
+			return results[i].Index < results[j].Index
+		}
+		return results[i].Latency() < results[j].Latency()
+	})
+}
+
+func (t *LatencyTester) test(ctx context.Context, result *LatencyResult) {
+	timeout := t.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if udpOnly(result.Link) {
+		// QUIC and WireGuard servers do not have to accept TCP connections,
+		// so only the URL test can tell whether they work.
+		if t.CorePath == "" {
+			result.Err = fmt.Errorf("%s servers need the url test", result.Link.Protocol)
+			return
+		}
+		result.HTTP, result.Err = t.urlTest(ctx, result.Link)
+		return
+	}
+	result.TCP, result.Err = TCPLatency(ctx, result.Link)
+	if result.Err != nil || t.CorePath == "" {
+		return
+	}
+	result.HTTP, result.Err = t.urlTest(ctx, result.Link)
+}
+
+func udpOnly(link Link) bool {
+	switch link.Protocol {
+	case "hysteria2", "tuic", "wireguard":
+		return true
+	default:
+		return false
+	}
+}
+
+// TCPLatency measures the time to open a TCP connection to the server.
+func TCPLatency(ctx context.Context, link Link) (time.Duration, error) {
+	var dialer net.Dialer
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(link.Address, strconv.Itoa(link.Port)))
+	if err != nil {
+		return 0, fmt.Errorf("tcp connect: %w", err)
+	}
+	elapsed := time.Since(start)
+	conn.Close()
+	return elapsed, nil
+}
+
+func (t *LatencyTester) urlTest(ctx context.Context, link Link) (time.Duration, error) {
+	port, err := FreePort()
+	if err != nil {
+		return 0, err
+	}
+	config, err := BuildCoreConfig(t.Core, link, CoreOptions{Inbound: InboundOptions{SocksPort: port}})
+	if err != nil {
+		return 0, err
+	}
+
+	dir, err := os.MkdirTemp(t.WorkDir, "urltest-*")
+	if err != nil {
+		return 0, fmt.Errorf("create url test dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	configPath := filepath.Join(dir, "config.json")
+	if err := WriteConfig(configPath, config); err != nil {
+		return 0, err
+	}
+
+	logger := zerolog.Nop()
+	supervisor := NewSupervisor(SupervisorOptions{
+		CorePath:    t.CorePath,
+		ConfigPath:  configPath,
+		ReadyPort:   port,
+		MaxRestarts: -1,
+		Logger:      &logger,
+	})
+	if err := supervisor.Start(ctx); err != nil {
+		return 0, err
+	}
+	defer supervisor.Stop()
+
+	return HTTPLatency(ctx, net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), firstNonEmpty(t.TestURL, DefaultTestURL))
+}
+
+// HTTPLatency fetches testURL through the SOCKS inbound at socksAddress and
+// returns the round trip of the second request, so that the proxy handshake
+// done by the first one is not counted.
+func HTTPLatency(ctx context.Context, socksAddress string, testURL string) (time.Duration, error) {
+	proxyURL := &url.URL{Scheme: "socks5", Host: socksAddress}
+	transport := &http.Transport{Proxy: http.ProxyURL(proxyURL)}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport}
+
+	var elapsed time.Duration
+	for attempt := 0; attempt < 2; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, testURL, nil)
+		if err != nil {
+			return 0, fmt.Errorf("create url test request: %w", err)
+		}
+		start := time.Now()
+		resp, err := client.Do(req)
+		if err != nil {
+			return 0, fmt.Errorf("url test: %w", err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		elapsed = time.Since(start)
+		if resp.StatusCode < 200 || resp.StatusCode > 399 {
+			return 0, fmt.Errorf("url test: unexpected status %s", resp.Status)
+		}
+	}
+	return elapsed, nil
+}
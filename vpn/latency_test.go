@@ -0,0 +1,376 @@
+package vpn
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// runFakeSocksCore serves a minimal no-auth SOCKS5 CONNECT proxy on the
+// local inbound of the Xray or sing-box config at configPath until
+// interrupted. A proxy outbound whose password is "unreachable" stands for
+// a dead server: every CONNECT through it fails. One whose password is
+// "slow" holds every response back for fakeSlowDelay.
+func runFakeSocksCore(configPath string) int {
+	raw, err := os.ReadFile(configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	port, password, err := fakeCoreEndpoint(raw)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveFakeSocks(conn, password)
+		}
+	}()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	<-interrupt
+	return 0
+}
+
+// fakeCoreEndpoint returns the local SOCKS port and the proxy password of
+// an Xray or sing-box config.
+func fakeCoreEndpoint(raw []byte) (int, string, error) {
+	var xray XrayConfig
+	if err := json.Unmarshal(raw, &xray); err == nil {
+		if port, err := LocalSocksPort(xray); err == nil {
+			var password string
+			if settings, ok := xray.Outbounds[0].Settings.(ServersSettings); ok && len(settings.Servers) > 0 {
+				password = settings.Servers[0].Password
+			}
+			return port, password, nil
+		}
+	}
+	var singBox SingBoxConfig
+	if err := json.Unmarshal(raw, &singBox); err != nil {
+		return 0, "", err
+	}
+	if len(singBox.Inbounds) == 0 || len(singBox.Outbounds) == 0 {
+		return 0, "", errors.New("no inbound or outbound in config")
+	}
+	return singBox.Inbounds[0].ListenPort, singBox.Outbounds[0].Password, nil
+}
+
+const fakeSlowDelay = 300 * time.Millisecond
+
+func serveFakeSocks(conn net.Conn, password string) {
+	defer conn.Close()
+	greeting := make([]byte, 2)
+	if _, err := io.ReadFull(conn, greeting); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, make([]byte, greeting[1])); err != nil {
+		return
+	}
+	if _, err := conn.Write([]byte{5, 0}); err != nil {
+		return
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil || header[1] != 1 {
+		return
+	}
+	var host string
+	switch header[3] {
+	case 1, 4:
+		size := net.IPv4len
+		if header[3] == 4 {
+			size = net.IPv6len
+		}
+		ip := make([]byte, size)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return
+		}
+		host = net.IP(ip).String()
+	case 3:
+		size := make([]byte, 1)
+		if _, err := io.ReadFull(conn, size); err != nil {
+			return
+		}
+		name := make([]byte, size[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return
+		}
+		host = string(name)
+	default:
+		return
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return
+	}
+
+	if password == "unreachable" {
+		_, _ = conn.Write([]byte{5, 4, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+	if err != nil {
+		_, _ = conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer target.Close()
+	if _, err := conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0}); err != nil {
+		return
+	}
+	go func() {
+		_, _ = io.Copy(target, conn)
+		target.Close()
+	}()
+	if password != "slow" {
+		_, _ = io.Copy(conn, target)
+		return
+	}
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := target.Read(buf)
+		if n > 0 {
+			time.Sleep(fakeSlowDelay)
+			if _, err := conn.Write(buf[:n]); err != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func listenerLink(t *testing.T) (Link, func()) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	link := Link{Protocol: "trojan", Address: "127.0.0.1", Port: listener.Addr().(*net.TCPAddr).Port, Password: "secret"}
+	return link, func() { listener.Close() }
+}
+
+func closedPortLink(t *testing.T) Link {
+	t.Helper()
+	port, err := FreePort()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Link{Protocol: "trojan", Address: "127.0.0.1", Port: port, Password: "secret"}
+}
+
+func TestTCPLatency(t *testing.T) {
+	link, closeListener := listenerLink(t)
+	defer closeListener()
+
+	if latency, err := TCPLatency(context.Background(), link); err != nil || latency <= 0 {
+		t.Errorf("TCPLatency on a listener = %s, %v", latency, err)
+	}
+	if _, err := TCPLatency(context.Background(), closedPortLink(t)); err == nil {
+		t.Error("TCPLatency succeeded on a closed port")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := TCPLatency(ctx, link); !errors.Is(err, context.Canceled) {
+		t.Errorf("TCPLatency with a cancelled context = %v", err)
+	}
+}
+
+func TestLatencyTesterSortsResults(t *testing.T) {
+	up1, close1 := listenerLink(t)
+	defer close1()
+	up2, close2 := listenerLink(t)
+	defer close2()
+	links := []Link{closedPortLink(t), up1, closedPortLink(t), up2}
+
+	tester := LatencyTester{Timeout: time.Second}
+	results := tester.Test(context.Background(), links)
+	if len(results) != len(links) {
+		t.Fatalf("got %d results", len(results))
+	}
+	for idx, result := range results[:2] {
+		if result.Err != nil || result.TCP <= 0 || result.HTTP != 0 {
+			t.Errorf("result %d = %+v, want a TCP latency", idx, result)
+		}
+	}
+	if results[0].Latency() > results[1].Latency() {
+		t.Errorf("successes not sorted by latency: %s > %s", results[0].Latency(), results[1].Latency())
+	}
+	for idx, wantIndex := range []int{0, 2} {
+		result := results[2+idx]
+		if result.Err == nil || result.Index != wantIndex || result.Link.Port != links[wantIndex].Port {
+			t.Errorf("failure %d = %+v, want input %d", idx, result, wantIndex)
+		}
+	}
+}
+
+func TestLatencyTesterCancelled(t *testing.T) {
+	link, closeListener := listenerLink(t)
+	defer closeListener()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	tester := LatencyTester{Concurrency: 1}
+	for _, result := range tester.Test(ctx, []Link{link, link, link}) {
+		if result.Err == nil {
+			t.Errorf("result %+v has no error", result)
+		}
+	}
+}
+
+func TestLatencyTesterQUICNeedsURLTest(t *testing.T) {
+	link := Link{Protocol: "hysteria2", Address: "127.0.0.1", Port: 1, Password: "secret"}
+	results := (&LatencyTester{}).Test(context.Background(), []Link{link})
+	if len(results) != 1 || results[0].Err == nil || !strings.Contains(results[0].Err.Error(), "hysteria2 servers need the url test") {
+		t.Errorf("results = %+v", results)
+	}
+}
+
+func TestSortLatencyResults(t *testing.T) {
+	failure := errors.New("failed")
+	results := []LatencyResult{
+		{Index: 0, Err: failure},
+		{Index: 1, TCP: 30 * time.Millisecond},
+		{Index: 2, TCP: 5 * time.Millisecond, HTTP: 80 * time.Millisecond},
+		{Index: 3, Err: failure},
+		{Index: 4, TCP: 40 * time.Millisecond, HTTP: 50 * time.Millisecond},
+	}
+	SortLatencyResults(results)
+	var order []int
+	for _, result := range results {
+		order = append(order, result.Index)
+	}
+	if fmt.Sprint(order) != "[1 4 2 0 3]" {
+		t.Errorf("sorted order = %v, want [1 4 2 0 3]", order)
+	}
+}
+
+func newFakeSocksTester(t *testing.T) LatencyTester {
+	t.Helper()
+	t.Setenv("VPN_FAKE_CORE", "socks")
+	t.Setenv("VPN_FAKE_CORE_LOG", "")
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return LatencyTester{CorePath: executable, Timeout: 10 * time.Second}
+}
+
+func TestLatencyTesterURLTest(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			seen := maxInFlight.Load()
+			if current <= seen || maxInFlight.CompareAndSwap(seen, current) {
+				break
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	// The servers themselves are only dialed for the TCP test, so any
+	// listener will do.
+	address := server.Listener.Addr().(*net.TCPAddr)
+	links := make([]Link, 5)
+	for idx := range links {
+		links[idx] = Link{Protocol: "trojan", Address: "127.0.0.1", Port: address.Port, Password: "secret"}
+	}
+
+	tester := newFakeSocksTester(t)
+	tester.Concurrency = 2
+	tester.TestURL = server.URL
+	for _, result := range tester.Test(context.Background(), links) {
+		if result.Err != nil || result.HTTP < 50*time.Millisecond || result.Latency() != result.HTTP {
+			t.Errorf("result %d = %+v, want an HTTP latency of at least 50ms", result.Index, result)
+		}
+	}
+	if got := maxInFlight.Load(); got > 2 {
+		t.Errorf("%d servers were tested at once, want at most 2", got)
+	}
+}
+
+func TestLatencyTesterTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	address := server.Listener.Addr().(*net.TCPAddr)
+	link := Link{Protocol: "trojan", Address: "127.0.0.1", Port: address.Port, Password: "secret"}
+	tester := newFakeSocksTester(t)
+	tester.Timeout = time.Second
+	tester.TestURL = server.URL
+
+	start := time.Now()
+	results := tester.Test(context.Background(), []Link{link})
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Test took %s with a 1s timeout", elapsed)
+	}
+	if !errors.Is(results[0].Err, context.DeadlineExceeded) {
+		t.Errorf("result error = %v, want a deadline error", results[0].Err)
+	}
+}
+
+func TestLatencyTesterURLTestSingBox(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	address := server.Listener.Addr().(*net.TCPAddr)
+	links := []Link{
+		{Protocol: "trojan", Address: "127.0.0.1", Port: address.Port, Password: "unreachable"},
+		{Protocol: "trojan", Address: "127.0.0.1", Port: address.Port, Password: "secret"},
+	}
+	tester := newFakeSocksTester(t)
+	tester.Core = CoreSingBox
+	tester.TestURL = server.URL
+	results := tester.Test(context.Background(), links)
+	if results[0].Index != 1 || results[0].Err != nil || results[0].HTTP <= 0 {
+		t.Errorf("first result = %+v, want the reachable server", results[0])
+	}
+	if results[1].Index != 0 || results[1].Err == nil {
+		t.Errorf("second result = %+v, want the unreachable server to fail", results[1])
+	}
+}
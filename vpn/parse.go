@@ -2,6 +2,7 @@ package vpn
 
 import (
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -13,21 +14,67 @@ import (
 
 var ErrUnsupportedProtocol = errors.New("unsupported protocol")
 
+var shadowsocks2022KeySizes = map[string]int{
+	"2022-blake3-aes-128-gcm":       16,
+	"2022-blake3-aes-256-gcm":       32,
+	"2022-blake3-chacha20-poly1305": 32,
+}
+
 type vmessPayload struct {
-	Version     string `json:"v"`
-	Name        string `json:"ps"`
-	Address     string `json:"add"`
-	Port        string `json:"port"`
-	ID          string `json:"id"`
-	AlterID     string `json:"aid"`
-	Network     string `json:"net"`
-	Type        string `json:"type"`
-	Host        string `json:"host"`
-	Path        string `json:"path"`
-	TLS         string `json:"tls"`
-	SNI         string `json:"sni"`
-	ALPN        string `json:"alpn"`
-	Fingerprint string `json:"fp"`
+	Version     vmessNumber `json:"v"`
+	Name        string      `json:"ps"`
+	Address     string      `json:"add"`
+	Port        vmessNumber `json:"port"`
+	ID          string      `json:"id"`
+	AlterID     vmessNumber `json:"aid"`
+	Cipher      string      `json:"scy,omitempty"`
+	Network     string      `json:"net"`
+	Type        string      `json:"type"`
+	Host        string      `json:"host"`
+	Path        string      `json:"path"`
+	TLS         string      `json:"tls"`
+	SNI         string      `json:"sni"`
+	ALPN        string      `json:"alpn"`
+	Fingerprint string      `json:"fp"`
+	// Insecure, Authority and Extra are not part of the v2rayN format but
+	// are read by newer clients.
+	Insecure  vmessBool `json:"allowInsecure,omitempty"`
+	Authority string    `json:"authority,omitempty"`
+	Extra     string    `json:"extra,omitempty"`
+}
+
+// vmessNumber is written as a string like v2rayN does, and read from a
+// number or a string as providers emit both.
+type vmessNumber int
+
+func (n vmessNumber) MarshalJSON() ([]byte, error) {
+	return json.Marshal(strconv.Itoa(int(n)))
+}
+
+func (n *vmessNumber) UnmarshalJSON(data []byte) error {
+	value, err := unmarshalInt(data)
+	if err != nil {
+		return err
+	}
+	*n = vmessNumber(value)
+	return nil
+}
+
+// vmessBool is read from a boolean, a number or a string.
+type vmessBool bool
+
+func (b *vmessBool) UnmarshalJSON(data []byte) error {
+	var number int
+	if err := json.Unmarshal(data, &number); err == nil {
+		*b = number != 0
+		return nil
+	}
+	value, err := unmarshalBool(data)
+	if err != nil {
+		return err
+	}
+	*b = vmessBool(value)
+	return nil
 }
 
 func ParseLink(raw string) (Link, error) {
@@ -36,6 +83,16 @@ func ParseLink(raw string) (Link, error) {
 		return parseVLESS(raw)
 	case strings.HasPrefix(raw, "vmess://"):
 		return parseVMess(raw)
+	case strings.HasPrefix(raw, "trojan://"):
+		return parseTrojan(raw)
+	case strings.HasPrefix(raw, "ss://"):
+		return parseShadowsocks(raw)
+	case strings.HasPrefix(raw, "hysteria2://"), strings.HasPrefix(raw, "hy2://"):
+		return parseHysteria2(raw)
+	case strings.HasPrefix(raw, "tuic://"):
+		return parseTUIC(raw)
+	case strings.HasPrefix(raw, "wireguard://"), strings.HasPrefix(raw, "wg://"):
+		return parseWireGuard(raw)
 	default:
 		return Link{}, ErrUnsupportedProtocol
 	}
@@ -56,60 +113,448 @@ func parseVLESS(raw string) (Link, error) {
 	}
 
 	query := parsed.Query()
-	return Link{
-		Protocol:      "vless",
-		Name:          urlDecodeFragment(parsed.Fragment),
+	link := Link{
+		Protocol:   "vless",
+		Name:       parsed.Fragment,
+		Address:    host,
+		Port:       port,
+		UUID:       uuid,
+		Encryption: query.Get("encryption"),
+		Security:   query.Get("security"),
+		Flow:       query.Get("flow"),
+		Raw:        raw,
+	}
+	if err := applyTransportQuery(&link, query); err != nil {
+		return Link{}, err
+	}
+	return link, nil
+}
+
+func parseTrojan(raw string) (Link, error) {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return Link{}, fmt.Errorf("parse trojan url: %w", err)
+	}
+	if parsed.User == nil || parsed.User.Username() == "" {
+		return Link{}, errors.New("missing password")
+	}
+	host, port, err := splitHostPort(parsed.Host, 443)
+	if err != nil {
+		return Link{}, err
+	}
+
+	query := parsed.Query()
+	link := Link{
+		Protocol: "trojan",
+		Name:     parsed.Fragment,
+		Address:  host,
+		Port:     port,
+		Password: parsed.User.Username(),
+		Security: firstNonEmpty(query.Get("security"), "tls"),
+		Flow:     query.Get("flow"),
+		Raw:      raw,
+	}
+	if err := applyTransportQuery(&link, query); err != nil {
+		return Link{}, err
+	}
+	if link.SNI == "" {
+		link.SNI = query.Get("peer")
+	}
+	// Trojan defaults to TLS, so plain servers are written as security=none.
+	if link.Security == "none" {
+		link.Security = ""
+	}
+	return link, nil
+}
+
+func parseHysteria2(raw string) (Link, error) {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return Link{}, fmt.Errorf("parse hysteria2 url: %w", err)
+	}
+	host, port, err := splitHostPort(parsed.Host, 443)
+	if err != nil {
+		return Link{}, err
+	}
+
+	// The auth string may itself be "user:pass".
+	var auth string
+	if parsed.User != nil {
+		auth = parsed.User.Username()
+		if password, ok := parsed.User.Password(); ok {
+			auth += ":" + password
+		}
+	}
+	query := parsed.Query()
+	link := Link{
+		Protocol:      "hysteria2",
+		Name:          parsed.Fragment,
 		Address:       host,
 		Port:          port,
-		UUID:          uuid,
-		Encryption:    query.Get("encryption"),
-		Security:      query.Get("security"),
-		Transport:     firstNonEmpty(query.Get("type"), query.Get("transport"), "tcp"),
+		Password:      auth,
+		Security:      "tls",
 		SNI:           query.Get("sni"),
-		Host:          query.Get("host"),
-		Path:          query.Get("path"),
-		Fingerprint:   query.Get("fp"),
-		Flow:          query.Get("flow"),
 		ALPN:          splitCSV(query.Get("alpn")),
-		ServiceName:   query.Get("serviceName"),
-		AllowInsecure: query.Get("allowInsecure") == "1",
+		AllowInsecure: parseBool(query.Get("insecure")),
+		Obfs:          query.Get("obfs"),
+		ObfsPassword:  query.Get("obfs-password"),
 		Raw:           raw,
+	}
+	if link.UpMbps, err = parseMbps(firstNonEmpty(query.Get("upmbps"), query.Get("up"))); err != nil {
+		return Link{}, fmt.Errorf("parse up bandwidth: %w", err)
+	}
+	if link.DownMbps, err = parseMbps(firstNonEmpty(query.Get("downmbps"), query.Get("down"))); err != nil {
+		return Link{}, fmt.Errorf("parse down bandwidth: %w", err)
+	}
+	return link, nil
+}
+
+func parseTUIC(raw string) (Link, error) {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return Link{}, fmt.Errorf("parse tuic url: %w", err)
+	}
+	if parsed.User == nil || parsed.User.Username() == "" {
+		return Link{}, errors.New("missing uuid")
+	}
+	password, _ := parsed.User.Password()
+	host, port, err := splitHostPort(parsed.Host, 443)
+	if err != nil {
+		return Link{}, err
+	}
+
+	query := parsed.Query()
+	return Link{
+		Protocol:          "tuic",
+		Name:              parsed.Fragment,
+		Address:           host,
+		Port:              port,
+		UUID:              parsed.User.Username(),
+		Password:          password,
+		Security:          "tls",
+		SNI:               query.Get("sni"),
+		ALPN:              splitCSV(query.Get("alpn")),
+		AllowInsecure:     parseBool(firstNonEmpty(query.Get("allow_insecure"), query.Get("insecure"), query.Get("allowInsecure"))),
+		CongestionControl: firstNonEmpty(query.Get("congestion_control"), query.Get("congestion_controller")),
+		UDPRelayMode:      query.Get("udp_relay_mode"),
+		Raw:               raw,
 	}, nil
 }
 
+// parseMbps parses a bandwidth in Mbps, with an optional unit such as
+// "100 Mbps" or "1 Gbps".
+func parseMbps(value string) (int, error) {
+	value = strings.ToLower(strings.ReplaceAll(value, " ", ""))
+	if value == "" {
+		return 0, nil
+	}
+	multiplier := 1
+	switch {
+	case strings.HasSuffix(value, "gbps"):
+		multiplier = 1000
+		value = strings.TrimSuffix(value, "gbps")
+	case strings.HasSuffix(value, "mbps"):
+		value = strings.TrimSuffix(value, "mbps")
+	}
+	mbps, err := strconv.Atoi(value)
+	if err != nil || mbps < 0 {
+		return 0, fmt.Errorf("invalid bandwidth %q", value)
+	}
+	return mbps * multiplier, nil
+}
+
+func parseShadowsocks(raw string) (Link, error) {
+	body := strings.TrimPrefix(raw, "ss://")
+	name := ""
+	if idx := strings.Index(body, "#"); idx >= 0 {
+		name = urlDecodeFragment(body[idx+1:])
+		body = body[:idx]
+	}
+
+	var userinfo string
+	if idx := strings.LastIndex(body, "@"); idx >= 0 {
+		// SIP002: userinfo is base64url(method:password), or percent-encoded
+		// plain text for 2022 ciphers.
+		userinfo = body[:idx]
+		body = body[idx+1:]
+		if unescaped, err := url.PathUnescape(userinfo); err == nil {
+			userinfo = unescaped
+		}
+		if !strings.Contains(userinfo, ":") {
+			decoded, err := decodeBase64(userinfo)
+			if err != nil {
+				return Link{}, fmt.Errorf("decode shadowsocks userinfo: %w", err)
+			}
+			userinfo = string(decoded)
+		}
+	} else {
+		// Legacy: base64(method:password@host:port), optionally followed by a query.
+		encoded, query, _ := strings.Cut(body, "?")
+		decoded, err := decodeBase64(strings.TrimSuffix(encoded, "/"))
+		if err != nil {
+			return Link{}, fmt.Errorf("decode shadowsocks payload: %w", err)
+		}
+		decodedText := string(decoded)
+		idx := strings.LastIndex(decodedText, "@")
+		if idx < 0 {
+			return Link{}, errors.New("missing shadowsocks server")
+		}
+		userinfo = decodedText[:idx]
+		body = decodedText[idx+1:]
+		if query != "" {
+			body += "?" + query
+		}
+	}
+
+	method, password, ok := strings.Cut(userinfo, ":")
+	if !ok || method == "" || password == "" {
+		return Link{}, errors.New("missing shadowsocks method or password")
+	}
+	method = strings.ToLower(method)
+	if err := validateShadowsocksKey(method, password); err != nil {
+		return Link{}, err
+	}
+
+	parsed, err := url.Parse("ss://" + body)
+	if err != nil {
+		return Link{}, fmt.Errorf("parse shadowsocks url: %w", err)
+	}
+	host, port, err := splitHostPort(parsed.Host, 8388)
+	if err != nil {
+		return Link{}, err
+	}
+
+	link := Link{
+		Protocol:  "shadowsocks",
+		Name:      name,
+		Address:   host,
+		Port:      port,
+		Password:  password,
+		Method:    method,
+		Transport: "tcp",
+		Raw:       raw,
+	}
+	if plugin := parsed.Query().Get("plugin"); plugin != "" {
+		pluginName, pluginOpts, _ := strings.Cut(plugin, ";")
+		link.Plugin = pluginName
+		link.PluginOpts = pluginOpts
+	}
+	return link, nil
+}
+
+func validateShadowsocksKey(method, password string) error {
+	keySize, ok := shadowsocks2022KeySizes[method]
+	if !ok {
+		return nil
+	}
+	// 2022 ciphers take base64 PSKs; multi-user servers join the identity
+	// PSKs and the user PSK with colons.
+	for idx, part := range strings.Split(password, ":") {
+		key, err := base64.StdEncoding.DecodeString(part)
+		if err != nil {
+			return fmt.Errorf("decode %s psk %d: %w", method, idx+1, err)
+		}
+		if len(key) != keySize {
+			return fmt.Errorf("%s psk %d: expected %d bytes, got %d", method, idx+1, keySize, len(key))
+		}
+	}
+	return nil
+}
+
+func applyTransportQuery(link *Link, query url.Values) error {
+	link.Transport = firstNonEmpty(query.Get("type"), query.Get("transport"), "tcp")
+	link.SNI = query.Get("sni")
+	link.Host = query.Get("host")
+	link.Path = query.Get("path")
+	link.Fingerprint = query.Get("fp")
+	link.ALPN = splitCSV(query.Get("alpn"))
+	link.ServiceName = query.Get("serviceName")
+	link.Authority = query.Get("authority")
+	link.Mode = query.Get("mode")
+	link.Extra = query.Get("extra")
+	link.HeaderType = query.Get("headerType")
+	link.Seed = query.Get("seed")
+	link.QUICSecurity = query.Get("quicSecurity")
+	link.QUICKey = query.Get("key")
+	link.AllowInsecure = parseBool(query.Get("allowInsecure"))
+	link.PublicKey = query.Get("pbk")
+	link.ShortID = query.Get("sid")
+	link.SpiderX = query.Get("spx")
+	link.MLDSA65Verify = query.Get("pqv")
+	if err := validateTransportParams(*link); err != nil {
+		return err
+	}
+	return validateRealityParams(*link)
+}
+
+func validateRealityParams(link Link) error {
+	if link.PublicKey != "" {
+		key, err := base64.RawURLEncoding.DecodeString(link.PublicKey)
+		if err != nil {
+			return fmt.Errorf("decode reality public key: %w", err)
+		}
+		if len(key) != 32 {
+			return fmt.Errorf("reality public key: expected 32 bytes, got %d", len(key))
+		}
+	}
+	if link.ShortID != "" {
+		if len(link.ShortID) > 16 || len(link.ShortID)%2 != 0 {
+			return fmt.Errorf("reality short id %q: expected an even number of hex digits, at most 16", link.ShortID)
+		}
+		if _, err := hex.DecodeString(link.ShortID); err != nil {
+			return fmt.Errorf("decode reality short id: %w", err)
+		}
+	}
+	if link.MLDSA65Verify != "" {
+		key, err := base64.RawURLEncoding.DecodeString(link.MLDSA65Verify)
+		if err != nil {
+			return fmt.Errorf("decode reality mldsa65 verify key: %w", err)
+		}
+		if len(key) != 1952 {
+			return fmt.Errorf("reality mldsa65 verify key: expected 1952 bytes, got %d", len(key))
+		}
+	}
+	return nil
+}
+
 func parseVMess(raw string) (Link, error) {
-	encoded := strings.TrimPrefix(raw, "vmess://")
+	encoded, fragment, _ := strings.Cut(strings.TrimPrefix(raw, "vmess://"), "#")
+	encoded, rawQuery, _ := strings.Cut(encoded, "?")
 	payloadBytes, err := decodeBase64(encoded)
 	if err != nil {
 		return Link{}, fmt.Errorf("decode vmess payload: %w", err)
 	}
+	if !strings.HasPrefix(strings.TrimSpace(string(payloadBytes)), "{") {
+		return parseShadowrocketVMess(raw, string(payloadBytes), rawQuery, fragment)
+	}
 
 	var payload vmessPayload
 	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
 		return Link{}, fmt.Errorf("unmarshal vmess payload: %w", err)
 	}
+	if payload.Port == 0 {
+		return Link{}, errors.New("missing vmess port")
+	}
+
+	link := Link{
+		Protocol:      "vmess",
+		Name:          payload.Name,
+		Address:       payload.Address,
+		Port:          int(payload.Port),
+		UUID:          payload.ID,
+		AlterID:       int(payload.AlterID),
+		Cipher:        payload.Cipher,
+		Security:      payload.TLS,
+		Transport:     firstNonEmpty(payload.Network, "tcp"),
+		SNI:           payload.SNI,
+		Host:          payload.Host,
+		Path:          payload.Path,
+		Fingerprint:   payload.Fingerprint,
+		ALPN:          splitCSV(payload.ALPN),
+		AllowInsecure: bool(payload.Insecure),
+		Authority:     payload.Authority,
+		Extra:         payload.Extra,
+		Raw:           raw,
+	}
+	if payload.Type != "none" {
+		link.HeaderType = payload.Type
+	}
+
+	// v2rayN reuses host, path and type for the options of transports
+	// without a host or path.
+	switch normalizeTransport(link.Transport) {
+	case "kcp":
+		link.Seed, link.Path = link.Path, ""
+	case "quic":
+		link.QUICSecurity, link.QUICKey = link.Host, link.Path
+		link.Host, link.Path = "", ""
+	case "grpc":
+		link.ServiceName, link.Path = link.Path, ""
+		link.Mode, link.HeaderType = link.HeaderType, ""
+	case "xhttp":
+		link.Mode, link.HeaderType = link.HeaderType, ""
+	}
+	return link, nil
+}
 
-	port, err := strconv.Atoi(payload.Port)
+// parseShadowrocketVMess parses the Shadowrocket variant, where the base64
+// payload is "cipher:uuid@host:port" and the options follow as a query.
+func parseShadowrocketVMess(raw, payload, rawQuery, fragment string) (Link, error) {
+	userinfo, address, ok := cutLast(strings.TrimSpace(payload), "@")
+	if !ok {
+		return Link{}, errors.New("vmess payload is neither json nor cipher:uuid@host:port")
+	}
+	cipher, uuid, ok := strings.Cut(userinfo, ":")
+	if !ok {
+		return Link{}, errors.New("missing vmess cipher or uuid")
+	}
+	host, port, err := splitHostPort(address, 0)
+	if err != nil {
+		return Link{}, err
+	}
+	if port == 0 {
+		return Link{}, errors.New("missing vmess port")
+	}
+	query, err := url.ParseQuery(rawQuery)
 	if err != nil {
-		return Link{}, fmt.Errorf("parse vmess port: %w", err)
+		return Link{}, fmt.Errorf("parse vmess query: %w", err)
 	}
 
-	return Link{
-		Protocol:    "vmess",
-		Name:        payload.Name,
-		Address:     payload.Address,
-		Port:        port,
-		UUID:        payload.ID,
-		Encryption:  payload.AlterID,
-		Security:    payload.TLS,
-		Transport:   firstNonEmpty(payload.Network, "tcp"),
-		SNI:         payload.SNI,
-		Host:        payload.Host,
-		Path:        payload.Path,
-		Fingerprint: payload.Fingerprint,
-		ALPN:        splitCSV(payload.ALPN),
-		Raw:         raw,
-	}, nil
+	link := Link{
+		Protocol:      "vmess",
+		Name:          firstNonEmpty(query.Get("remarks"), urlDecodeFragment(fragment)),
+		Address:       host,
+		Port:          port,
+		UUID:          uuid,
+		Cipher:        cipher,
+		Transport:     "tcp",
+		SNI:           firstNonEmpty(query.Get("peer"), query.Get("sni")),
+		Path:          query.Get("path"),
+		ALPN:          splitCSV(query.Get("alpn")),
+		AllowInsecure: parseBool(query.Get("allowInsecure")),
+		Raw:           raw,
+	}
+	if aid := query.Get("alterId"); aid != "" {
+		if link.AlterID, err = strconv.Atoi(aid); err != nil {
+			return Link{}, fmt.Errorf("parse vmess alterId: %w", err)
+		}
+	}
+	if parseBool(query.Get("tls")) {
+		link.Security = "tls"
+	}
+
+	// obfsParam is the Host header, sometimes as a JSON object.
+	obfsHost := query.Get("obfsParam")
+	var headers map[string]string
+	if json.Unmarshal([]byte(obfsHost), &headers) == nil {
+		obfsHost = headerValue(headers, "Host")
+	}
+	switch obfs := query.Get("obfs"); obfs {
+	case "", "none":
+	case "websocket", "ws":
+		link.Transport = "ws"
+		link.Host = obfsHost
+	case "http":
+		link.HeaderType = "http"
+		link.Host = obfsHost
+	case "h2":
+		link.Transport = "h2"
+		link.Host = obfsHost
+	case "grpc":
+		link.Transport = "grpc"
+		link.ServiceName, link.Path = link.Path, ""
+	default:
+		return Link{}, fmt.Errorf("unsupported vmess obfs %q", obfs)
+	}
+	return link, nil
+}
+
+func cutLast(value, sep string) (string, string, bool) {
+	idx := strings.LastIndex(value, sep)
+	if idx < 0 {
+		return value, "", false
+	}
+	return value[:idx], value[idx+len(sep):], true
 }
 
 func decodeBase64(data string) ([]byte, error) {
@@ -174,6 +619,15 @@ func firstNonEmpty(values ...string) string {
 	return ""
 }
 
+func parseBool(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "1", "true", "yes":
+		return true
+	default:
+		return false
+	}
+}
+
 func urlDecodeFragment(value string) string {
 	if value == "" {
 		return ""
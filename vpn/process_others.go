@@ -0,0 +1,14 @@
+//go:build !windows
+
+package vpn
+
+import (
+	"os"
+	"os/exec"
+)
+
+func configureCoreCmd(cmd *exec.Cmd) {}
+
+func interruptCore(proc *os.Process) error {
+	return proc.Signal(os.Interrupt)
+}
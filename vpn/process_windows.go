@@ -0,0 +1,52 @@
+package vpn
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	kernel32          = windows.NewLazySystemDLL("kernel32.dll")
+	procAttachConsole = kernel32.NewProc("AttachConsole")
+	procFreeConsole   = kernel32.NewProc("FreeConsole")
+
+	// consoleMu serializes the console attachments of interruptCore, as a
+	// process has at most one console.
+	consoleMu sync.Mutex
+)
+
+// configureCoreCmd keeps the core from opening a console window and starts
+// it in its own process group, so that interruptCore reaches the core alone.
+func configureCoreCmd(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		HideWindow:    true,
+		CreationFlags: windows.CREATE_NEW_PROCESS_GROUP,
+	}
+}
+
+// interruptCore sends CTRL_BREAK to the process group of the core, which Go
+// cores such as Xray and sing-box receive as os.Interrupt. The launcher is a
+// GUI program without a console, so it attaches to the hidden console of the
+// core for the duration of the call.
+func interruptCore(proc *os.Process) error {
+	pid := uint32(proc.Pid)
+	if err := windows.GenerateConsoleCtrlEvent(windows.CTRL_BREAK_EVENT, pid); err == nil {
+		return nil
+	}
+
+	consoleMu.Lock()
+	defer consoleMu.Unlock()
+	if ok, _, err := procAttachConsole.Call(uintptr(pid)); ok == 0 {
+		return fmt.Errorf("attach core console: %w", err)
+	}
+	defer procFreeConsole.Call()
+	if err := windows.GenerateConsoleCtrlEvent(windows.CTRL_BREAK_EVENT, pid); err != nil {
+		return fmt.Errorf("send ctrl-break to core: %w", err)
+	}
+	return nil
+}
@@ -1,17 +1,99 @@
 package vpn
 
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	RoutingPresetMinimal     = "minimal"
+	RoutingPresetDiscordFull = "discord-full"
+)
+
+// DiscordDomains are the Xray domain matchers of the Discord services,
+// including the CDN, media, update and attachment upload hosts.
+var DiscordDomains = []string{
+	"domain:discord.app",
+	"domain:discord.co",
+	"domain:discord.com",
+	"domain:discord.design",
+	"domain:discord.dev",
+	"domain:discord.gg",
+	"domain:discord.gift",
+	"domain:discord.gifts",
+	"domain:discord.media",
+	"domain:discord.new",
+	"domain:discord.store",
+	"domain:discord.tools",
+	"domain:discordactivities.com",
+	"domain:discordapp.com",
+	"domain:discordapp.io",
+	"domain:discordapp.net",
+	"domain:discordcdn.com",
+	"domain:discordmerch.com",
+	"domain:discordpartygames.com",
+	"domain:discordsays.com",
+	"domain:discordsez.com",
+	"domain:discordstatus.com",
+	"domain:dis.gd",
+	"full:discord-attachments-uploads-prd.storage.googleapis.com",
+}
+
+// DiscordVoicePorts are the UDP port ranges of the Discord voice servers.
+const DiscordVoicePorts = "19294-19344,50000-65535"
+
 type RoutingConfig struct {
-	DomainStrategy string        `json:"domainStrategy,omitempty"`
-	Rules          []RoutingRule `json:"rules"`
+	DomainStrategy string           `json:"domainStrategy,omitempty"`
+	Rules          []RoutingRule    `json:"rules"`
+	Balancers      []BalancerConfig `json:"balancers,omitempty"`
 }
 
 type RoutingRule struct {
-	Type     string   `json:"type"`
-	Domain   []string `json:"domain,omitempty"`
-	IP       []string `json:"ip,omitempty"`
-	Port     string   `json:"port,omitempty"`
-	Network  string   `json:"network,omitempty"`
-	Outbound string   `json:"outboundTag"`
+	Type        string   `json:"type"`
+	InboundTag  []string `json:"inboundTag,omitempty"`
+	Domain      []string `json:"domain,omitempty"`
+	IP          []string `json:"ip,omitempty"`
+	Port        string   `json:"port,omitempty"`
+	Network     string   `json:"network,omitempty"`
+	Protocol    []string `json:"protocol,omitempty"`
+	Outbound    string   `json:"outboundTag,omitempty"`
+	BalancerTag string   `json:"balancerTag,omitempty"`
+}
+
+// UnmarshalJSON accepts ports written as numbers and domain or IP lists
+// written as a single string.
+func (r *RoutingRule) UnmarshalJSON(data []byte) error {
+	type plainRule RoutingRule
+	var raw struct {
+		plainRule
+		Domain json.RawMessage `json:"domain"`
+		IP     json.RawMessage `json:"ip"`
+		Port   json.RawMessage `json:"port"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	rule := RoutingRule(raw.plainRule)
+
+	var err error
+	if rule.Domain, err = unmarshalStringList(raw.Domain); err != nil {
+		return fmt.Errorf("rule domain: %w", err)
+	}
+	if rule.IP, err = unmarshalStringList(raw.IP); err != nil {
+		return fmt.Errorf("rule ip: %w", err)
+	}
+	if len(raw.Port) > 0 && string(raw.Port) != "null" {
+		var port json.Number
+		if err := json.Unmarshal(raw.Port, &port); err == nil {
+			rule.Port = port.String()
+		} else if err := json.Unmarshal(raw.Port, &rule.Port); err != nil {
+			return fmt.Errorf("rule port: %w", err)
+		}
+	}
+	rule.Port = strings.TrimSpace(rule.Port)
+	*r = rule
+	return nil
 }
 
 func DefaultRouting() *RoutingConfig {
@@ -37,6 +119,55 @@ func DefaultRouting() *RoutingConfig {
 	}
 }
 
+// RoutingPreset returns the routing of a named preset. The minimal preset,
+// also used for an empty name, only proxies the Discord web hosts;
+// discord-full proxies every Discord domain and the voice traffic.
+func RoutingPreset(name string) (*RoutingConfig, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", RoutingPresetMinimal:
+		return DefaultRouting(), nil
+	case RoutingPresetDiscordFull:
+		return DiscordFullRouting(), nil
+	default:
+		return nil, fmt.Errorf("unknown routing preset %q", name)
+	}
+}
+
+func DiscordFullRouting() *RoutingConfig {
+	return &RoutingConfig{
+		DomainStrategy: "AsIs",
+		Rules: []RoutingRule{
+			{
+				// Private addresses go first so that LAN traffic in
+				// the voice port ranges stays direct.
+				Type:     "field",
+				IP:       []string{"geoip:private"},
+				Outbound: "direct",
+			},
+			{
+				Type:     "field",
+				Domain:   append([]string(nil), DiscordDomains...),
+				Outbound: "proxy",
+			},
+			{
+				Type:     "field",
+				Port:     DiscordVoicePorts,
+				Network:  "udp",
+				Outbound: "proxy",
+			},
+		},
+	}
+}
+
+// cloneRouting copies routing so that the With* helpers, which rewrite
+// rules in place, leave the original untouched.
+func cloneRouting(routing *RoutingConfig) *RoutingConfig {
+	clone := *routing
+	clone.Rules = append([]RoutingRule(nil), routing.Rules...)
+	clone.Balancers = append([]BalancerConfig(nil), routing.Balancers...)
+	return &clone
+}
+
 func WithOutboundTags(config XrayConfig, proxyTag string) XrayConfig {
 	if config.Routing == nil {
 		config.Routing = DefaultRouting()
@@ -49,3 +180,25 @@ func WithOutboundTags(config XrayConfig, proxyTag string) XrayConfig {
 	}
 	return config
 }
+
+// WithBalancerTag points the rules that target the proxy outbound at a
+// balancer instead, and ends the rules with a catch-all balancer rule, as
+// unmatched traffic would otherwise take the first outbound directly.
+func WithBalancerTag(config XrayConfig, balancerTag string) XrayConfig {
+	if config.Routing == nil {
+		config.Routing = DefaultRouting()
+	}
+	for idx, rule := range config.Routing.Rules {
+		if rule.Outbound == "proxy" {
+			rule.Outbound = ""
+			rule.BalancerTag = balancerTag
+			config.Routing.Rules[idx] = rule
+		}
+	}
+	config.Routing.Rules = append(config.Routing.Rules, RoutingRule{
+		Type:        "field",
+		Network:     "tcp,udp",
+		BalancerTag: balancerTag,
+	})
+	return config
+}
@@ -0,0 +1,150 @@
+package vpn
+
+import (
+	"encoding/json"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRoutingPreset(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		want *RoutingConfig
+	}{
+		{"", DefaultRouting()},
+		{"minimal", DefaultRouting()},
+		{" Discord-Full ", DiscordFullRouting()},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := RoutingPreset(test.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("routing = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+
+	if _, err := RoutingPreset("everything"); err == nil || !strings.Contains(err.Error(), `unknown routing preset "everything"`) {
+		t.Errorf("got error %v for an unknown preset", err)
+	}
+}
+
+func TestDiscordFullRouting(t *testing.T) {
+	routing := DiscordFullRouting()
+	private := RoutingRule{Type: "field", IP: []string{"geoip:private"}, Outbound: "direct"}
+	if !reflect.DeepEqual(routing.Rules[0], private) {
+		t.Errorf("private rule = %+v, want %+v", routing.Rules[0], private)
+	}
+	if !reflect.DeepEqual(routing.Rules[1].Domain, DiscordDomains) || routing.Rules[1].Outbound != "proxy" {
+		t.Errorf("domain rule = %+v", routing.Rules[1])
+	}
+	voice := RoutingRule{Type: "field", Port: DiscordVoicePorts, Network: "udp", Outbound: "proxy"}
+	if !reflect.DeepEqual(routing.Rules[2], voice) {
+		t.Errorf("voice rule = %+v, want %+v", routing.Rules[2], voice)
+	}
+
+	// LAN traffic in the voice port range stays direct.
+	match, err := ExplainRoute(routing, RouteRequest{IP: net.ParseIP("192.168.1.20"), Port: 50010, Network: "udp"}, nil)
+	if err != nil || match.Outbound != OutboundDirect {
+		t.Errorf("LAN voice traffic = %+v, %v, want direct", match, err)
+	}
+
+	// Each call returns its own rules.
+	routing.Rules[1].Domain[0] = "domain:example.com"
+	if DiscordFullRouting().Rules[1].Domain[0] == "domain:example.com" || DiscordDomains[0] == "domain:example.com" {
+		t.Error("DiscordFullRouting shares its domain list")
+	}
+}
+
+func TestBuildCoreConfigRouting(t *testing.T) {
+	link := Link{Protocol: "trojan", Address: "example.com", Port: 443, Password: "secret"}
+	routing := DiscordFullRouting()
+	want := DiscordFullRouting()
+
+	config, err := BuildCoreConfig(CoreXray, link, CoreOptions{Routing: routing, DNS: &DNSConfig{Tag: DNSTag}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	xray := config.(XrayConfig)
+	if len(xray.Routing.Rules) != len(want.Rules)+1 || !reflect.DeepEqual(xray.Routing.Rules[1:], want.Rules) {
+		t.Errorf("xray rules = %+v", xray.Routing.Rules)
+	}
+	if !reflect.DeepEqual(routing, want) {
+		t.Errorf("BuildCoreConfig changed the routing: %+v", routing)
+	}
+
+	config, err = BuildCoreConfig(CoreSingBox, link, CoreOptions{Routing: routing})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rules := config.(SingBoxConfig).Route.Rules; len(rules) != 3 || !reflect.DeepEqual(rules[2].Network, singBoxListable{"udp"}) {
+		t.Errorf("sing-box rules = %+v", rules)
+	}
+
+	balanced, err := BuildBalancedXrayConfig(balancerTestLinks(), BalancerOptions{Routing: routing})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule := balanced.Routing.Rules[2]; rule.Port != DiscordVoicePorts || rule.BalancerTag != "proxy-balancer" {
+		t.Errorf("balanced voice rule = %+v", rule)
+	}
+	if !reflect.DeepEqual(routing, want) {
+		t.Errorf("BuildBalancedXrayConfig changed the routing: %+v", routing)
+	}
+}
+
+// collectURLs appends every "url" string found in a decoded JSON value.
+func collectURLs(value interface{}, urls []string) []string {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, child := range value {
+			if text, ok := child.(string); ok && key == "url" {
+				urls = append(urls, text)
+				continue
+			}
+			urls = collectURLs(child, urls)
+		}
+	case []interface{}:
+		for _, child := range value {
+			urls = collectURLs(child, urls)
+		}
+	}
+	return urls
+}
+
+func TestDiscordFullRoutingProxiesPinnedUpdateHosts(t *testing.T) {
+	raw, err := os.ReadFile("../res/pinned_update.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pinned interface{}
+	if err := json.Unmarshal(raw, &pinned); err != nil {
+		t.Fatal(err)
+	}
+	urls := collectURLs(pinned, nil)
+	if len(urls) == 0 {
+		t.Fatal("no urls in pinned_update.json")
+	}
+
+	routing := DiscordFullRouting()
+	for _, rawURL := range urls {
+		parsed, err := url.Parse(rawURL)
+		if err != nil {
+			t.Fatalf("parse %q: %v", rawURL, err)
+		}
+		req := RouteRequest{Domain: parsed.Hostname(), Port: 443, Network: "tcp"}
+		match, err := ExplainRoute(routing, req, nil)
+		if err != nil {
+			t.Fatalf("ExplainRoute(%s): %v", req, err)
+		}
+		if match.Rule < 0 || match.Outbound != OutboundProxy {
+			t.Errorf("%s goes to %q by rule %d, want the proxy by a preset rule", req, match.Outbound, match.Rule+1)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package vpn
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	OutboundProxy  = "proxy"
+	OutboundDirect = "direct"
+	OutboundBlock  = "block"
+)
+
+var ruleProtocols = map[string]bool{
+	"http":       true,
+	"tls":        true,
+	"quic":       true,
+	"bittorrent": true,
+}
+
+// RuleOptions is a routing rule as written in the launcher YAML config.
+type RuleOptions struct {
+	Domain   stringList `yaml:"domain"`
+	IP       stringList `yaml:"ip"`
+	Port     string     `yaml:"port"`
+	Network  string     `yaml:"network"`
+	Protocol stringList `yaml:"protocol"`
+	Outbound string     `yaml:"outbound"`
+}
+
+// stringList accepts a single scalar or a sequence. A scalar is one entry
+// and is not split on commas, which regexp matchers may contain.
+type stringList []string
+
+func (l *stringList) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*l = stringList{node.Value}
+		return nil
+	}
+	var list []string
+	if err := node.Decode(&list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+// ruleError is a validation error of a single rule field, and of one of
+// its list entries when value is set.
+type ruleError struct {
+	field string
+	value string
+	err   error
+}
+
+func (e *ruleError) Error() string {
+	return e.field + ": " + e.err.Error()
+}
+
+func (e *ruleError) Unwrap() error {
+	return e.err
+}
+
+// ParseRoutingRules decodes and validates the YAML sequence of rules in
+// node. Errors report the line of the offending rule field. When geo is set,
+// geosite and geoip references must name existing categories.
+func ParseRoutingRules(node *yaml.Node, geo *GeoData) ([]RoutingRule, error) {
+	if node == nil {
+		return nil, nil
+	}
+	if node.Kind != yaml.SequenceNode {
+		return nil, fmt.Errorf("line %d: rules must be a list", node.Line)
+	}
+
+	rules := make([]RoutingRule, 0, len(node.Content))
+	for idx, item := range node.Content {
+		var opts RuleOptions
+		if err := item.Decode(&opts); err != nil {
+			return nil, fmt.Errorf("rule %d (line %d): %w", idx+1, item.Line, err)
+		}
+		rule, err := opts.Rule()
+		if err == nil && geo != nil {
+			err = validateGeoRefs(rule, geo)
+		}
+		if err != nil {
+			line := item.Line
+			var fieldErr *ruleError
+			if errors.As(err, &fieldErr) {
+				line = fieldLine(item, fieldErr.field, fieldErr.value)
+			}
+			return nil, fmt.Errorf("rule %d (line %d): %w", idx+1, line, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func fieldLine(item *yaml.Node, field, value string) int {
+	if item.Kind != yaml.MappingNode {
+		return item.Line
+	}
+	for idx := 0; idx+1 < len(item.Content); idx += 2 {
+		if item.Content[idx].Value != field {
+			continue
+		}
+		if values := item.Content[idx+1]; values.Kind == yaml.SequenceNode {
+			for _, entry := range values.Content {
+				if entry.Value == value {
+					return entry.Line
+				}
+			}
+		}
+		return item.Content[idx].Line
+	}
+	return item.Line
+}
+
+// Rule validates the options and converts them to an Xray routing rule.
+func (o RuleOptions) Rule() (RoutingRule, error) {
+	rule := RoutingRule{Type: "field"}
+
+	switch outbound := strings.ToLower(strings.TrimSpace(o.Outbound)); outbound {
+	case OutboundProxy, OutboundDirect, OutboundBlock:
+		rule.Outbound = outbound
+	case "":
+		return RoutingRule{}, &ruleError{field: "outbound", err: errors.New("missing outbound")}
+	default:
+		return RoutingRule{}, &ruleError{field: "outbound", err: fmt.Errorf("unknown outbound %q, expected proxy, direct or block", o.Outbound)}
+	}
+
+	for _, domain := range o.Domain {
+		if err := validateDomainMatcher(domain); err != nil {
+			return RoutingRule{}, &ruleError{field: "domain", value: domain, err: err}
+		}
+		rule.Domain = append(rule.Domain, domain)
+	}
+	for _, ip := range o.IP {
+		if err := validateIPMatcher(ip); err != nil {
+			return RoutingRule{}, &ruleError{field: "ip", value: ip, err: err}
+		}
+		rule.IP = append(rule.IP, ip)
+	}
+	if port := strings.TrimSpace(o.Port); port != "" {
+		if err := validatePortList(port); err != nil {
+			return RoutingRule{}, &ruleError{field: "port", err: err}
+		}
+		rule.Port = port
+	}
+	if network := strings.ToLower(strings.TrimSpace(o.Network)); network != "" {
+		for _, part := range splitCSV(network) {
+			if part != "tcp" && part != "udp" {
+				return RoutingRule{}, &ruleError{field: "network", err: fmt.Errorf("unknown network %q", part)}
+			}
+		}
+		rule.Network = network
+	}
+	for _, protocol := range o.Protocol {
+		if !ruleProtocols[protocol] {
+			return RoutingRule{}, &ruleError{field: "protocol", value: protocol, err: fmt.Errorf("unknown protocol %q", protocol)}
+		}
+		rule.Protocol = append(rule.Protocol, protocol)
+	}
+
+	if len(rule.Domain) == 0 && len(rule.IP) == 0 && rule.Port == "" && rule.Network == "" && len(rule.Protocol) == 0 {
+		return RoutingRule{}, errors.New("rule matches nothing")
+	}
+	return rule, nil
+}
+
+func validateGeoRefs(rule RoutingRule, geo *GeoData) error {
+	for _, domain := range rule.Domain {
+		if !strings.HasPrefix(domain, "geosite:") {
+			continue
+		}
+		sites, err := geo.Sites()
+		if err == nil {
+			_, err = sites.lookup(domain)
+		}
+		if err != nil {
+			return &ruleError{field: "domain", value: domain, err: err}
+		}
+	}
+	for _, ip := range rule.IP {
+		// A negated category must exist like a plain one.
+		ref := strings.Replace(ip, "geoip:!", "geoip:", 1)
+		if !strings.HasPrefix(ref, "geoip:") || ref == "geoip:private" {
+			continue
+		}
+		ips, err := geo.IPs()
+		if err == nil {
+			_, err = ips.lookup(ref)
+		}
+		if err != nil {
+			return &ruleError{field: "ip", value: ip, err: err}
+		}
+	}
+	return nil
+}
+
+func validateDomainMatcher(domain string) error {
+	prefix, value, ok := strings.Cut(domain, ":")
+	if !ok {
+		prefix, value = "", domain
+	}
+	if strings.TrimSpace(value) == "" {
+		return fmt.Errorf("empty matcher %q", domain)
+	}
+	switch prefix {
+	case "", "full", "domain", "keyword", "geosite":
+		return nil
+	case "regexp":
+		if _, err := regexp.Compile(value); err != nil {
+			return fmt.Errorf("invalid regexp %q: %w", value, err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown matcher prefix %q in %q", prefix, domain)
+	}
+}
+
+func validateIPMatcher(ip string) error {
+	if code, ok := strings.CutPrefix(ip, "geoip:"); ok {
+		if strings.TrimPrefix(code, "!") == "" {
+			return fmt.Errorf("empty matcher %q", ip)
+		}
+		return nil
+	}
+	if strings.Contains(ip, "/") {
+		if _, _, err := net.ParseCIDR(ip); err != nil {
+			return fmt.Errorf("invalid cidr %q", ip)
+		}
+		return nil
+	}
+	if net.ParseIP(ip) == nil {
+		return fmt.Errorf("invalid ip %q", ip)
+	}
+	return nil
+}
+
+func validatePortList(ports string) error {
+	for _, part := range splitCSV(ports) {
+		from, to, isRange := strings.Cut(part, "-")
+		first, err := parseRulePort(from)
+		if err != nil {
+			return err
+		}
+		if !isRange {
+			continue
+		}
+		last, err := parseRulePort(to)
+		if err != nil {
+			return err
+		}
+		if last < first {
+			return fmt.Errorf("invalid port range %q", part)
+		}
+	}
+	return nil
+}
+
+func parseRulePort(value string) (int, error) {
+	port, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %q", value)
+	}
+	return port, nil
+}
+
+// MergeRouting returns preset with rules applied before its own rules, or
+// only rules when replace is set.
+func MergeRouting(preset *RoutingConfig, rules []RoutingRule, replace bool) *RoutingConfig {
+	merged := cloneRouting(preset)
+	if replace {
+		merged.Rules = append([]RoutingRule(nil), rules...)
+	} else {
+		merged.Rules = append(append([]RoutingRule(nil), rules...), preset.Rules...)
+	}
+	return merged
+}
@@ -0,0 +1,218 @@
+package vpn
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// testGeoData returns geo data with a CN category and no geosite.dat.
+func testGeoData() *GeoData {
+	_, network, _ := net.ParseCIDR("1.0.1.0/24")
+	geo := &GeoData{}
+	geo.ipOnce.Do(func() {
+		geo.ips = GeoIPList{"CN": {Networks: []*net.IPNet{network}}}
+	})
+	return geo
+}
+
+func parseTestRules(t *testing.T, source string, geo *GeoData) ([]RoutingRule, error) {
+	t.Helper()
+	var root yaml.Node
+	if err := yaml.Unmarshal([]byte(source), &root); err != nil {
+		t.Fatal(err)
+	}
+	return ParseRoutingRules(root.Content[0], geo)
+}
+
+func TestParseRoutingRules(t *testing.T) {
+	rules, err := parseTestRules(t, `
+- domain: [domain:discord.com, "full:cdn.discordapp.com", geosite:category-ads]
+  outbound: Proxy
+- ip: [10.0.0.0/8, 2001:db8::1, geoip:private]
+  network: TCP,udp
+  outbound: direct
+- port: 50000-65535, 443
+  protocol: [bittorrent]
+  outbound: block
+`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []RoutingRule{
+		{Type: "field", Domain: []string{"domain:discord.com", "full:cdn.discordapp.com", "geosite:category-ads"}, Outbound: OutboundProxy},
+		{Type: "field", IP: []string{"10.0.0.0/8", "2001:db8::1", "geoip:private"}, Network: "tcp,udp", Outbound: OutboundDirect},
+		{Type: "field", Port: "50000-65535, 443", Protocol: []string{"bittorrent"}, Outbound: OutboundBlock},
+	}
+	if !reflect.DeepEqual(rules, want) {
+		t.Errorf("rules = %+v\nwant %+v", rules, want)
+	}
+
+	if rules, err := ParseRoutingRules(nil, nil); rules != nil || err != nil {
+		t.Errorf("ParseRoutingRules(nil) = %v, %v", rules, err)
+	}
+}
+
+func TestParseRoutingRulesKeepsScalarsWhole(t *testing.T) {
+	rules, err := parseTestRules(t, `
+- domain: 'regexp:^a{1,3}\.com$'
+  outbound: block
+- domain: [domain:example.com, "keyword:a,b"]
+  protocol: tls
+  outbound: proxy
+`, nil)
+	if err != nil {
+		t.Fatalf("ParseRoutingRules: %v", err)
+	}
+	if want := []string{`regexp:^a{1,3}\.com$`}; !reflect.DeepEqual(rules[0].Domain, want) {
+		t.Errorf("domain = %q, want %q", rules[0].Domain, want)
+	}
+	if want := []string{"domain:example.com", "keyword:a,b"}; !reflect.DeepEqual(rules[1].Domain, want) {
+		t.Errorf("domain = %q, want %q", rules[1].Domain, want)
+	}
+	if want := []string{"tls"}; !reflect.DeepEqual(rules[1].Protocol, want) {
+		t.Errorf("protocol = %q, want %q", rules[1].Protocol, want)
+	}
+}
+
+func TestParseRoutingRulesNegatedGeoIP(t *testing.T) {
+	rules, err := parseTestRules(t, `
+- ip: geoip:!cn
+  outbound: proxy
+`, testGeoData())
+	if err != nil {
+		t.Fatalf("ParseRoutingRules: %v", err)
+	}
+
+	for _, test := range []struct {
+		ip   string
+		want int
+	}{
+		{"8.8.8.8", 0},
+		{"1.0.1.1", -1},
+	} {
+		req := RouteRequest{IP: net.ParseIP(test.ip), Port: 443, Network: "tcp"}
+		match, err := ExplainRoute(&RoutingConfig{Rules: rules}, req, testGeoData())
+		if err != nil {
+			t.Fatalf("ExplainRoute(%s): %v", req, err)
+		}
+		if match.Rule != test.want {
+			t.Errorf("%s matches rule %d, want %d", req, match.Rule, test.want)
+		}
+	}
+
+	for _, source := range []string{
+		"- {ip: 'geoip:!xx', outbound: proxy}",
+		"- {ip: 'geoip:!', outbound: proxy}",
+	} {
+		if _, err := parseTestRules(t, source, testGeoData()); err == nil {
+			t.Errorf("%s: ParseRoutingRules succeeded", source)
+		}
+	}
+	_, err = parseTestRules(t, "- {ip: 'geoip:!xx', outbound: proxy}", testGeoData())
+	if !errors.Is(err, ErrGeoCategoryNotFound) {
+		t.Errorf("unknown negated category: %v, want ErrGeoCategoryNotFound", err)
+	}
+}
+
+func TestParseRoutingRulesErrors(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{
+			name:   "not a list",
+			source: "outbound: proxy\n",
+			want:   "line 1: rules must be a list",
+		},
+		{
+			name:   "invalid matcher",
+			source: "- outbound: proxy\n  domain:\n    - domain:example.com\n    - 'regexp:(['\n",
+			want:   `rule 1 (line 4): domain: invalid regexp "(["`,
+		},
+		{
+			name:   "unknown prefix",
+			source: "- {domain: keyword:example, outbound: proxy}\n- {domain: site:example.com, outbound: proxy}\n",
+			want:   `rule 2 (line 2): domain: unknown matcher prefix "site"`,
+		},
+		{
+			name:   "invalid ip",
+			source: "- outbound: direct\n  ip: [1.1.1.1, 300.1.1.1]\n",
+			want:   `rule 1 (line 2): ip: invalid ip "300.1.1.1"`,
+		},
+		{
+			name:   "invalid port",
+			source: "- domain: example.com\n  outbound: proxy\n- network: udp\n  port: 443-80\n  outbound: proxy\n",
+			want:   `rule 2 (line 4): port: invalid port range "443-80"`,
+		},
+		{
+			name:   "port out of range",
+			source: "- port: 70000\n  outbound: proxy\n",
+			want:   `rule 1 (line 1): port: invalid port "70000"`,
+		},
+		{
+			name:   "unknown outbound",
+			source: "- domain: example.com\n\n  outbound: vpn\n",
+			want:   `rule 1 (line 3): outbound: unknown outbound "vpn"`,
+		},
+		{
+			name:   "missing outbound",
+			source: "- domain: example.com\n",
+			want:   "rule 1 (line 1): outbound: missing outbound",
+		},
+		{
+			name:   "unknown network",
+			source: "- network: tcp,sctp\n  outbound: proxy\n",
+			want:   `rule 1 (line 1): network: unknown network "sctp"`,
+		},
+		{
+			name:   "unknown protocol",
+			source: "- outbound: proxy\n  protocol:\n    - tls\n    - ftp\n",
+			want:   `rule 1 (line 4): protocol: unknown protocol "ftp"`,
+		},
+		{
+			name:   "matches nothing",
+			source: "- outbound: proxy\n",
+			want:   "rule 1 (line 1): rule matches nothing",
+		},
+		{
+			name:   "decode",
+			source: "- outbound: [proxy]\n",
+			want:   "rule 1 (line 1):",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseTestRules(t, test.source, nil)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got error %v, want %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestMergeRouting(t *testing.T) {
+	preset := DefaultRouting()
+	want := DefaultRouting()
+	rules := []RoutingRule{{Type: "field", Domain: []string{"example.com"}, Outbound: OutboundBlock}}
+
+	merged := MergeRouting(preset, rules, false)
+	if len(merged.Rules) != len(preset.Rules)+1 || !reflect.DeepEqual(merged.Rules[0], rules[0]) ||
+		!reflect.DeepEqual(merged.Rules[1:], preset.Rules) || merged.DomainStrategy != preset.DomainStrategy {
+		t.Errorf("merged = %+v", merged)
+	}
+
+	replaced := MergeRouting(preset, rules, true)
+	if !reflect.DeepEqual(replaced.Rules, rules) || replaced.DomainStrategy != preset.DomainStrategy {
+		t.Errorf("replaced = %+v", replaced)
+	}
+
+	merged.Rules[1].Outbound = OutboundBlock
+	if !reflect.DeepEqual(preset, want) {
+		t.Errorf("MergeRouting shares the preset rules: %+v", preset)
+	}
+}
@@ -0,0 +1,458 @@
+package vpn
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type SingBoxConfig struct {
+	Log       SingBoxLog        `json:"log"`
+	Inbounds  []SingBoxInbound  `json:"inbounds"`
+	Endpoints []SingBoxEndpoint `json:"endpoints,omitempty"`
+	Outbounds []SingBoxOutbound `json:"outbounds"`
+	Route     *SingBoxRoute     `json:"route,omitempty"`
+}
+
+type SingBoxLog struct {
+	Level string `json:"level,omitempty"`
+}
+
+type SingBoxInbound struct {
+	Type       string        `json:"type"`
+	Tag        string        `json:"tag,omitempty"`
+	Listen     string        `json:"listen"`
+	ListenPort int           `json:"listen_port"`
+	Users      []SingBoxUser `json:"users,omitempty"`
+}
+
+type SingBoxUser struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type SingBoxOutbound struct {
+	Type           string            `json:"type"`
+	Tag            string            `json:"tag"`
+	Server         string            `json:"server,omitempty"`
+	ServerPort     int               `json:"server_port,omitempty"`
+	UUID           string            `json:"uuid,omitempty"`
+	Flow           string            `json:"flow,omitempty"`
+	Security       string            `json:"security,omitempty"`
+	AlterID        int               `json:"alter_id,omitempty"`
+	Password       string            `json:"password,omitempty"`
+	Method         string            `json:"method,omitempty"`
+	Plugin         string            `json:"plugin,omitempty"`
+	PluginOpts     string            `json:"plugin_opts,omitempty"`
+	PacketEncoding string            `json:"packet_encoding,omitempty"`
+	TLS            *SingBoxTLS       `json:"tls,omitempty"`
+	Transport      *SingBoxTransport `json:"transport,omitempty"`
+
+	UpMbps            int          `json:"up_mbps,omitempty"`
+	DownMbps          int          `json:"down_mbps,omitempty"`
+	Obfs              *SingBoxObfs `json:"obfs,omitempty"`
+	CongestionControl string       `json:"congestion_control,omitempty"`
+	UDPRelayMode      string       `json:"udp_relay_mode,omitempty"`
+}
+
+// SingBoxEndpoint is a WireGuard endpoint, which replaces the WireGuard
+// outbound since sing-box 1.11.
+type SingBoxEndpoint struct {
+	Type       string                 `json:"type"`
+	Tag        string                 `json:"tag"`
+	Address    []string               `json:"address"`
+	PrivateKey string                 `json:"private_key"`
+	MTU        int                    `json:"mtu,omitempty"`
+	Peers      []SingBoxWireGuardPeer `json:"peers"`
+}
+
+type SingBoxWireGuardPeer struct {
+	Address      string   `json:"address"`
+	Port         int      `json:"port"`
+	PublicKey    string   `json:"public_key"`
+	PreSharedKey string   `json:"pre_shared_key,omitempty"`
+	AllowedIPs   []string `json:"allowed_ips"`
+	Reserved     []int    `json:"reserved,omitempty"`
+}
+
+type SingBoxObfs struct {
+	Type     string `json:"type"`
+	Password string `json:"password,omitempty"`
+}
+
+type SingBoxTLS struct {
+	Enabled    bool            `json:"enabled"`
+	ServerName string          `json:"server_name,omitempty"`
+	Insecure   bool            `json:"insecure,omitempty"`
+	ALPN       []string        `json:"alpn,omitempty"`
+	UTLS       *SingBoxUTLS    `json:"utls,omitempty"`
+	Reality    *SingBoxReality `json:"reality,omitempty"`
+}
+
+type SingBoxUTLS struct {
+	Enabled     bool   `json:"enabled"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+type SingBoxReality struct {
+	Enabled   bool   `json:"enabled"`
+	PublicKey string `json:"public_key"`
+	ShortID   string `json:"short_id,omitempty"`
+}
+
+type SingBoxTransport struct {
+	Type        string            `json:"type"`
+	Path        string            `json:"path,omitempty"`
+	Host        singBoxListable   `json:"host,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	ServiceName string            `json:"service_name,omitempty"`
+}
+
+// singBoxListable is written as a plain string when it has one element,
+// which sing-box accepts for every list option and requires for the
+// httpupgrade host.
+type singBoxListable []string
+
+func (l singBoxListable) MarshalJSON() ([]byte, error) {
+	if len(l) == 1 {
+		return json.Marshal(l[0])
+	}
+	return json.Marshal([]string(l))
+}
+
+type SingBoxRoute struct {
+	Rules []SingBoxRule `json:"rules"`
+	Final string        `json:"final,omitempty"`
+}
+
+// Rule actions introduced in sing-box 1.11, which deprecates inbound
+// sniffing and the block outbound.
+const (
+	SingBoxActionRoute  = "route"
+	SingBoxActionReject = "reject"
+	SingBoxActionSniff  = "sniff"
+)
+
+// SingBoxRule is a route rule. A logical rule sets Type, Mode and Rules,
+// whose sub-rules carry no action.
+type SingBoxRule struct {
+	Type          string          `json:"type,omitempty"`
+	Mode          string          `json:"mode,omitempty"`
+	Rules         []SingBoxRule   `json:"rules,omitempty"`
+	Inbound       []string        `json:"inbound,omitempty"`
+	Domain        []string        `json:"domain,omitempty"`
+	DomainSuffix  []string        `json:"domain_suffix,omitempty"`
+	DomainKeyword []string        `json:"domain_keyword,omitempty"`
+	DomainRegex   []string        `json:"domain_regex,omitempty"`
+	IPCIDR        []string        `json:"ip_cidr,omitempty"`
+	IPIsPrivate   bool            `json:"ip_is_private,omitempty"`
+	Port          []int           `json:"port,omitempty"`
+	PortRange     []string        `json:"port_range,omitempty"`
+	Network       singBoxListable `json:"network,omitempty"`
+	Protocol      []string        `json:"protocol,omitempty"`
+	Action        string          `json:"action,omitempty"`
+	Outbound      string          `json:"outbound,omitempty"`
+}
+
+// BuildSingBoxConfig is the sing-box counterpart of BuildXrayConfig. Xray
+// routing rules are translated to sing-box 1.11 route rules; a nil routing
+// uses DefaultRouting.
+func BuildSingBoxConfig(link Link, routing *RoutingConfig) (SingBoxConfig, error) {
+	if err := validateLink(link); err != nil {
+		return SingBoxConfig{}, err
+	}
+	outbounds := []SingBoxOutbound{{
+		Type: "direct",
+		Tag:  OutboundDirect,
+	}}
+	var endpoints []SingBoxEndpoint
+	if link.Protocol == "wireguard" {
+		endpoints = append(endpoints, buildSingBoxWireGuardEndpoint(link))
+	} else {
+		outbound, err := buildSingBoxOutbound(link)
+		if err != nil {
+			return SingBoxConfig{}, err
+		}
+		outbounds = append([]SingBoxOutbound{outbound}, outbounds...)
+	}
+	if routing == nil {
+		routing = DefaultRouting()
+	}
+	route, err := buildSingBoxRoute(routing)
+	if err != nil {
+		return SingBoxConfig{}, err
+	}
+
+	return SingBoxConfig{
+		Log: SingBoxLog{
+			Level: "warn",
+		},
+		Inbounds:  SingBoxInbounds(InboundOptions{MixedPort: DefaultLocalPort}),
+		Endpoints: endpoints,
+		Outbounds: outbounds,
+		Route:     route,
+	}, nil
+}
+
+func buildSingBoxOutbound(link Link) (SingBoxOutbound, error) {
+	outbound := SingBoxOutbound{
+		Type:       link.Protocol,
+		Tag:        "proxy",
+		Server:     link.Address,
+		ServerPort: link.Port,
+	}
+
+	switch link.Protocol {
+	case "vless":
+		outbound.UUID = link.UUID
+		outbound.Flow = link.Flow
+		outbound.PacketEncoding = "xudp"
+	case "vmess":
+		outbound.UUID = link.UUID
+		outbound.Security = firstNonEmpty(link.Cipher, "auto")
+		outbound.AlterID = link.AlterID
+	case "trojan":
+		outbound.Password = link.Password
+	case "shadowsocks":
+		outbound.Method = link.Method
+		outbound.Password = link.Password
+		switch link.Plugin {
+		case "", "obfs-local", "v2ray-plugin":
+			outbound.Plugin = link.Plugin
+			outbound.PluginOpts = link.PluginOpts
+		default:
+			return SingBoxOutbound{}, fmt.Errorf("%w: %q cannot be run by sing-box", ErrUnsupportedPlugin, link.Plugin)
+		}
+		return outbound, nil
+	case "hysteria2":
+		outbound.Password = link.Password
+		outbound.UpMbps = link.UpMbps
+		outbound.DownMbps = link.DownMbps
+		if link.Obfs != "" {
+			outbound.Obfs = &SingBoxObfs{Type: link.Obfs, Password: link.ObfsPassword}
+		}
+		// QUIC protocols always use TLS and have no transport.
+		link.Security = "tls"
+		outbound.TLS = buildSingBoxTLS(link)
+		return outbound, nil
+	case "tuic":
+		outbound.UUID = link.UUID
+		outbound.Password = link.Password
+		outbound.CongestionControl = link.CongestionControl
+		outbound.UDPRelayMode = link.UDPRelayMode
+		link.Security = "tls"
+		outbound.TLS = buildSingBoxTLS(link)
+		return outbound, nil
+	default:
+		return SingBoxOutbound{}, ErrUnsupportedProtocol
+	}
+
+	outbound.TLS = buildSingBoxTLS(link)
+	transport, err := buildSingBoxTransport(link)
+	if err != nil {
+		return SingBoxOutbound{}, err
+	}
+	outbound.Transport = transport
+	return outbound, nil
+}
+
+func buildSingBoxWireGuardEndpoint(link Link) SingBoxEndpoint {
+	allowedIPs := link.AllowedIPs
+	if len(allowedIPs) == 0 {
+		// Xray routes everything through a peer without allowed IPs.
+		allowedIPs = []string{"0.0.0.0/0", "::/0"}
+	}
+	return SingBoxEndpoint{
+		Type:       "wireguard",
+		Tag:        "proxy",
+		Address:    link.LocalAddress,
+		PrivateKey: link.PrivateKey,
+		MTU:        link.MTU,
+		Peers: []SingBoxWireGuardPeer{{
+			Address:      link.Address,
+			Port:         link.Port,
+			PublicKey:    link.PeerPublicKey,
+			PreSharedKey: link.PresharedKey,
+			AllowedIPs:   allowedIPs,
+			Reserved:     link.Reserved,
+		}},
+	}
+}
+
+func buildSingBoxTLS(link Link) *SingBoxTLS {
+	security := normalizeSecurity(link.Security)
+	if security == "" {
+		return nil
+	}
+	tls := &SingBoxTLS{
+		Enabled:    true,
+		ServerName: link.SNI,
+		Insecure:   link.AllowInsecure,
+		ALPN:       link.ALPN,
+	}
+	if link.Fingerprint != "" {
+		tls.UTLS = &SingBoxUTLS{Enabled: true, Fingerprint: link.Fingerprint}
+	}
+	if security == "reality" {
+		tls.Reality = &SingBoxReality{
+			Enabled:   true,
+			PublicKey: link.PublicKey,
+			ShortID:   link.ShortID,
+		}
+		if tls.UTLS == nil {
+			// sing-box refuses REALITY without uTLS.
+			tls.UTLS = &SingBoxUTLS{Enabled: true, Fingerprint: "chrome"}
+		}
+	}
+	return tls
+}
+
+func buildSingBoxTransport(link Link) (*SingBoxTransport, error) {
+	switch normalizeTransport(link.Transport) {
+	case "tcp":
+		if link.HeaderType == "http" {
+			return nil, errors.New("tcp http header obfuscation is not supported by sing-box")
+		}
+		return nil, nil
+	case "ws":
+		transport := &SingBoxTransport{Type: "ws", Path: link.Path}
+		if link.Host != "" {
+			transport.Headers = map[string]string{"Host": link.Host}
+		}
+		return transport, nil
+	case "grpc":
+		if link.Mode == "multi" {
+			return nil, errors.New("grpc multi mode is not supported by sing-box")
+		}
+		return &SingBoxTransport{Type: "grpc", ServiceName: link.ServiceName}, nil
+	case "httpupgrade":
+		transport := &SingBoxTransport{Type: "httpupgrade", Path: link.Path}
+		if hosts := splitCSV(link.Host); len(hosts) > 0 {
+			transport.Host = hosts[:1]
+		}
+		return transport, nil
+	case "quic":
+		encrypted := link.QUICSecurity != "" && link.QUICSecurity != "none"
+		obfuscated := link.HeaderType != "" && link.HeaderType != "none"
+		if encrypted || link.QUICKey != "" || obfuscated {
+			return nil, errors.New("quic encryption and header obfuscation are not supported by sing-box")
+		}
+		return &SingBoxTransport{Type: "quic"}, nil
+	case "h2", "http", "http2":
+		return &SingBoxTransport{Type: "http", Host: splitCSV(link.Host), Path: link.Path}, nil
+	default:
+		return nil, fmt.Errorf("transport %q is not supported by sing-box", link.Transport)
+	}
+}
+
+func buildSingBoxRoute(routing *RoutingConfig) (*SingBoxRoute, error) {
+	route := &SingBoxRoute{
+		Rules: make([]SingBoxRule, 0, len(routing.Rules)),
+		Final: "proxy",
+	}
+	for idx, rule := range routing.Rules {
+		converted, err := buildSingBoxRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("routing rule %d: %w", idx+1, err)
+		}
+		route.Rules = append(route.Rules, converted)
+	}
+	return route, nil
+}
+
+// WithSingBoxSniffing prepends the sniff rule that replaces inbound sniffing
+// when opts enables it, so that later rules can match sniffed domains.
+func WithSingBoxSniffing(config SingBoxConfig, opts InboundOptions) SingBoxConfig {
+	if !opts.Sniffing || config.Route == nil {
+		return config
+	}
+	rules := make([]SingBoxRule, 0, len(config.Route.Rules)+1)
+	rules = append(rules, SingBoxRule{Action: SingBoxActionSniff})
+	config.Route.Rules = append(rules, config.Route.Rules...)
+	return config
+}
+
+// buildSingBoxRule translates an Xray rule. Xray requires every condition
+// to match, while sing-box ORs domain and IP conditions within a rule, so a
+// rule with both becomes a logical and rule.
+func buildSingBoxRule(rule RoutingRule) (SingBoxRule, error) {
+	if rule.BalancerTag != "" {
+		return SingBoxRule{}, errors.New("balancers are not supported by sing-box")
+	}
+	converted := SingBoxRule{Inbound: rule.InboundTag}
+
+	for _, domain := range rule.Domain {
+		prefix, value, ok := strings.Cut(domain, ":")
+		if !ok {
+			// Xray treats a bare domain as a substring match.
+			converted.DomainKeyword = append(converted.DomainKeyword, domain)
+			continue
+		}
+		switch prefix {
+		case "domain":
+			converted.DomainSuffix = append(converted.DomainSuffix, value)
+		case "full":
+			converted.Domain = append(converted.Domain, value)
+		case "keyword":
+			converted.DomainKeyword = append(converted.DomainKeyword, value)
+		case "regexp":
+			converted.DomainRegex = append(converted.DomainRegex, value)
+		default:
+			return SingBoxRule{}, fmt.Errorf("domain matcher %q is not supported by sing-box", domain)
+		}
+	}
+
+	var ipRule SingBoxRule
+	for _, ip := range rule.IP {
+		switch {
+		case ip == "geoip:private":
+			ipRule.IPIsPrivate = true
+		case strings.HasPrefix(ip, "geoip:"):
+			return SingBoxRule{}, fmt.Errorf("ip matcher %q is not supported by sing-box", ip)
+		case strings.Contains(ip, "/"):
+			ipRule.IPCIDR = append(ipRule.IPCIDR, ip)
+		case strings.Contains(ip, ":"):
+			ipRule.IPCIDR = append(ipRule.IPCIDR, ip+"/128")
+		default:
+			ipRule.IPCIDR = append(ipRule.IPCIDR, ip+"/32")
+		}
+	}
+
+	for _, part := range splitCSV(rule.Port) {
+		if from, to, ok := strings.Cut(part, "-"); ok {
+			converted.PortRange = append(converted.PortRange, strings.TrimSpace(from)+":"+strings.TrimSpace(to))
+			continue
+		}
+		port, err := strconv.Atoi(part)
+		if err != nil {
+			return SingBoxRule{}, fmt.Errorf("parse port %q: %w", part, err)
+		}
+		converted.Port = append(converted.Port, port)
+	}
+
+	converted.Network = splitCSV(rule.Network)
+	converted.Protocol = rule.Protocol
+
+	switch {
+	case len(rule.Domain) == 0:
+		converted.IPCIDR = ipRule.IPCIDR
+		converted.IPIsPrivate = ipRule.IPIsPrivate
+	case len(rule.IP) > 0:
+		converted = SingBoxRule{
+			Type:  "logical",
+			Mode:  "and",
+			Rules: []SingBoxRule{converted, ipRule},
+		}
+	}
+
+	switch rule.Outbound {
+	case OutboundBlock:
+		converted.Action = SingBoxActionReject
+	default:
+		converted.Action = SingBoxActionRoute
+		converted.Outbound = rule.Outbound
+	}
+	return converted, nil
+}
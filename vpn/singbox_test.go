@@ -0,0 +1,345 @@
+package vpn
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// assertGolden compares got, marshaled like WriteConfig does, with
+// testdata/name. Run the tests with -update to rewrite the file.
+func assertGolden(t *testing.T, name string, got interface{}) {
+	t.Helper()
+	raw, err := json.MarshalIndent(got, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw = append(raw, '\n')
+	path := filepath.Join("testdata", name)
+	if *updateGolden {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, raw, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run with -update to create it)", err)
+	}
+	if !bytes.Equal(raw, want) {
+		t.Errorf("%s differs from the generated config:\n%s", path, raw)
+	}
+}
+
+func TestBuildSingBoxOutbound(t *testing.T) {
+	tests := []struct {
+		name string
+		link Link
+		want SingBoxOutbound
+	}{
+		{
+			name: "vless reality",
+			link: Link{
+				Protocol: "vless", Address: "example.com", Port: 443, UUID: testUUID, Flow: "xtls-rprx-vision",
+				Security: "reality", SNI: "www.microsoft.com", PublicKey: testRealityKey, ShortID: "6ba85179e30d4fc2",
+			},
+			want: SingBoxOutbound{
+				Type: "vless", Tag: "proxy", Server: "example.com", ServerPort: 443, UUID: testUUID,
+				Flow: "xtls-rprx-vision", PacketEncoding: "xudp",
+				TLS: &SingBoxTLS{
+					Enabled: true, ServerName: "www.microsoft.com",
+					UTLS:    &SingBoxUTLS{Enabled: true, Fingerprint: "chrome"},
+					Reality: &SingBoxReality{Enabled: true, PublicKey: testRealityKey, ShortID: "6ba85179e30d4fc2"},
+				},
+			},
+		},
+		{
+			name: "vmess ws",
+			link: Link{
+				Protocol: "vmess", Address: "example.com", Port: 443, UUID: testUUID, AlterID: 2, Cipher: "zero",
+				Security: "tls", SNI: "example.com", AllowInsecure: true, ALPN: []string{"h2"}, Fingerprint: "firefox",
+				Transport: "ws", Path: "/ws", Host: "cdn.example.com",
+			},
+			want: SingBoxOutbound{
+				Type: "vmess", Tag: "proxy", Server: "example.com", ServerPort: 443, UUID: testUUID,
+				Security: "zero", AlterID: 2,
+				TLS: &SingBoxTLS{
+					Enabled: true, ServerName: "example.com", Insecure: true, ALPN: []string{"h2"},
+					UTLS: &SingBoxUTLS{Enabled: true, Fingerprint: "firefox"},
+				},
+				Transport: &SingBoxTransport{Type: "ws", Path: "/ws", Headers: map[string]string{"Host": "cdn.example.com"}},
+			},
+		},
+		{
+			name: "trojan grpc",
+			link: Link{Protocol: "trojan", Address: "example.com", Port: 443, Password: "secret", Security: "tls", Transport: "grpc", ServiceName: "svc"},
+			want: SingBoxOutbound{
+				Type: "trojan", Tag: "proxy", Server: "example.com", ServerPort: 443, Password: "secret",
+				TLS:       &SingBoxTLS{Enabled: true},
+				Transport: &SingBoxTransport{Type: "grpc", ServiceName: "svc"},
+			},
+		},
+		{
+			name: "vless h2",
+			link: Link{Protocol: "vless", Address: "example.com", Port: 443, UUID: testUUID, Security: "tls", Transport: "h2", Host: "a.example.com,b.example.com", Path: "/h2"},
+			want: SingBoxOutbound{
+				Type: "vless", Tag: "proxy", Server: "example.com", ServerPort: 443, UUID: testUUID, PacketEncoding: "xudp",
+				TLS:       &SingBoxTLS{Enabled: true},
+				Transport: &SingBoxTransport{Type: "http", Host: []string{"a.example.com", "b.example.com"}, Path: "/h2"},
+			},
+		},
+		{
+			name: "shadowsocks plugin",
+			link: Link{Protocol: "shadowsocks", Address: "example.com", Port: 8388, Method: "aes-128-gcm", Password: "secret", Plugin: "obfs-local", PluginOpts: "obfs=http"},
+			want: SingBoxOutbound{
+				Type: "shadowsocks", Tag: "proxy", Server: "example.com", ServerPort: 8388,
+				Method: "aes-128-gcm", Password: "secret", Plugin: "obfs-local", PluginOpts: "obfs=http",
+			},
+		},
+		{
+			name: "hysteria2",
+			link: Link{
+				Protocol: "hysteria2", Address: "example.com", Port: 443, Password: "secret", SNI: "cdn.example.com",
+				ALPN: []string{"h3"}, Obfs: "salamander", ObfsPassword: "obfs", UpMbps: 50, DownMbps: 200,
+			},
+			want: SingBoxOutbound{
+				Type: "hysteria2", Tag: "proxy", Server: "example.com", ServerPort: 443, Password: "secret",
+				UpMbps: 50, DownMbps: 200, Obfs: &SingBoxObfs{Type: "salamander", Password: "obfs"},
+				TLS: &SingBoxTLS{Enabled: true, ServerName: "cdn.example.com", ALPN: []string{"h3"}},
+			},
+		},
+		{
+			name: "tuic",
+			link: Link{
+				Protocol: "tuic", Address: "example.com", Port: 443, UUID: testUUID, Password: "secret",
+				Security: "tls", AllowInsecure: true, CongestionControl: "bbr", UDPRelayMode: "native",
+			},
+			want: SingBoxOutbound{
+				Type: "tuic", Tag: "proxy", Server: "example.com", ServerPort: 443, UUID: testUUID, Password: "secret",
+				CongestionControl: "bbr", UDPRelayMode: "native",
+				TLS: &SingBoxTLS{Enabled: true, Insecure: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := BuildSingBoxConfig(tt.link, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(config.Outbounds[0], tt.want) {
+				t.Errorf("outbound = %+v\nwant %+v", config.Outbounds[0], tt.want)
+			}
+			// Blocked traffic is rejected by a rule action, not an outbound.
+			if len(config.Outbounds) != 2 || config.Outbounds[1].Tag != OutboundDirect {
+				t.Errorf("outbounds = %+v", config.Outbounds)
+			}
+			if inbound := config.Inbounds[0]; inbound.Type != "mixed" || inbound.ListenPort != DefaultLocalPort {
+				t.Errorf("inbound = %+v", inbound)
+			}
+		})
+	}
+}
+
+func TestBuildSingBoxRoute(t *testing.T) {
+	routing := &RoutingConfig{Rules: []RoutingRule{
+		{Type: "field", Domain: []string{"domain:example.com", "full:a.example.org", "keyword:tracker", "regexp:^ads\\.", "discord"}, Outbound: "proxy"},
+		{Type: "field", IP: []string{"geoip:private", "10.0.0.0/8", "1.1.1.1", "2001:db8::1"}, Outbound: "direct"},
+		{Type: "field", Port: "443, 50000-50100", Network: "udp", Outbound: "proxy"},
+		{Type: "field", Port: "53", Network: "tcp,udp", Outbound: "direct"},
+		{Type: "field", Domain: []string{"full:ads.example.com"}, Outbound: "block"},
+		{Type: "field", Domain: []string{"domain:example.org"}, IP: []string{"203.0.113.0/24"}, Outbound: "direct"},
+	}}
+	config, err := BuildSingBoxConfig(Link{Protocol: "trojan", Address: "example.com", Port: 443, Password: "secret"}, routing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &SingBoxRoute{
+		Final: "proxy",
+		Rules: []SingBoxRule{
+			{
+				DomainSuffix: []string{"example.com"}, Domain: []string{"a.example.org"},
+				DomainKeyword: []string{"tracker", "discord"}, DomainRegex: []string{"^ads\\."},
+				Action: SingBoxActionRoute, Outbound: "proxy",
+			},
+			{IPIsPrivate: true, IPCIDR: []string{"10.0.0.0/8", "1.1.1.1/32", "2001:db8::1/128"}, Action: SingBoxActionRoute, Outbound: "direct"},
+			{Port: []int{443}, PortRange: []string{"50000:50100"}, Network: singBoxListable{"udp"}, Action: SingBoxActionRoute, Outbound: "proxy"},
+			{Port: []int{53}, Network: singBoxListable{"tcp", "udp"}, Action: SingBoxActionRoute, Outbound: "direct"},
+			{Domain: []string{"ads.example.com"}, Action: SingBoxActionReject},
+			{
+				Type: "logical", Mode: "and", Action: SingBoxActionRoute, Outbound: "direct",
+				Rules: []SingBoxRule{{DomainSuffix: []string{"example.org"}}, {IPCIDR: []string{"203.0.113.0/24"}}},
+			},
+		},
+	}
+	if !reflect.DeepEqual(config.Route, want) {
+		t.Errorf("route = %+v\nwant %+v", config.Route, want)
+	}
+}
+
+func TestBuildSingBoxTransport(t *testing.T) {
+	tests := []struct {
+		name string
+		link Link
+		want string
+	}{
+		{"raw", Link{Transport: "raw"}, "null"},
+		{"httpupgrade", Link{Transport: "httpupgrade", Path: "/up", Host: "a.example.com,b.example.com"}, `{"type":"httpupgrade","path":"/up","host":"a.example.com"}`},
+		{"h2", Link{Transport: "h2", Path: "/h2", Host: "a.example.com,b.example.com"}, `{"type":"http","path":"/h2","host":["a.example.com","b.example.com"]}`},
+		{"quic", Link{Transport: "quic"}, `{"type":"quic"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport, err := buildSingBoxTransport(tt.link)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := json.Marshal(transport)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("transport = %s, want %s", data, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildSingBoxConfigErrors(t *testing.T) {
+	trojan := Link{Protocol: "trojan", Address: "example.com", Port: 443, Password: "secret"}
+	tests := []struct {
+		name    string
+		link    Link
+		routing *RoutingConfig
+		want    string
+	}{
+		{"invalid link", Link{Protocol: "trojan", Address: "example.com", Port: 443}, nil, "missing password"},
+		{"kcp", Link{Protocol: "trojan", Address: "example.com", Port: 443, Password: "secret", Transport: "kcp"}, nil, `transport "kcp" is not supported by sing-box`},
+		{"grpc multi", Link{Protocol: "trojan", Address: "example.com", Port: 443, Password: "secret", Transport: "grpc", Mode: "multi"}, nil, "grpc multi mode is not supported"},
+		{"tcp http header", Link{Protocol: "trojan", Address: "example.com", Port: 443, Password: "secret", HeaderType: "http"}, nil, "tcp http header obfuscation is not supported"},
+		{"plugin", Link{Protocol: "shadowsocks", Address: "example.com", Port: 8388, Method: "aes-128-gcm", Password: "secret", Plugin: "kcptun"}, nil, "cannot be run by sing-box"},
+		{"hysteria2 obfs", Link{Protocol: "hysteria2", Address: "example.com", Port: 443, Obfs: "xor"}, nil, `unsupported hysteria2 obfs "xor"`},
+		{"hysteria2 obfs password", Link{Protocol: "hysteria2", Address: "example.com", Port: 443, Obfs: "salamander"}, nil, "missing obfs password"},
+		{"tuic password", Link{Protocol: "tuic", Address: "example.com", Port: 443, UUID: testUUID}, nil, "missing uuid or password"},
+		{"tuic congestion", Link{Protocol: "tuic", Address: "example.com", Port: 443, UUID: testUUID, Password: "secret", CongestionControl: "reno"}, nil, `unsupported tuic congestion control "reno"`},
+		{"tuic relay", Link{Protocol: "tuic", Address: "example.com", Port: 443, UUID: testUUID, Password: "secret", UDPRelayMode: "tcp"}, nil, `unsupported tuic udp relay mode "tcp"`},
+		{"balancer", trojan, &RoutingConfig{Rules: []RoutingRule{{BalancerTag: "pool"}}}, "balancers are not supported"},
+		{"geosite", trojan, &RoutingConfig{Rules: []RoutingRule{{Domain: []string{"geosite:cn"}, Outbound: "direct"}}}, `domain matcher "geosite:cn"`},
+		{"geoip", trojan, &RoutingConfig{Rules: []RoutingRule{{IP: []string{"geoip:cn"}, Outbound: "direct"}}}, `ip matcher "geoip:cn"`},
+		{"port", trojan, &RoutingConfig{Rules: []RoutingRule{{Port: "https", Outbound: "direct"}}}, `routing rule 1: parse port "https"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := BuildSingBoxConfig(tt.link, tt.routing)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+	if _, err := BuildSingBoxConfig(Link{Protocol: "shadowsocks", Address: "example.com", Port: 8388, Method: "aes-128-gcm", Password: "secret", Plugin: "kcptun"}, nil); !errors.Is(err, ErrUnsupportedPlugin) {
+		t.Errorf("plugin error = %v, want ErrUnsupportedPlugin", err)
+	}
+}
+
+func TestBuildCoreConfig(t *testing.T) {
+	link := Link{Protocol: "trojan", Address: "example.com", Port: 443, Password: "secret"}
+
+	config, err := BuildCoreConfig(CoreXray, link, CoreOptions{Inbound: InboundOptions{MixedPort: 2080}, DNS: &DNSConfig{Tag: DNSTag}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if xray, ok := config.(XrayConfig); !ok || len(xray.Inbounds) != 1 || xray.Inbounds[0].Port != 2080 || xray.DNS == nil {
+		t.Errorf("xray config = %+v", config)
+	}
+
+	config, err = BuildCoreConfig(CoreSingBox, link, CoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if singBox, ok := config.(SingBoxConfig); !ok || singBox.Inbounds[0].Type != "socks" || singBox.Inbounds[0].ListenPort != DefaultLocalPort {
+		t.Errorf("sing-box config = %+v", config)
+	}
+
+	if _, err := BuildCoreConfig("clash", link, CoreOptions{}); err == nil {
+		t.Error("BuildCoreConfig accepted an unknown core")
+	}
+}
+
+func TestBuildSingBoxConfigGolden(t *testing.T) {
+	custom := &RoutingConfig{
+		DomainStrategy: "AsIs",
+		Rules: []RoutingRule{
+			{Type: "field", Domain: []string{"domain:example.com", "full:a.example.org"}, IP: []string{"203.0.113.0/24", "2001:db8::1"}, Port: "443", Outbound: "proxy"},
+			{Type: "field", Domain: []string{"regexp:^ads\\.", "keyword:tracker"}, Outbound: "block"},
+			{Type: "field", Port: "50000-50100,3478", Network: "tcp,udp", Outbound: "proxy"},
+			{Type: "field", InboundTag: []string{"socks-in"}, IP: []string{"geoip:private", "10.1.2.3"}, Outbound: "direct"},
+		},
+	}
+
+	transport := func(link Link) Link {
+		link.Protocol, link.Name, link.Address, link.Port = "vless", "", "example.com", 443
+		link.UUID, link.Security, link.SNI = testUUID, "tls", "example.com"
+		return link
+	}
+	minimal := InboundOptions{MixedPort: DefaultLocalPort}
+	for _, test := range []struct {
+		name    string
+		link    Link
+		routing *RoutingConfig
+		inbound InboundOptions
+	}{
+		{"minimal.json", formatTestLinks["vless reality"], DefaultRouting(), minimal},
+		{"discord-full.json", formatTestLinks["vless reality"], DiscordFullRouting(), InboundOptions{SocksPort: DefaultLocalPort, Sniffing: true}},
+		{"custom.json", formatTestLinks["vless reality"], custom, InboundOptions{MixedPort: DefaultLocalPort, Sniffing: true}},
+		{"wireguard.json", formatTestLinks["wireguard"], DefaultRouting(), minimal},
+
+		{"vmess.json", formatTestLinks["vmess ws insecure"], DefaultRouting(), minimal},
+		{"trojan.json", formatTestLinks["trojan plain"], DefaultRouting(), minimal},
+		{"shadowsocks.json", formatTestLinks["shadowsocks"], DefaultRouting(), minimal},
+		{"shadowsocks-plugin.json", formatTestLinks["shadowsocks 2022 plugin"], DefaultRouting(), minimal},
+		{"hysteria2.json", formatTestLinks["hysteria2"], DefaultRouting(), minimal},
+		{"tuic.json", formatTestLinks["tuic"], DefaultRouting(), minimal},
+
+		{"ws.json", formatTestLinks["vless ws tls"], DefaultRouting(), minimal},
+		{"grpc.json", transport(Link{Transport: "grpc", ServiceName: "svc"}), DefaultRouting(), minimal},
+		{"httpupgrade.json", transport(Link{Transport: "httpupgrade", Host: "cdn.example.com", Path: "/up"}), DefaultRouting(), minimal},
+		{"h2.json", transport(Link{Transport: "h2", Host: "a.example.com,b.example.com", Path: "/h2"}), DefaultRouting(), minimal},
+		{"quic.json", transport(Link{Transport: "quic"}), DefaultRouting(), minimal},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			config, err := BuildCoreConfig(CoreSingBox, test.link, CoreOptions{
+				Routing: test.routing,
+				Inbound: test.inbound,
+			})
+			if err != nil {
+				t.Fatalf("BuildCoreConfig: %v", err)
+			}
+			assertGolden(t, filepath.Join("singbox", test.name), config)
+		})
+	}
+}
+
+func TestBuildSingBoxConfigRejectsUnsupportedTransports(t *testing.T) {
+	for _, name := range []string{"vless quic", "vless grpc multi", "vmess tcp http"} {
+		if _, err := BuildSingBoxConfig(formatTestLinks[name], nil); err == nil {
+			t.Errorf("%s: BuildSingBoxConfig succeeded", name)
+		}
+	}
+
+	link := formatTestLinks["vless quic"]
+	link.QUICSecurity, link.QUICKey, link.HeaderType = "none", "", "none"
+	if _, err := BuildSingBoxConfig(link, nil); err != nil {
+		t.Errorf("plain quic: %v", err)
+	}
+}
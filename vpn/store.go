@@ -0,0 +1,466 @@
+package vpn
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// StoreFileName is the name of the server store inside the data directory.
+const StoreFileName = "servers.json"
+
+// StoreVersion is the schema version written by Save.
+const StoreVersion = 2
+
+// ManualGroupID holds servers imported by hand rather than by subscription.
+const ManualGroupID = "manual"
+
+var ErrServerNotFound = errors.New("server not found")
+
+var ErrGroupNotFound = errors.New("group not found")
+
+// storeMigrations upgrade a decoded document from the keyed version to the
+// next one. Add an entry here whenever StoreVersion is bumped.
+var storeMigrations = map[int]func(doc map[string]interface{}) error{
+	// Documents without a version field predate versioning but already use
+	// the version 1 layout.
+	0: func(doc map[string]interface{}) error { return nil },
+	1: migrateVMessAlterID,
+}
+
+// migrateVMessAlterID moves the VMess alterId, which version 1 kept as a
+// string in the link encryption, to the integer alterId field.
+func migrateVMessAlterID(doc map[string]interface{}) error {
+	groups, _ := doc["groups"].([]interface{})
+	for _, group := range groups {
+		group, _ := group.(map[string]interface{})
+		servers, _ := group["servers"].([]interface{})
+		for _, server := range servers {
+			server, _ := server.(map[string]interface{})
+			link, _ := server["link"].(map[string]interface{})
+			if link == nil || link["protocol"] != "vmess" {
+				continue
+			}
+			encryption, _ := link["encryption"].(string)
+			delete(link, "encryption")
+			if encryption == "" {
+				continue
+			}
+			alterID, err := strconv.Atoi(encryption)
+			if err != nil {
+				return fmt.Errorf("server %v: vmess alterId %q: %w", server["id"], encryption, err)
+			}
+			link["alterId"] = alterID
+		}
+	}
+	return nil
+}
+
+type Store struct {
+	mu   sync.Mutex
+	path string
+	data storeData
+}
+
+type storeData struct {
+	Version  int           `json:"version"`
+	Selected string        `json:"selected,omitempty"`
+	Groups   []ServerGroup `json:"groups"`
+}
+
+type ServerGroup struct {
+	ID           string        `json:"id"`
+	Name         string        `json:"name"`
+	Subscription *Subscription `json:"subscription,omitempty"`
+	Servers      []Server      `json:"servers"`
+}
+
+type Server struct {
+	ID       string        `json:"id"`
+	Name     string        `json:"name,omitempty"`
+	Tags     []string      `json:"tags,omitempty"`
+	Link     Link          `json:"link"`
+	Latency  time.Duration `json:"latency,omitempty"`
+	TestedAt time.Time     `json:"testedAt"`
+}
+
+// DisplayName returns the user-assigned name, falling back to the link name.
+func (s Server) DisplayName() string {
+	return firstNonEmpty(s.Name, s.Link.Name, fmt.Sprintf("%s:%d", s.Link.Address, s.Link.Port))
+}
+
+// OpenStore loads the store at path, migrating older schemas. A missing file
+// yields an empty store that is created on the first Save.
+func OpenStore(path string) (*Store, error) {
+	store := &Store{
+		path: path,
+		data: storeData{
+			Version: StoreVersion,
+			Groups:  []ServerGroup{{ID: ManualGroupID, Name: "Manual", Servers: []Server{}}},
+		},
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return store, nil
+		}
+		return nil, fmt.Errorf("read server store: %w", err)
+	}
+
+	data, err := decodeStore(raw)
+	if err != nil {
+		return nil, err
+	}
+	if findGroup(data.Groups, ManualGroupID) < 0 {
+		data.Groups = append([]ServerGroup{store.data.Groups[0]}, data.Groups...)
+	}
+	store.data = data
+	return store, nil
+}
+
+func decodeStore(raw []byte) (storeData, error) {
+	doc := map[string]interface{}{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return storeData{}, fmt.Errorf("unmarshal server store: %w", err)
+	}
+
+	version := 0
+	if value, ok := doc["version"].(float64); ok {
+		version = int(value)
+	}
+	if version > StoreVersion {
+		return storeData{}, fmt.Errorf("server store version %d is newer than supported version %d", version, StoreVersion)
+	}
+	for ; version < StoreVersion; version++ {
+		migrate, ok := storeMigrations[version]
+		if !ok {
+			return storeData{}, fmt.Errorf("no server store migration from version %d", version)
+		}
+		if err := migrate(doc); err != nil {
+			return storeData{}, fmt.Errorf("migrate server store from version %d: %w", version, err)
+		}
+		doc["version"] = version + 1
+	}
+
+	migrated, err := json.Marshal(doc)
+	if err != nil {
+		return storeData{}, fmt.Errorf("marshal server store: %w", err)
+	}
+	var data storeData
+	if err := json.Unmarshal(migrated, &data); err != nil {
+		return storeData{}, fmt.Errorf("unmarshal server store: %w", err)
+	}
+	return data, nil
+}
+
+// Save writes the store to disk atomically via a temporary file and rename.
+func (s *Store) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data.Version = StoreVersion
+	raw, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal server store: %w", err)
+	}
+
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("create server store dir: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, ".servers-*.tmp")
+	if err != nil {
+		return fmt.Errorf("create temporary server store: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write server store: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync server store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close server store: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("replace server store: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) Groups() []ServerGroup {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	groups := make([]ServerGroup, len(s.data.Groups))
+	for idx, group := range s.data.Groups {
+		group.Servers = append([]Server(nil), group.Servers...)
+		groups[idx] = group
+	}
+	return groups
+}
+
+// Servers returns every stored server in group order.
+func (s *Store) Servers() []Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var servers []Server
+	for _, group := range s.data.Groups {
+		servers = append(servers, group.Servers...)
+	}
+	return servers
+}
+
+func (s *Store) Server(id string) (Server, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	groupIdx, serverIdx := s.findServer(id)
+	if groupIdx < 0 {
+		return Server{}, ErrServerNotFound
+	}
+	return s.data.Groups[groupIdx].Servers[serverIdx], nil
+}
+
+// AddGroup creates a group, typically for a subscription, and returns its ID.
+func (s *Store) AddGroup(name string, sub *Subscription) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	group := ServerGroup{
+		ID:           newStoreID(),
+		Name:         name,
+		Subscription: sub,
+		Servers:      []Server{},
+	}
+	s.data.Groups = append(s.data.Groups, group)
+	return group.ID
+}
+
+func (s *Store) UpdateGroup(group ServerGroup) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	idx := findGroup(s.data.Groups, group.ID)
+	if idx < 0 {
+		return ErrGroupNotFound
+	}
+	group.Servers = s.data.Groups[idx].Servers
+	s.data.Groups[idx] = group
+	return nil
+}
+
+// DeleteGroup removes a group and its servers. The manual group cannot be
+// deleted; it is emptied instead.
+func (s *Store) DeleteGroup(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	idx := findGroup(s.data.Groups, id)
+	if idx < 0 {
+		return ErrGroupNotFound
+	}
+	for _, server := range s.data.Groups[idx].Servers {
+		if server.ID == s.data.Selected {
+			s.data.Selected = ""
+		}
+	}
+	if id == ManualGroupID {
+		s.data.Groups[idx].Servers = []Server{}
+		return nil
+	}
+	s.data.Groups = append(s.data.Groups[:idx], s.data.Groups[idx+1:]...)
+	return nil
+}
+
+// AddServers appends links to a group and returns the created servers.
+func (s *Store) AddServers(groupID string, links []Link) ([]Server, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	idx := findGroup(s.data.Groups, groupID)
+	if idx < 0 {
+		return nil, ErrGroupNotFound
+	}
+	added := make([]Server, 0, len(links))
+	for _, link := range links {
+		added = append(added, Server{ID: newStoreID(), Link: link})
+	}
+	s.data.Groups[idx].Servers = append(s.data.Groups[idx].Servers, added...)
+	return added, nil
+}
+
+// ReplaceServers swaps the servers of a group for links, as after a
+// subscription refresh. Servers pointing at the same endpoint keep their ID,
+// name, tags and latency.
+func (s *Store) ReplaceServers(groupID string, links []Link) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	idx := findGroup(s.data.Groups, groupID)
+	if idx < 0 {
+		return ErrGroupNotFound
+	}
+
+	previous := map[string]Server{}
+	for _, server := range s.data.Groups[idx].Servers {
+		previous[serverKey(server.Link)] = server
+	}
+	servers := make([]Server, 0, len(links))
+	for _, link := range links {
+		server, ok := previous[serverKey(link)]
+		if ok {
+			delete(previous, serverKey(link))
+		} else {
+			server = Server{ID: newStoreID()}
+		}
+		server.Link = link
+		servers = append(servers, server)
+	}
+	for _, removed := range previous {
+		if removed.ID == s.data.Selected {
+			s.data.Selected = ""
+		}
+	}
+	s.data.Groups[idx].Servers = servers
+	return nil
+}
+
+// UpdateServer replaces the stored server with the same ID.
+func (s *Store) UpdateServer(server Server) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	groupIdx, serverIdx := s.findServer(server.ID)
+	if groupIdx < 0 {
+		return ErrServerNotFound
+	}
+	s.data.Groups[groupIdx].Servers[serverIdx] = server
+	return nil
+}
+
+// RecordLatency stores a latency test result. Failed tests clear the
+// latency so that unreachable servers sort last.
+func (s *Store) RecordLatency(id string, result LatencyResult, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	groupIdx, serverIdx := s.findServer(id)
+	if groupIdx < 0 {
+		return ErrServerNotFound
+	}
+	server := &s.data.Groups[groupIdx].Servers[serverIdx]
+	server.Latency = 0
+	if result.Err == nil {
+		server.Latency = result.Latency()
+	}
+	server.TestedAt = at
+	return nil
+}
+
+func (s *Store) DeleteServer(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	groupIdx, serverIdx := s.findServer(id)
+	if groupIdx < 0 {
+		return ErrServerNotFound
+	}
+	servers := s.data.Groups[groupIdx].Servers
+	s.data.Groups[groupIdx].Servers = append(servers[:serverIdx], servers[serverIdx+1:]...)
+	if s.data.Selected == id {
+		s.data.Selected = ""
+	}
+	return nil
+}
+
+// MoveServer moves a server to position index within its group, clamping
+// out-of-range positions to the ends.
+func (s *Store) MoveServer(id string, index int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	groupIdx, serverIdx := s.findServer(id)
+	if groupIdx < 0 {
+		return ErrServerNotFound
+	}
+	servers := s.data.Groups[groupIdx].Servers
+	server := servers[serverIdx]
+	servers = append(servers[:serverIdx], servers[serverIdx+1:]...)
+	index = max(0, min(index, len(servers)))
+	servers = append(servers[:index], append([]Server{server}, servers[index:]...)...)
+	s.data.Groups[groupIdx].Servers = servers
+	return nil
+}
+
+func (s *Store) Select(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id != "" {
+		if groupIdx, _ := s.findServer(id); groupIdx < 0 {
+			return ErrServerNotFound
+		}
+	}
+	s.data.Selected = id
+	return nil
+}
+
+// Selected returns the selected server, or false when none is selected.
+func (s *Store) Selected() (Server, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	groupIdx, serverIdx := s.findServer(s.data.Selected)
+	if groupIdx < 0 {
+		return Server{}, false
+	}
+	return s.data.Groups[groupIdx].Servers[serverIdx], true
+}
+
+func (s *Store) findServer(id string) (int, int) {
+	if id == "" {
+		return -1, -1
+	}
+	for groupIdx, group := range s.data.Groups {
+		for serverIdx, server := range group.Servers {
+			if server.ID == id {
+				return groupIdx, serverIdx
+			}
+		}
+	}
+	return -1, -1
+}
+
+func findGroup(groups []ServerGroup, id string) int {
+	for idx, group := range groups {
+		if group.ID == id {
+			return idx
+		}
+	}
+	return -1
+}
+
+func serverKey(link Link) string {
+	return fmt.Sprintf("%s|%s|%d|%s%s", link.Protocol, link.Address, link.Port, link.UUID, link.Password)
+}
+
+func newStoreID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		panic(fmt.Sprintf("read random store id: %v", err))
+	}
+	return hex.EncodeToString(buf)
+}
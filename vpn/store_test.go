@@ -0,0 +1,301 @@
+package vpn
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testStoreLinks(names ...string) []Link {
+	links := make([]Link, len(names))
+	for idx, name := range names {
+		links[idx] = Link{Protocol: "trojan", Name: name, Address: name + ".example.com", Port: 443, Password: "secret", Security: "tls"}
+	}
+	return links
+}
+
+func serverNames(servers []Server) []string {
+	names := make([]string, len(servers))
+	for idx, server := range servers {
+		names[idx] = server.DisplayName()
+	}
+	return names
+}
+
+func TestStoreRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", StoreFileName)
+	store, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if groups := store.Groups(); len(groups) != 1 || groups[0].ID != ManualGroupID {
+		t.Fatalf("new store groups = %+v", groups)
+	}
+
+	sub := &Subscription{URL: "https://example.com/sub", UpdatedAt: time.Unix(1700000000, 0).UTC(), ETag: `"v1"`}
+	groupID := store.AddGroup("Provider", sub)
+	added, err := store.AddServers(groupID, testStoreLinks("a", "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.AddServers(ManualGroupID, testStoreLinks("manual")); err != nil {
+		t.Fatal(err)
+	}
+	server := added[1]
+	server.Name = "Renamed"
+	server.Tags = []string{"fast"}
+	server.Latency = 42 * time.Millisecond
+	server.TestedAt = time.Unix(1700000100, 0).UTC()
+	if err := store.UpdateServer(server); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Select(server.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(reopened.Groups(), store.Groups()) {
+		t.Errorf("reopened groups = %+v\nwant %+v", reopened.Groups(), store.Groups())
+	}
+	selected, ok := reopened.Selected()
+	if !ok || !reflect.DeepEqual(selected, server) {
+		t.Errorf("reopened selection = %+v, %v", selected, ok)
+	}
+	if got := serverNames(reopened.Servers()); !reflect.DeepEqual(got, []string{"manual", "a", "Renamed"}) {
+		t.Errorf("servers = %v", got)
+	}
+}
+
+func TestStoreSaveLeavesNoTemporaryFile(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenStore(filepath.Join(dir, StoreFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if err := store.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != StoreFileName {
+		t.Errorf("directory holds %v", entries)
+	}
+}
+
+func TestStoreKeepsSelection(t *testing.T) {
+	store, err := OpenStore(filepath.Join(t.TempDir(), StoreFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	groupID := store.AddGroup("Provider", nil)
+	added, err := store.AddServers(groupID, testStoreLinks("a", "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Select(added[1].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	store.AddGroup("Another", nil)
+	if selected, ok := store.Selected(); !ok || selected.ID != added[1].ID {
+		t.Fatalf("selection after AddGroup = %+v, %v", selected, ok)
+	}
+
+	// A refresh that keeps the endpoint keeps the ID and the selection.
+	refreshed := testStoreLinks("c", "b")
+	refreshed[1].Name = "b renamed by provider"
+	if err := store.ReplaceServers(groupID, refreshed); err != nil {
+		t.Fatal(err)
+	}
+	selected, ok := store.Selected()
+	if !ok || selected.ID != added[1].ID || selected.Link.Name != "b renamed by provider" {
+		t.Fatalf("selection after ReplaceServers = %+v, %v", selected, ok)
+	}
+
+	// A refresh that drops it clears the selection.
+	if err := store.ReplaceServers(groupID, testStoreLinks("c")); err != nil {
+		t.Fatal(err)
+	}
+	if selected, ok := store.Selected(); ok {
+		t.Errorf("selection after removal = %+v", selected)
+	}
+}
+
+func TestStoreMoveServer(t *testing.T) {
+	store, err := OpenStore(filepath.Join(t.TempDir(), StoreFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	added, err := store.AddServers(ManualGroupID, testStoreLinks("a", "b", "c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		id    string
+		index int
+		want  []string
+	}{
+		{added[0].ID, 1, []string{"b", "a", "c"}},
+		{added[2].ID, -5, []string{"c", "b", "a"}},
+		{added[2].ID, 99, []string{"b", "a", "c"}},
+		{added[1].ID, 2, []string{"a", "c", "b"}},
+		{added[1].ID, 2, []string{"a", "c", "b"}},
+	}
+	for _, tt := range tests {
+		if err := store.MoveServer(tt.id, tt.index); err != nil {
+			t.Fatal(err)
+		}
+		if got := serverNames(store.Servers()); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("MoveServer(%d) = %v, want %v", tt.index, got, tt.want)
+		}
+	}
+	if err := store.MoveServer("missing", 0); !errors.Is(err, ErrServerNotFound) {
+		t.Errorf("MoveServer(missing) = %v", err)
+	}
+}
+
+func TestStoreDeleteGroup(t *testing.T) {
+	store, err := OpenStore(filepath.Join(t.TempDir(), StoreFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	groupID := store.AddGroup("Provider", nil)
+	added, err := store.AddServers(groupID, testStoreLinks("a", "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	manual, err := store.AddServers(ManualGroupID, testStoreLinks("manual"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Select(added[0].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.DeleteGroup(groupID); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.Selected(); ok {
+		t.Error("selection survived the deletion of its group")
+	}
+	if _, err := store.Server(added[1].ID); !errors.Is(err, ErrServerNotFound) {
+		t.Errorf("server of deleted group: %v", err)
+	}
+	if err := store.DeleteGroup(groupID); !errors.Is(err, ErrGroupNotFound) {
+		t.Errorf("second DeleteGroup = %v", err)
+	}
+
+	// The manual group is emptied rather than removed.
+	if err := store.Select(manual[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteGroup(ManualGroupID); err != nil {
+		t.Fatal(err)
+	}
+	groups := store.Groups()
+	if len(groups) != 1 || groups[0].ID != ManualGroupID || len(groups[0].Servers) != 0 {
+		t.Errorf("groups = %+v", groups)
+	}
+	if _, ok := store.Selected(); ok {
+		t.Error("selection survived emptying the manual group")
+	}
+}
+
+func TestStoreMigration(t *testing.T) {
+	tests := map[string]string{
+		"v0": `{"selected":"s1","groups":[{"id":"g1","name":"Provider","servers":[
+			{"id":"s1","link":{"protocol":"trojan","address":"example.com","port":443,"password":"secret"}}]}]}`,
+		"v1": `{"version":1,"selected":"s1","groups":[{"id":"g1","name":"Provider","servers":[
+			{"id":"s1","link":{"protocol":"trojan","address":"example.com","port":443,"password":"secret"}}]}]}`,
+	}
+	for name, doc := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), StoreFileName)
+			if err := os.WriteFile(path, []byte(doc), 0644); err != nil {
+				t.Fatal(err)
+			}
+			store, err := OpenStore(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			groups := store.Groups()
+			if len(groups) != 2 || groups[0].ID != ManualGroupID || groups[1].ID != "g1" {
+				t.Fatalf("groups = %+v", groups)
+			}
+			selected, ok := store.Selected()
+			want := Link{Protocol: "trojan", Address: "example.com", Port: 443, Password: "secret"}
+			if !ok || selected.ID != "s1" || !reflect.DeepEqual(selected.Link, want) {
+				t.Errorf("selected = %+v, %v", selected, ok)
+			}
+			if err := store.Save(); err != nil {
+				t.Fatal(err)
+			}
+			raw, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(raw), fmt.Sprintf(`"version": %d`, StoreVersion)) {
+				t.Errorf("saved store is not at version %d:\n%s", StoreVersion, raw)
+			}
+		})
+	}
+}
+
+func TestStoreMigrateVMessAlterID(t *testing.T) {
+	path := filepath.Join(t.TempDir(), StoreFileName)
+	doc := `{"version":1,"selected":"s1","groups":[{"id":"g1","name":"Provider","servers":[
+		{"id":"s1","link":{"protocol":"vmess","address":"example.com","port":443,"uuid":"` + testUUID + `","encryption":"4"}},
+		{"id":"s2","link":{"protocol":"vmess","address":"example.com","port":443,"uuid":"` + testUUID + `","encryption":""}},
+		{"id":"s3","link":{"protocol":"vless","address":"example.com","port":443,"uuid":"` + testUUID + `","encryption":"none"}}]}]}`
+	if err := os.WriteFile(path, []byte(doc), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	servers := store.Groups()[1].Servers
+	want := []Link{
+		{Protocol: "vmess", Address: "example.com", Port: 443, UUID: testUUID, AlterID: 4},
+		{Protocol: "vmess", Address: "example.com", Port: 443, UUID: testUUID},
+		{Protocol: "vless", Address: "example.com", Port: 443, UUID: testUUID, Encryption: "none"},
+	}
+	for idx, server := range servers {
+		if !reflect.DeepEqual(server.Link, want[idx]) {
+			t.Errorf("server %s = %+v\nwant %+v", server.ID, server.Link, want[idx])
+		}
+	}
+
+	bad := strings.Replace(doc, `"encryption":"4"`, `"encryption":"four"`, 1)
+	if err := os.WriteFile(path, []byte(bad), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenStore(path); err == nil || !strings.Contains(err.Error(), `server s1: vmess alterId "four"`) {
+		t.Errorf("OpenStore = %v, want an alterId error", err)
+	}
+}
+
+func TestStoreRejectsNewerVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), StoreFileName)
+	if err := os.WriteFile(path, []byte(`{"version":99,"groups":[]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenStore(path); err == nil || !strings.Contains(err.Error(), "newer than supported") {
+		t.Errorf("OpenStore = %v", err)
+	}
+}
@@ -0,0 +1,206 @@
+package vpn
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultSubscriptionUserAgent is sent when the fetcher has no UserAgent set.
+// Most panels pick the response format from the user agent, and a v2rayN one
+// gets the plain link list that ParseLinksFromText understands.
+const DefaultSubscriptionUserAgent = "v2rayN/6.23"
+
+const maxSubscriptionSize = 10 << 20
+
+// ErrEmptySubscription is returned when a subscription body has no usable
+// links, such as an HTML error page or an expired-token notice.
+var ErrEmptySubscription = errors.New("subscription has no servers")
+
+type Subscription struct {
+	URL            string             `json:"url"`
+	Name           string             `json:"name,omitempty"`
+	UpdatedAt      time.Time          `json:"updatedAt"`
+	UpdateInterval time.Duration      `json:"updateInterval,omitempty"`
+	ETag           string             `json:"etag,omitempty"`
+	LastModified   string             `json:"lastModified,omitempty"`
+	Quota          *SubscriptionQuota `json:"quota,omitempty"`
+}
+
+// SubscriptionQuota is the traffic accounting advertised by the
+// subscription-userinfo header. Byte counts are zero when not advertised.
+type SubscriptionQuota struct {
+	Upload   int64     `json:"upload"`
+	Download int64     `json:"download"`
+	Total    int64     `json:"total"`
+	Expire   time.Time `json:"expire"`
+}
+
+type FetchResult struct {
+	Import      ImportResult
+	Quota       *SubscriptionQuota
+	NotModified bool
+}
+
+type SubscriptionFetcher struct {
+	Client    *http.Client
+	UserAgent string
+}
+
+// Due reports whether the subscription should be refreshed at now according
+// to the interval advertised by the provider.
+func (s Subscription) Due(now time.Time) bool {
+	if s.UpdatedAt.IsZero() || s.UpdateInterval <= 0 {
+		return true
+	}
+	return !now.Before(s.UpdatedAt.Add(s.UpdateInterval))
+}
+
+// Fetch downloads sub and parses its body. Conditional request headers are
+// sent from the stored ETag and Last-Modified values, and sub is updated in
+// place with the metadata returned by the server once the body parsed to at
+// least one link.
+func (f *SubscriptionFetcher) Fetch(ctx context.Context, sub *Subscription) (FetchResult, error) {
+	if sub == nil || strings.TrimSpace(sub.URL) == "" {
+		return FetchResult{}, errors.New("missing subscription url")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sub.URL, nil)
+	if err != nil {
+		return FetchResult{}, fmt.Errorf("create subscription request: %w", err)
+	}
+	req.Header.Set("User-Agent", firstNonEmpty(f.UserAgent, DefaultSubscriptionUserAgent))
+	if sub.ETag != "" {
+		req.Header.Set("If-None-Match", sub.ETag)
+	}
+	if sub.LastModified != "" {
+		req.Header.Set("If-Modified-Since", sub.LastModified)
+	}
+
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return FetchResult{}, fmt.Errorf("fetch subscription: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		sub.UpdatedAt = time.Now()
+		return FetchResult{Quota: sub.Quota, NotModified: true}, nil
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return FetchResult{}, fmt.Errorf("fetch subscription: unexpected status %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxSubscriptionSize+1))
+	if err != nil {
+		return FetchResult{}, fmt.Errorf("read subscription body: %w", err)
+	}
+	if len(body) > maxSubscriptionSize {
+		return FetchResult{}, fmt.Errorf("subscription body exceeds %d bytes", maxSubscriptionSize)
+	}
+
+	result := ParseLinksFromText(string(body))
+	if len(result.Links) == 0 {
+		if len(result.Errors) > 0 {
+			return FetchResult{}, fmt.Errorf("%w: %w", ErrEmptySubscription, result.Errors[0])
+		}
+		return FetchResult{}, ErrEmptySubscription
+	}
+
+	applySubscriptionHeaders(sub, resp.Header)
+	sub.UpdatedAt = time.Now()
+
+	return FetchResult{
+		Import: result,
+		Quota:  sub.Quota,
+	}, nil
+}
+
+func applySubscriptionHeaders(sub *Subscription, header http.Header) {
+	sub.ETag = header.Get("ETag")
+	sub.LastModified = header.Get("Last-Modified")
+
+	if quota, ok := parseSubscriptionUserinfo(header.Get("Subscription-Userinfo")); ok {
+		sub.Quota = &quota
+	}
+	if hours, err := strconv.ParseFloat(strings.TrimSpace(header.Get("Profile-Update-Interval")), 64); err == nil && hours > 0 {
+		sub.UpdateInterval = time.Duration(hours * float64(time.Hour))
+	}
+	if sub.Name == "" {
+		sub.Name = firstNonEmpty(
+			decodeProfileTitle(header.Get("Profile-Title")),
+			contentDispositionName(header.Get("Content-Disposition")),
+		)
+	}
+}
+
+// parseSubscriptionUserinfo parses "upload=1; download=2; total=3; expire=4".
+func parseSubscriptionUserinfo(value string) (SubscriptionQuota, bool) {
+	var quota SubscriptionQuota
+	found := false
+	for _, field := range strings.Split(value, ";") {
+		key, raw, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			continue
+		}
+		number, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+		if err != nil {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "upload":
+			quota.Upload = number
+		case "download":
+			quota.Download = number
+		case "total":
+			quota.Total = number
+		case "expire":
+			if number > 0 {
+				quota.Expire = time.Unix(number, 0).UTC()
+			}
+		default:
+			continue
+		}
+		found = true
+	}
+	return quota, found
+}
+
+func decodeProfileTitle(value string) string {
+	value = strings.TrimSpace(value)
+	if encoded, ok := strings.CutPrefix(value, "base64:"); ok {
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(string(decoded))
+	}
+	return value
+}
+
+func contentDispositionName(value string) string {
+	if value == "" {
+		return ""
+	}
+	_, params, err := mime.ParseMediaType(value)
+	if err != nil {
+		return ""
+	}
+	filename := filepath.Base(params["filename"])
+	if filename == "." || filename == string(filepath.Separator) {
+		return ""
+	}
+	return strings.TrimSuffix(filename, filepath.Ext(filename))
+}
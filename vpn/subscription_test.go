@@ -0,0 +1,164 @@
+package vpn
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSubscriptionBody = "trojan://secret@example.com:443#one\nvless://" + testUUID + "@example.com:443?security=tls#two\n"
+
+func TestSubscriptionFetch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("User-Agent"); got != DefaultSubscriptionUserAgent {
+			t.Errorf("User-Agent = %q", got)
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Subscription-Userinfo", "upload=1; download=2; total=30; expire=1700000000")
+		w.Header().Set("Profile-Update-Interval", "12")
+		w.Header().Set("Profile-Title", "base64:TXkgVlBO")
+		_, _ = w.Write([]byte(testSubscriptionBody))
+	}))
+	defer server.Close()
+
+	var fetcher SubscriptionFetcher
+	sub := &Subscription{URL: server.URL}
+	result, err := fetcher.Fetch(context.Background(), sub)
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if len(result.Import.Links) != 2 {
+		t.Errorf("got %d links, want 2", len(result.Import.Links))
+	}
+	if sub.ETag != `"v1"` || sub.Name != "My VPN" || sub.UpdateInterval != 12*time.Hour {
+		t.Errorf("subscription metadata = %+v", sub)
+	}
+	want := SubscriptionQuota{Upload: 1, Download: 2, Total: 30, Expire: time.Unix(1700000000, 0).UTC()}
+	if result.Quota == nil || *result.Quota != want {
+		t.Errorf("quota = %+v, want %+v", result.Quota, want)
+	}
+
+	result, err = fetcher.Fetch(context.Background(), sub)
+	if err != nil {
+		t.Fatalf("conditional Fetch: %v", err)
+	}
+	if !result.NotModified || result.Quota == nil {
+		t.Errorf("conditional result = %+v", result)
+	}
+}
+
+func TestSubscriptionFetchRejectsBodiesWithoutLinks(t *testing.T) {
+	for name, body := range map[string]string{
+		"html error page": "<html><body>Token expired</body></html>",
+		"empty":           "",
+		"only errors":     "vless://missing-host\nfoo://bar\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", `"new"`)
+				_, _ = w.Write([]byte(body))
+			}))
+			defer server.Close()
+
+			var fetcher SubscriptionFetcher
+			updatedAt := time.Unix(1, 0)
+			sub := &Subscription{URL: server.URL, ETag: `"old"`, UpdatedAt: updatedAt}
+			if _, err := fetcher.Fetch(context.Background(), sub); !errors.Is(err, ErrEmptySubscription) {
+				t.Fatalf("Fetch error = %v, want ErrEmptySubscription", err)
+			}
+			if sub.ETag != `"old"` || !sub.UpdatedAt.Equal(updatedAt) {
+				t.Errorf("subscription was updated: %+v", sub)
+			}
+		})
+	}
+}
+
+func TestSubscriptionFetchStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	var fetcher SubscriptionFetcher
+	if _, err := fetcher.Fetch(context.Background(), &Subscription{URL: server.URL}); err == nil {
+		t.Fatal("Fetch succeeded on 403")
+	}
+}
+
+func TestSubscriptionFetchHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("User-Agent"); got != "custom/1.0" {
+			t.Errorf("User-Agent = %q", got)
+		}
+		if got := r.Header.Get("If-Modified-Since"); got != "Mon, 02 Jan 2006 15:04:05 GMT" {
+			t.Errorf("If-Modified-Since = %q", got)
+		}
+		w.Header().Set("Last-Modified", "Tue, 03 Jan 2006 15:04:05 GMT")
+		w.Header().Set("Content-Disposition", `attachment; filename="Work Servers.txt"`)
+		_, _ = w.Write([]byte(base64.StdEncoding.EncodeToString([]byte(testSubscriptionBody))))
+	}))
+	defer server.Close()
+
+	fetcher := SubscriptionFetcher{UserAgent: "custom/1.0"}
+	sub := &Subscription{URL: server.URL, LastModified: "Mon, 02 Jan 2006 15:04:05 GMT"}
+	result, err := fetcher.Fetch(context.Background(), sub)
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if len(result.Import.Links) != 2 || result.Quota != nil {
+		t.Errorf("result = %+v", result)
+	}
+	if sub.LastModified != "Tue, 03 Jan 2006 15:04:05 GMT" || sub.Name != "Work Servers" {
+		t.Errorf("subscription metadata = %+v", sub)
+	}
+}
+
+func TestSubscriptionFetchSizeLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(strings.Repeat("a", maxSubscriptionSize+1)))
+	}))
+	defer server.Close()
+
+	var fetcher SubscriptionFetcher
+	if _, err := fetcher.Fetch(context.Background(), &Subscription{URL: server.URL}); err == nil {
+		t.Fatal("Fetch accepted an oversized body")
+	}
+}
+
+func TestSubscriptionDue(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		name string
+		sub  Subscription
+		want bool
+	}{
+		{"never updated", Subscription{UpdateInterval: time.Hour}, true},
+		{"no interval", Subscription{UpdatedAt: now}, true},
+		{"fresh", Subscription{UpdatedAt: now.Add(-30 * time.Minute), UpdateInterval: time.Hour}, false},
+		{"stale", Subscription{UpdatedAt: now.Add(-time.Hour), UpdateInterval: time.Hour}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.sub.Due(now); got != tt.want {
+			t.Errorf("%s: Due = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseSubscriptionUserinfo(t *testing.T) {
+	quota, ok := parseSubscriptionUserinfo("upload=10;download=bad; TOTAL = 100 ; expire=0; extra=1")
+	if !ok || quota != (SubscriptionQuota{Upload: 10, Total: 100}) {
+		t.Errorf("got %+v, %v", quota, ok)
+	}
+	if _, ok := parseSubscriptionUserinfo("garbage"); ok {
+		t.Error("parsed a header without known fields")
+	}
+}
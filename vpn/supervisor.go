@@ -0,0 +1,365 @@
+package vpn
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/portapps/portapps/v3/pkg/log"
+	"github.com/rs/zerolog"
+)
+
+type CoreState int
+
+const (
+	CoreStarting CoreState = iota
+	CoreRunning
+	CoreFailed
+	CoreStopped
+)
+
+func (s CoreState) String() string {
+	switch s {
+	case CoreStarting:
+		return "starting"
+	case CoreRunning:
+		return "running"
+	case CoreFailed:
+		return "failed"
+	case CoreStopped:
+		return "stopped"
+	default:
+		return fmt.Sprintf("CoreState(%d)", int(s))
+	}
+}
+
+type SupervisorOptions struct {
+	CorePath   string
+	ConfigPath string
+	// ReadyPort, when set, delays the running state until a listener
+	// accepts connections on that port of ReadyHost, 127.0.0.1 by default.
+	ReadyHost string
+	ReadyPort int
+	// MaxRestarts is the number of consecutive crashes tolerated before the
+	// supervisor gives up; negative disables restarts. A core that stays up
+	// for StableAfter resets the count.
+	MaxRestarts    int
+	StableAfter    time.Duration
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	ReadyTimeout   time.Duration
+	StopTimeout    time.Duration
+	Logger         *zerolog.Logger
+}
+
+// Supervisor runs a proxy core as a child process, restarting it with
+// exponential backoff when it crashes.
+type Supervisor struct {
+	opts   SupervisorOptions
+	logger zerolog.Logger
+	states chan CoreState
+
+	mu      sync.Mutex
+	state   CoreState
+	err     error
+	started bool
+	ready   chan struct{}
+
+	restart  chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+type coreProcess struct {
+	cmd     *exec.Cmd
+	exited  chan struct{}
+	waitErr error
+}
+
+func NewSupervisor(opts SupervisorOptions) *Supervisor {
+	if opts.MaxRestarts == 0 {
+		opts.MaxRestarts = 5
+	}
+	if opts.StableAfter == 0 {
+		opts.StableAfter = time.Minute
+	}
+	if opts.InitialBackoff == 0 {
+		opts.InitialBackoff = 500 * time.Millisecond
+	}
+	if opts.MaxBackoff == 0 {
+		opts.MaxBackoff = 30 * time.Second
+	}
+	if opts.ReadyTimeout == 0 {
+		opts.ReadyTimeout = 15 * time.Second
+	}
+	if opts.StopTimeout == 0 {
+		opts.StopTimeout = 5 * time.Second
+	}
+
+	logger := log.With().Str("component", filepath.Base(opts.CorePath)).Logger()
+	if opts.Logger != nil {
+		logger = *opts.Logger
+	}
+
+	return &Supervisor{
+		opts:    opts,
+		logger:  logger,
+		states:  make(chan CoreState, 16),
+		state:   CoreStopped,
+		ready:   make(chan struct{}),
+		restart: make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// States delivers state transitions. When the channel is not drained, the
+// oldest transitions are dropped so that the latest one, such as CoreFailed,
+// is always delivered; State always reports the current one.
+func (s *Supervisor) States() <-chan CoreState {
+	return s.states
+}
+
+func (s *Supervisor) State() CoreState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+// Err returns the last error that made the core exit.
+func (s *Supervisor) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Start launches the core and blocks until it is running, it fails for good
+// or ctx is done. The supervisor keeps running after Start returns nil.
+func (s *Supervisor) Start(ctx context.Context) error {
+	s.mu.Lock()
+	if s.started {
+		s.mu.Unlock()
+		return errors.New("supervisor already started")
+	}
+	s.started = true
+	s.mu.Unlock()
+
+	go s.run()
+
+	select {
+	case <-s.ready:
+	case <-ctx.Done():
+		s.Stop()
+		return fmt.Errorf("start core: %w", ctx.Err())
+	}
+	if s.State() != CoreRunning {
+		s.Stop()
+		return fmt.Errorf("start core: %w", s.Err())
+	}
+	return nil
+}
+
+// Restart stops the running core and starts it again, e.g. after its config
+// file was rewritten. It does not count towards MaxRestarts and also revives
+// a supervisor that gave up.
+func (s *Supervisor) Restart() {
+	select {
+	case s.restart <- struct{}{}:
+	default:
+	}
+}
+
+// Stop shuts the core down, killing it if it does not exit within
+// StopTimeout, and waits for the supervisor to finish.
+func (s *Supervisor) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+	s.mu.Lock()
+	started := s.started
+	s.mu.Unlock()
+	if started {
+		<-s.done
+	}
+}
+
+func (s *Supervisor) run() {
+	defer close(s.done)
+
+	backoff := s.opts.InitialBackoff
+	crashes := 0
+	for {
+		s.setState(CoreStarting, nil)
+		proc, err := s.spawn()
+		if err == nil {
+			startedAt := time.Now()
+			if err = s.waitReady(proc); err != nil {
+				s.terminate(proc)
+			} else {
+				s.setState(CoreRunning, nil)
+
+				select {
+				case <-proc.exited:
+					err = fmt.Errorf("core exited: %v", proc.waitErr)
+				case <-s.restart:
+					s.logger.Info().Msg("Restarting core")
+					s.terminate(proc)
+					crashes, backoff = 0, s.opts.InitialBackoff
+					continue
+				case <-s.stop:
+					s.terminate(proc)
+					s.setState(CoreStopped, nil)
+					return
+				}
+				if time.Since(startedAt) >= s.opts.StableAfter {
+					crashes, backoff = 0, s.opts.InitialBackoff
+				}
+			}
+		}
+
+		crashes++
+		if crashes > s.opts.MaxRestarts {
+			s.logger.Error().Err(err).Msgf("Core failed %d times in a row, giving up", crashes)
+			s.setState(CoreFailed, err)
+			// Stay failed until the config is rewritten and Restart is
+			// called, e.g. by failover switching to another server.
+			select {
+			case <-s.restart:
+				s.logger.Info().Msg("Restarting failed core")
+				crashes, backoff = 0, s.opts.InitialBackoff
+				continue
+			case <-s.stop:
+				return
+			}
+		}
+		s.logger.Warn().Err(err).Msgf("Core crashed, restarting in %s", backoff)
+		s.setState(CoreStarting, err)
+
+		select {
+		case <-time.After(backoff):
+		case <-s.restart:
+		case <-s.stop:
+			s.setState(CoreStopped, err)
+			return
+		}
+		backoff = min(backoff*2, s.opts.MaxBackoff)
+	}
+}
+
+func (s *Supervisor) spawn() (*coreProcess, error) {
+	cmd := exec.Command(s.opts.CorePath, CoreArgs(s.opts.ConfigPath)...)
+	cmd.Dir = filepath.Dir(s.opts.CorePath)
+	configureCoreCmd(cmd)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("pipe core stdout: %w", err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, fmt.Errorf("pipe core stderr: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start core: %w", err)
+	}
+
+	proc := &coreProcess{cmd: cmd, exited: make(chan struct{})}
+	var pipes sync.WaitGroup
+	pipes.Add(2)
+	go s.pipeLog(&pipes, stdout, zerolog.InfoLevel)
+	go s.pipeLog(&pipes, stderr, zerolog.WarnLevel)
+	go func() {
+		pipes.Wait()
+		proc.waitErr = cmd.Wait()
+		close(proc.exited)
+	}()
+	return proc, nil
+}
+
+func (s *Supervisor) pipeLog(wg *sync.WaitGroup, reader io.Reader, level zerolog.Level) {
+	defer wg.Done()
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		s.logger.WithLevel(level).Msg(scanner.Text())
+	}
+}
+
+func (s *Supervisor) waitReady(proc *coreProcess) error {
+	if s.opts.ReadyPort == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.opts.ReadyTimeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-proc.exited:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	if err := WaitForPort(ctx, s.opts.ReadyHost, s.opts.ReadyPort); err != nil {
+		return fmt.Errorf("core not ready: %w", err)
+	}
+	return nil
+}
+
+// terminate interrupts the core so that it can close its connections, and
+// kills it when it does not exit within StopTimeout or cannot be
+// interrupted.
+func (s *Supervisor) terminate(proc *coreProcess) {
+	if err := interruptCore(proc.cmd.Process); err != nil {
+		s.logger.Debug().Err(err).Msg("Cannot interrupt core")
+	} else {
+		select {
+		case <-proc.exited:
+			return
+		case <-time.After(s.opts.StopTimeout):
+			s.logger.Warn().Msgf("Core did not exit within %s, killing it", s.opts.StopTimeout)
+		}
+	}
+	if err := proc.cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		s.logger.Error().Err(err).Msg("Cannot kill core")
+	}
+	<-proc.exited
+}
+
+func (s *Supervisor) setState(state CoreState, err error) {
+	s.mu.Lock()
+	changed := s.state != state
+	s.state = state
+	if err != nil || state == CoreRunning {
+		s.err = err
+	}
+	if state == CoreRunning || state == CoreFailed || state == CoreStopped {
+		select {
+		case <-s.ready:
+		default:
+			close(s.ready)
+		}
+	}
+	s.mu.Unlock()
+
+	if !changed {
+		return
+	}
+	for {
+		select {
+		case s.states <- state:
+			return
+		default:
+		}
+		// Make room by dropping the oldest transition.
+		select {
+		case <-s.states:
+		default:
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package vpn
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// The test binary doubles as a fake core when VPN_FAKE_CORE is set: "crash"
+// exits right away, "serve" listens on VPN_FAKE_CORE_PORT until interrupted,
+// "serve-crash" listens briefly and then exits with an error and "socks"
+// serves SOCKS5 on the inbound of its config file. Every start is appended
+// to VPN_FAKE_CORE_LOG.
+func TestMain(m *testing.M) {
+	if mode := os.Getenv("VPN_FAKE_CORE"); mode != "" {
+		os.Exit(runFakeCore(mode))
+	}
+	os.Exit(m.Run())
+}
+
+func runFakeCore(mode string) int {
+	if path := os.Getenv("VPN_FAKE_CORE_LOG"); path != "" {
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err == nil {
+			fmt.Fprintln(file, time.Now().UnixNano())
+			file.Close()
+		}
+	}
+	switch mode {
+	case "crash":
+		fmt.Fprintln(os.Stderr, "fake core crashed")
+		return 1
+	case "socks":
+		return runFakeSocksCore(os.Args[len(os.Args)-1])
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:"+os.Getenv("VPN_FAKE_CORE_PORT"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	timeout := time.After(time.Minute)
+	if mode == "serve-crash" {
+		timeout = time.After(200 * time.Millisecond)
+	}
+	select {
+	case <-interrupt:
+		return 0
+	case <-timeout:
+		return 2
+	}
+}
+
+func newFakeCoreSupervisor(t *testing.T, mode string, opts SupervisorOptions) (*Supervisor, string) {
+	t.Helper()
+	port, err := FreePort()
+	if err != nil {
+		t.Fatal(err)
+	}
+	logPath := t.TempDir() + "/starts.log"
+	t.Setenv("VPN_FAKE_CORE", mode)
+	t.Setenv("VPN_FAKE_CORE_PORT", fmt.Sprint(port))
+	t.Setenv("VPN_FAKE_CORE_LOG", logPath)
+
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := zerolog.Nop()
+	opts.Logger = &logger
+	opts.CorePath = executable
+	opts.ConfigPath = "config.json"
+	opts.ReadyPort = port
+	return NewSupervisor(opts), logPath
+}
+
+func fakeCoreStarts(t *testing.T, logPath string) []time.Time {
+	t.Helper()
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var starts []time.Time
+	for _, line := range strings.Fields(string(data)) {
+		var nanos int64
+		fmt.Sscan(line, &nanos)
+		starts = append(starts, time.Unix(0, nanos))
+	}
+	return starts
+}
+
+func TestSupervisorStartStop(t *testing.T) {
+	supervisor, logPath := newFakeCoreSupervisor(t, "serve", SupervisorOptions{StopTimeout: 10 * time.Second})
+	if err := supervisor.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if state := supervisor.State(); state != CoreRunning {
+		t.Fatalf("state = %s, want running", state)
+	}
+
+	start := time.Now()
+	supervisor.Stop()
+	if state := supervisor.State(); state != CoreStopped {
+		t.Errorf("state = %s, want stopped", state)
+	}
+	if elapsed := time.Since(start); elapsed >= 10*time.Second {
+		t.Errorf("core was killed after %s instead of exiting on interrupt", elapsed)
+	}
+	if starts := fakeCoreStarts(t, logPath); len(starts) != 1 {
+		t.Errorf("core started %d times, want 1", len(starts))
+	}
+}
+
+func TestSupervisorGivesUpWithBackoff(t *testing.T) {
+	supervisor, logPath := newFakeCoreSupervisor(t, "crash", SupervisorOptions{
+		MaxRestarts:    3,
+		InitialBackoff: 50 * time.Millisecond,
+		MaxBackoff:     100 * time.Millisecond,
+	})
+	if err := supervisor.Start(context.Background()); err == nil {
+		t.Fatal("Start succeeded with a crashing core")
+	}
+	supervisor.Stop()
+	if state := supervisor.State(); state != CoreFailed {
+		t.Errorf("state = %s, want failed", state)
+	}
+	if supervisor.Err() == nil {
+		t.Error("Err is nil after giving up")
+	}
+
+	starts := fakeCoreStarts(t, logPath)
+	if len(starts) != 4 {
+		t.Fatalf("core started %d times, want 4", len(starts))
+	}
+	// Backoffs of 50, 100 and 100ms, capped by MaxBackoff.
+	for idx, want := range []time.Duration{50, 100, 100} {
+		if gap := starts[idx+1].Sub(starts[idx]); gap < want*time.Millisecond {
+			t.Errorf("restart %d after %s, want at least %dms", idx+1, gap, want)
+		}
+	}
+}
+
+func TestSupervisorReportsCrashAfterRunning(t *testing.T) {
+	supervisor, logPath := newFakeCoreSupervisor(t, "serve-crash", SupervisorOptions{
+		MaxRestarts:    1,
+		InitialBackoff: 10 * time.Millisecond,
+	})
+	if err := supervisor.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	var last CoreState
+	timeout := time.After(10 * time.Second)
+	for last != CoreFailed {
+		select {
+		case last = <-supervisor.States():
+		case <-timeout:
+			t.Fatalf("no failed state, last %s", last)
+		}
+	}
+	supervisor.Stop()
+	if starts := fakeCoreStarts(t, logPath); len(starts) != 2 {
+		t.Errorf("core started %d times, want 2", len(starts))
+	}
+}
+
+func TestSupervisorRestart(t *testing.T) {
+	supervisor, logPath := newFakeCoreSupervisor(t, "serve", SupervisorOptions{})
+	if err := supervisor.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer supervisor.Stop()
+	<-supervisor.States()
+	<-supervisor.States()
+
+	supervisor.Restart()
+	timeout := time.After(10 * time.Second)
+	for _, want := range []CoreState{CoreStarting, CoreRunning} {
+		select {
+		case state := <-supervisor.States():
+			if state != want {
+				t.Fatalf("state = %s, want %s", state, want)
+			}
+		case <-timeout:
+			t.Fatalf("no %s state after Restart", want)
+		}
+	}
+	if starts := fakeCoreStarts(t, logPath); len(starts) != 2 {
+		t.Errorf("core started %d times, want 2", len(starts))
+	}
+}
+
+func TestSupervisorRestartRevivesFailed(t *testing.T) {
+	supervisor, logPath := newFakeCoreSupervisor(t, "serve-crash", SupervisorOptions{
+		MaxRestarts:    -1,
+		InitialBackoff: 10 * time.Millisecond,
+	})
+	if err := supervisor.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer supervisor.Stop()
+
+	waitState := func(want CoreState) {
+		t.Helper()
+		timeout := time.After(10 * time.Second)
+		for {
+			select {
+			case state := <-supervisor.States():
+				if state == want {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("no %s state", want)
+			}
+		}
+	}
+	waitState(CoreFailed)
+	supervisor.Restart()
+	waitState(CoreRunning)
+	if starts := fakeCoreStarts(t, logPath); len(starts) != 2 {
+		t.Errorf("core started %d times, want 2", len(starts))
+	}
+}
+
+func TestSupervisorStatesKeepLatest(t *testing.T) {
+	supervisor := NewSupervisor(SupervisorOptions{CorePath: "core"})
+	for idx := 0; idx < 100; idx++ {
+		supervisor.setState(CoreStarting, nil)
+		supervisor.setState(CoreRunning, nil)
+	}
+	supervisor.setState(CoreFailed, fmt.Errorf("crashed"))
+
+	var last CoreState
+	for len(supervisor.States()) > 0 {
+		last = <-supervisor.States()
+	}
+	if last != CoreFailed {
+		t.Errorf("last delivered state = %s, want failed", last)
+	}
+}
@@ -1,26 +1,78 @@
 package vpn
 
 type Link struct {
-	Protocol      string
-	Name          string
-	Address       string
-	Port          int
-	UUID          string
-	Encryption    string
-	Security      string
-	Transport     string
-	SNI           string
-	Host          string
-	Path          string
-	Fingerprint   string
-	Flow          string
-	ALPN          []string
-	ServiceName   string
-	AllowInsecure bool
-	Raw           string
+	Protocol string `json:"protocol"`
+	Name     string `json:"name,omitempty"`
+	Address  string `json:"address"`
+	Port     int    `json:"port"`
+	UUID     string `json:"uuid,omitempty"`
+	// AlterID and Cipher are the VMess alterId and user security.
+	AlterID       int      `json:"alterId,omitempty"`
+	Cipher        string   `json:"cipher,omitempty"`
+	Password      string   `json:"password,omitempty"`
+	Method        string   `json:"method,omitempty"`
+	Plugin        string   `json:"plugin,omitempty"`
+	PluginOpts    string   `json:"pluginOpts,omitempty"`
+	Encryption    string   `json:"encryption,omitempty"`
+	Security      string   `json:"security,omitempty"`
+	Transport     string   `json:"transport,omitempty"`
+	SNI           string   `json:"sni,omitempty"`
+	Host          string   `json:"host,omitempty"`
+	Path          string   `json:"path,omitempty"`
+	Fingerprint   string   `json:"fingerprint,omitempty"`
+	PublicKey     string   `json:"publicKey,omitempty"`
+	ShortID       string   `json:"shortId,omitempty"`
+	SpiderX       string   `json:"spiderX,omitempty"`
+	MLDSA65Verify string   `json:"mldsa65Verify,omitempty"`
+	Flow          string   `json:"flow,omitempty"`
+	ALPN          []string `json:"alpn,omitempty"`
+	ServiceName   string   `json:"serviceName,omitempty"`
+	// Authority overrides the gRPC :authority header.
+	Authority string `json:"authority,omitempty"`
+	// Mode is the xhttp mode, or "multi" for gRPC multi mode.
+	Mode string `json:"mode,omitempty"`
+	// Extra is the raw xhttp extra JSON object.
+	Extra string `json:"extra,omitempty"`
+	// HeaderType is the tcp, kcp or quic header obfuscation.
+	HeaderType string `json:"headerType,omitempty"`
+	// Seed is the mKCP obfuscation seed.
+	Seed string `json:"seed,omitempty"`
+	// QUICSecurity and QUICKey encrypt QUIC transport packets.
+	QUICSecurity  string `json:"quicSecurity,omitempty"`
+	QUICKey       string `json:"quicKey,omitempty"`
+	AllowInsecure bool   `json:"allowInsecure,omitempty"`
+	// Obfs and ObfsPassword configure Hysteria2 obfuscation.
+	Obfs         string `json:"obfs,omitempty"`
+	ObfsPassword string `json:"obfsPassword,omitempty"`
+	// CongestionControl and UDPRelayMode are TUIC options.
+	CongestionControl string `json:"congestionControl,omitempty"`
+	UDPRelayMode      string `json:"udpRelayMode,omitempty"`
+	// UpMbps and DownMbps are Hysteria2 bandwidth hints, zero for BBR.
+	UpMbps   int `json:"upMbps,omitempty"`
+	DownMbps int `json:"downMbps,omitempty"`
+	// WireGuard fields. PeerPublicKey is the server key, LocalAddress the
+	// interface addresses as CIDRs and Reserved the three reserved bytes.
+	PrivateKey    string   `json:"privateKey,omitempty"`
+	PeerPublicKey string   `json:"peerPublicKey,omitempty"`
+	PresharedKey  string   `json:"presharedKey,omitempty"`
+	LocalAddress  []string `json:"localAddress,omitempty"`
+	AllowedIPs    []string `json:"allowedIPs,omitempty"`
+	Reserved      []int    `json:"reserved,omitempty"`
+	MTU           int      `json:"mtu,omitempty"`
+	DNS           []string `json:"dns,omitempty"`
+	Raw           string   `json:"raw,omitempty"`
 }
 
 type ImportResult struct {
 	Links  []Link
 	Errors []error
+	Groups []ImportGroup
+}
+
+// ImportGroup is a named server group from the imported document. Members
+// are link names.
+type ImportGroup struct {
+	Name    string
+	Type    string
+	Members []string
 }
@@ -0,0 +1,343 @@
+package vpn
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var wireGuardInterfaceSection = regexp.MustCompile(`(?mi)^\s*\[Interface\]`)
+
+func isWireGuardConf(text string) bool {
+	return wireGuardInterfaceSection.MatchString(text)
+}
+
+// ParseWireGuardConf converts a wg-quick config into one link per peer.
+// Unknown keys such as PostUp or Table are ignored.
+func ParseWireGuardConf(data []byte) (ImportResult, error) {
+	var iface Link
+	var peers []Link
+	var peerLines []int
+	section := ""
+
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.ToLower(strings.Trim(line, "[]"))
+			switch section {
+			case "interface":
+			case "peer":
+				peers = append(peers, Link{})
+				peerLines = append(peerLines, lineNo)
+			default:
+				return ImportResult{}, fmt.Errorf("line %d: unknown section %q", lineNo, line)
+			}
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return ImportResult{}, fmt.Errorf("line %d: expected key = value", lineNo)
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		var err error
+		switch section {
+		case "interface":
+			err = applyWireGuardInterface(&iface, key, value)
+		case "peer":
+			err = applyWireGuardPeer(&peers[len(peers)-1], key, value)
+		default:
+			err = errors.New("key outside of a section")
+		}
+		if err != nil {
+			return ImportResult{}, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return ImportResult{}, fmt.Errorf("read wireguard config: %w", err)
+	}
+	if len(peers) == 0 {
+		return ImportResult{}, errors.New("no peers in wireguard config")
+	}
+
+	result := ImportResult{
+		Links:  make([]Link, 0, len(peers)),
+		Errors: []error{},
+	}
+	for idx, peer := range peers {
+		link := iface
+		link.Protocol = "wireguard"
+		link.Address = peer.Address
+		link.Port = peer.Port
+		link.PeerPublicKey = peer.PeerPublicKey
+		link.PresharedKey = peer.PresharedKey
+		link.AllowedIPs = peer.AllowedIPs
+		if peer.Reserved != nil {
+			link.Reserved = peer.Reserved
+		}
+		if err := validateWireGuard(link); err != nil {
+			result.Errors = append(result.Errors, fmt.Errorf("peer %d (line %d): %w", idx+1, peerLines[idx], err))
+			continue
+		}
+		result.Links = append(result.Links, link)
+	}
+	return result, nil
+}
+
+func applyWireGuardInterface(link *Link, key, value string) error {
+	switch key {
+	case "privatekey":
+		link.PrivateKey = value
+	case "address":
+		addresses, err := parseWireGuardAddresses(value)
+		if err != nil {
+			return err
+		}
+		link.LocalAddress = append(link.LocalAddress, addresses...)
+	case "dns":
+		link.DNS = append(link.DNS, splitCSV(value)...)
+	case "mtu":
+		mtu, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid mtu %q", value)
+		}
+		link.MTU = mtu
+	case "reserved":
+		reserved, err := parseWireGuardReserved(value)
+		if err != nil {
+			return err
+		}
+		link.Reserved = reserved
+	}
+	return nil
+}
+
+func applyWireGuardPeer(link *Link, key, value string) error {
+	switch key {
+	case "publickey":
+		link.PeerPublicKey = value
+	case "presharedkey":
+		link.PresharedKey = value
+	case "endpoint":
+		host, port, err := splitHostPort(value, 0)
+		if err != nil {
+			return err
+		}
+		link.Address = strings.Trim(host, "[]")
+		link.Port = port
+	case "allowedips":
+		for _, cidr := range splitCSV(value) {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return fmt.Errorf("invalid allowed ip %q", cidr)
+			}
+			link.AllowedIPs = append(link.AllowedIPs, cidr)
+		}
+	case "reserved":
+		reserved, err := parseWireGuardReserved(value)
+		if err != nil {
+			return err
+		}
+		link.Reserved = reserved
+	}
+	return nil
+}
+
+// parseWireGuard parses the wireguard:// URIs shared by v2rayN and sing-box
+// clients, with the URL-encoded private key as userinfo.
+func parseWireGuard(raw string) (Link, error) {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return Link{}, fmt.Errorf("parse wireguard url: %w", err)
+	}
+	if parsed.User == nil || parsed.User.Username() == "" {
+		return Link{}, errors.New("missing private key")
+	}
+	host, port, err := splitHostPort(parsed.Host, 51820)
+	if err != nil {
+		return Link{}, err
+	}
+
+	query := parsed.Query()
+	link := Link{
+		Protocol:      "wireguard",
+		Name:          parsed.Fragment,
+		Address:       host,
+		Port:          port,
+		PrivateKey:    parsed.User.Username(),
+		PeerPublicKey: firstNonEmpty(query.Get("publickey"), query.Get("publicKey"), query.Get("peer_public_key")),
+		PresharedKey:  firstNonEmpty(query.Get("presharedkey"), query.Get("presharedKey"), query.Get("pre_shared_key")),
+		AllowedIPs:    splitCSV(firstNonEmpty(query.Get("allowedips"), query.Get("allowedIPs"))),
+		DNS:           splitCSV(query.Get("dns")),
+		Raw:           raw,
+	}
+	if link.LocalAddress, err = parseWireGuardAddresses(firstNonEmpty(query.Get("address"), query.Get("ip"))); err != nil {
+		return Link{}, err
+	}
+	if mtu := query.Get("mtu"); mtu != "" {
+		if link.MTU, err = strconv.Atoi(mtu); err != nil {
+			return Link{}, fmt.Errorf("invalid mtu %q", mtu)
+		}
+	}
+	if link.Reserved, err = parseWireGuardReserved(query.Get("reserved")); err != nil {
+		return Link{}, err
+	}
+	if err := validateWireGuard(link); err != nil {
+		return Link{}, err
+	}
+	return link, nil
+}
+
+// parseWireGuardAddresses parses interface addresses, giving bare IPs a
+// single host prefix like wg-quick does.
+func parseWireGuardAddresses(value string) ([]string, error) {
+	var addresses []string
+	for _, address := range splitCSV(value) {
+		if strings.Contains(address, "/") {
+			if _, _, err := net.ParseCIDR(address); err != nil {
+				return nil, fmt.Errorf("invalid address %q", address)
+			}
+			addresses = append(addresses, address)
+			continue
+		}
+		ip := net.ParseIP(address)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid address %q", address)
+		}
+		if ip.To4() != nil {
+			addresses = append(addresses, address+"/32")
+		} else {
+			addresses = append(addresses, address+"/128")
+		}
+	}
+	return addresses, nil
+}
+
+// parseWireGuardReserved accepts the three reserved bytes as a list of
+// numbers or as base64, like WARP clients print them.
+func parseWireGuardReserved(value string) ([]int, error) {
+	value = strings.Trim(strings.TrimSpace(value), "[]")
+	if value == "" {
+		return nil, nil
+	}
+	if !strings.Contains(value, ",") {
+		if decoded, err := base64.StdEncoding.DecodeString(value); err == nil && len(decoded) == 3 {
+			return []int{int(decoded[0]), int(decoded[1]), int(decoded[2])}, nil
+		}
+	}
+	parts := splitCSV(value)
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("reserved %q: expected 3 bytes", value)
+	}
+	reserved := make([]int, len(parts))
+	for idx, part := range parts {
+		number, err := strconv.Atoi(part)
+		if err != nil || number < 0 || number > 255 {
+			return nil, fmt.Errorf("reserved %q: invalid byte %q", value, part)
+		}
+		reserved[idx] = number
+	}
+	return reserved, nil
+}
+
+func validateWireGuard(link Link) error {
+	if link.Address == "" || link.Port == 0 {
+		return errors.New("missing endpoint")
+	}
+	if err := validateWireGuardKey("private key", link.PrivateKey, true); err != nil {
+		return err
+	}
+	if err := validateWireGuardKey("public key", link.PeerPublicKey, true); err != nil {
+		return err
+	}
+	if err := validateWireGuardKey("preshared key", link.PresharedKey, false); err != nil {
+		return err
+	}
+	if len(link.LocalAddress) == 0 {
+		return errors.New("missing interface address")
+	}
+	for _, address := range link.LocalAddress {
+		if _, _, err := net.ParseCIDR(address); err != nil {
+			return fmt.Errorf("invalid address %q", address)
+		}
+	}
+	for _, cidr := range link.AllowedIPs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("invalid allowed ip %q", cidr)
+		}
+	}
+	if link.MTU < 0 || link.MTU > 65535 {
+		return fmt.Errorf("invalid mtu %d", link.MTU)
+	}
+	if len(link.Reserved) != 0 && len(link.Reserved) != 3 {
+		return fmt.Errorf("reserved: expected 3 bytes, got %d", len(link.Reserved))
+	}
+	return nil
+}
+
+func validateWireGuardKey(name, key string, required bool) error {
+	if key == "" {
+		if required {
+			return fmt.Errorf("missing %s", name)
+		}
+		return nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return fmt.Errorf("decode %s: %w", name, err)
+	}
+	if len(decoded) != 32 {
+		return fmt.Errorf("%s: expected 32 bytes, got %d", name, len(decoded))
+	}
+	return nil
+}
+
+func buildWireGuardOutbound(link Link) OutboundConfig {
+	return OutboundConfig{
+		Protocol: "wireguard",
+		Settings: WireGuardSettings{
+			SecretKey: link.PrivateKey,
+			Address:   link.LocalAddress,
+			Peers: []WireGuardPeer{{
+				PublicKey:    link.PeerPublicKey,
+				PreSharedKey: link.PresharedKey,
+				Endpoint:     net.JoinHostPort(link.Address, strconv.Itoa(link.Port)),
+				AllowedIPs:   link.AllowedIPs,
+			}},
+			MTU:      link.MTU,
+			Reserved: link.Reserved,
+		},
+		Tag: "proxy",
+	}
+}
+
+func formatWireGuard(link Link) string {
+	query := url.Values{}
+	setQuery(query, "publickey", link.PeerPublicKey)
+	setQuery(query, "presharedkey", link.PresharedKey)
+	setQuery(query, "address", strings.Join(link.LocalAddress, ","))
+	setQuery(query, "allowedips", strings.Join(link.AllowedIPs, ","))
+	setQuery(query, "dns", strings.Join(link.DNS, ","))
+	if link.MTU > 0 {
+		query.Set("mtu", strconv.Itoa(link.MTU))
+	}
+	if len(link.Reserved) > 0 {
+		parts := make([]string, len(link.Reserved))
+		for idx, value := range link.Reserved {
+			parts[idx] = strconv.Itoa(value)
+		}
+		query.Set("reserved", strings.Join(parts, ","))
+	}
+	return formatURL("wireguard", url.User(link.PrivateKey), link, query)
+}
@@ -0,0 +1,180 @@
+package vpn
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var (
+	testWGPrivateKey = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	testWGPublicKey  = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32))
+	testWGPSK        = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{3}, 32))
+)
+
+var testWireGuardConf = `# Exported by wg-quick
+[Interface]
+PrivateKey = ` + testWGPrivateKey + `
+Address = 10.0.0.2, fd00::2/128
+DNS = 1.1.1.1
+MTU = 1280
+PostUp = iptables -A FORWARD -i wg0 -j ACCEPT
+
+[Peer]
+PublicKey = ` + testWGPublicKey + `
+PresharedKey = ` + testWGPSK + `
+Endpoint = vpn.example.com:51820
+AllowedIPs = 0.0.0.0/0, ::/0
+Reserved = AQID
+
+[Peer]
+PublicKey = ` + testWGPublicKey + `
+Endpoint = [2001:db8::1]:51821
+
+[Peer]
+Endpoint = broken.example.com:51820
+`
+
+func TestParseWireGuardConf(t *testing.T) {
+	result, err := ParseWireGuardConf([]byte(testWireGuardConf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	iface := Link{
+		Protocol: "wireguard", PrivateKey: testWGPrivateKey,
+		LocalAddress: []string{"10.0.0.2/32", "fd00::2/128"}, DNS: []string{"1.1.1.1"}, MTU: 1280,
+	}
+	first := iface
+	first.Address, first.Port = "vpn.example.com", 51820
+	first.PeerPublicKey, first.PresharedKey = testWGPublicKey, testWGPSK
+	first.AllowedIPs = []string{"0.0.0.0/0", "::/0"}
+	first.Reserved = []int{1, 2, 3}
+	second := iface
+	second.Address, second.Port = "2001:db8::1", 51821
+	second.PeerPublicKey = testWGPublicKey
+
+	if want := []Link{first, second}; !reflect.DeepEqual(result.Links, want) {
+		t.Errorf("links = %+v\nwant %+v", result.Links, want)
+	}
+	if len(result.Errors) != 1 || !strings.Contains(result.Errors[0].Error(), "peer 3 (line 20): missing public key") {
+		t.Errorf("errors = %v", result.Errors)
+	}
+
+	text := ParseLinksFromText(testWireGuardConf)
+	if len(text.Links) != 2 || len(text.Errors) != 1 {
+		t.Errorf("ParseLinksFromText: %d links, errors %v", len(text.Links), text.Errors)
+	}
+}
+
+func TestParseWireGuardConfErrors(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		conf string
+		want string
+	}{
+		{"section", "[Interface]\n[Server]\n", `line 2: unknown section "[Server]"`},
+		{"key value", "[Interface]\nPrivateKey\n", "line 2: expected key = value"},
+		{"outside", "PrivateKey = abc\n[Interface]\n", "line 1: key outside of a section"},
+		{"address", "[Interface]\nAddress = 10.0.0.300\n", `line 2: invalid address "10.0.0.300"`},
+		{"mtu", "[Interface]\nMTU = big\n", `line 2: invalid mtu "big"`},
+		{"reserved", "[Interface]\nReserved = 1,2\n", `line 2: reserved "1,2": expected 3 bytes`},
+		{"allowed ips", "[Interface]\n[Peer]\nAllowedIPs = 0.0.0.0\n", `line 3: invalid allowed ip "0.0.0.0"`},
+		{"endpoint", "[Interface]\n[Peer]\nEndpoint = example.com:port\n", "line 3:"},
+		{"no peers", "[Interface]\nPrivateKey = " + testWGPrivateKey + "\n", "no peers in wireguard config"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseWireGuardConf([]byte(test.conf))
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got error %v, want %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestParseLinkWireGuard(t *testing.T) {
+	raw := "wireguard://" + url.QueryEscape(testWGPrivateKey) + "@203.0.113.7?publickey=" + url.QueryEscape(testWGPublicKey) +
+		"&address=10.0.0.2,fd00::2&reserved=1,2,3&mtu=1420&dns=1.1.1.1#WARP"
+	link, err := ParseLink(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Link{
+		Protocol: "wireguard", Name: "WARP", Address: "203.0.113.7", Port: 51820,
+		PrivateKey: testWGPrivateKey, PeerPublicKey: testWGPublicKey,
+		LocalAddress: []string{"10.0.0.2/32", "fd00::2/128"}, Reserved: []int{1, 2, 3}, MTU: 1420,
+		DNS: []string{"1.1.1.1"}, Raw: raw,
+	}
+	if !reflect.DeepEqual(link, want) {
+		t.Errorf("got %+v\nwant %+v", link, want)
+	}
+
+	key := url.QueryEscape(testWGPrivateKey)
+	pub := "&publickey=" + url.QueryEscape(testWGPublicKey)
+	for _, test := range []struct {
+		raw  string
+		want string
+	}{
+		{"wg://example.com:51820?address=10.0.0.2" + pub, "missing private key"},
+		{"wg://" + key + "@example.com:51820?address=10.0.0.2", "missing public key"},
+		{"wg://" + key + "@example.com:51820?x=1" + pub, "missing interface address"},
+		{"wg://abc@example.com:51820?address=10.0.0.2" + pub, "decode private key"},
+		{"wg://" + key + "@example.com:51820?address=10.0.0.2&presharedkey=" + b64("short") + pub, "preshared key: expected 32 bytes"},
+		{"wg://" + key + "@example.com:51820?address=10.0.0.2&reserved=1,2,256" + pub, `invalid byte "256"`},
+		{"wg://" + key + "@example.com:51820?address=10.0.0.2&mtu=70000" + pub, "invalid mtu 70000"},
+	} {
+		if _, err := ParseLink(test.raw); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("ParseLink(%s) = %v, want %q", test.raw, err, test.want)
+		}
+	}
+}
+
+func TestBuildXrayConfigWireGuard(t *testing.T) {
+	link := Link{
+		Protocol: "wireguard", Address: "2001:db8::1", Port: 51820,
+		PrivateKey: testWGPrivateKey, PeerPublicKey: testWGPublicKey, PresharedKey: testWGPSK,
+		LocalAddress: []string{"10.0.0.2/32"}, AllowedIPs: []string{"0.0.0.0/0"}, MTU: 1280, Reserved: []int{1, 2, 3},
+	}
+	config, err := BuildXrayConfig(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := WireGuardSettings{
+		SecretKey: testWGPrivateKey,
+		Address:   []string{"10.0.0.2/32"},
+		Peers: []WireGuardPeer{{
+			PublicKey: testWGPublicKey, PreSharedKey: testWGPSK,
+			Endpoint: "[2001:db8::1]:51820", AllowedIPs: []string{"0.0.0.0/0"},
+		}},
+		MTU:      1280,
+		Reserved: []int{1, 2, 3},
+	}
+	if outbound := config.Outbounds[0]; outbound.Protocol != "wireguard" || outbound.Tag != "proxy" || !reflect.DeepEqual(outbound.Settings, want) {
+		t.Errorf("outbound = %+v", outbound)
+	}
+
+	link.LocalAddress = nil
+	if _, err := BuildXrayConfig(link); err == nil || !strings.Contains(err.Error(), "missing interface address") {
+		t.Errorf("got error %v, want a missing address", err)
+	}
+}
+
+func TestParseXrayJSONWireGuard(t *testing.T) {
+	imported, err := ParseXrayJSON([]byte(`{"outbounds": [{"tag": "warp", "protocol": "wireguard", "settings": {
+		"secretKey": "` + testWGPrivateKey + `", "address": ["172.16.0.2/32"], "mtu": 1280, "reserved": [1, 2, 3],
+		"peers": [{"publicKey": "` + testWGPublicKey + `", "endpoint": "engage.cloudflareclient.com:2408"}]
+	}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Link{{
+		Protocol: "wireguard", Name: "warp", Address: "engage.cloudflareclient.com", Port: 2408,
+		PrivateKey: testWGPrivateKey, PeerPublicKey: testWGPublicKey,
+		LocalAddress: []string{"172.16.0.2/32"}, MTU: 1280, Reserved: []int{1, 2, 3},
+	}}
+	if !reflect.DeepEqual(imported.Links, want) || len(imported.Errors) != 0 {
+		t.Errorf("links = %+v, errors = %v\nwant %+v", imported.Links, imported.Errors, want)
+	}
+}
@@ -1,14 +1,40 @@
 package vpn
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"slices"
+	"strings"
 )
 
+// DefaultLocalPort is the local SOCKS port the generated configs listen on.
+const DefaultLocalPort = 10808
+
+var ErrUnsupportedPlugin = errors.New("unsupported shadowsocks plugin")
+
+var xrayShadowsocksMethods = map[string]bool{
+	"aes-128-gcm":                   true,
+	"aes-256-gcm":                   true,
+	"chacha20-poly1305":             true,
+	"chacha20-ietf-poly1305":        true,
+	"xchacha20-poly1305":            true,
+	"xchacha20-ietf-poly1305":       true,
+	"none":                          true,
+	"plain":                         true,
+	"2022-blake3-aes-128-gcm":       true,
+	"2022-blake3-aes-256-gcm":       true,
+	"2022-blake3-chacha20-poly1305": true,
+}
+
 type XrayConfig struct {
-	Log       LogConfig        `json:"log,omitempty"`
-	Inbounds  []InboundConfig  `json:"inbounds"`
-	Outbounds []OutboundConfig `json:"outbounds"`
-	Routing   *RoutingConfig   `json:"routing,omitempty"`
+	Log              LogConfig               `json:"log,omitempty"`
+	DNS              *DNSConfig              `json:"dns,omitempty"`
+	Inbounds         []InboundConfig         `json:"inbounds"`
+	Outbounds        []OutboundConfig        `json:"outbounds"`
+	Routing          *RoutingConfig          `json:"routing,omitempty"`
+	Observatory      *ObservatoryConfig      `json:"observatory,omitempty"`
+	BurstObservatory *BurstObservatoryConfig `json:"burstObservatory,omitempty"`
 }
 
 type LogConfig struct {
@@ -16,27 +42,58 @@ type LogConfig struct {
 }
 
 type InboundConfig struct {
-	Port     int               `json:"port"`
-	Listen   string            `json:"listen"`
-	Protocol string            `json:"protocol"`
-	Settings map[string]string `json:"settings,omitempty"`
+	Tag      string           `json:"tag,omitempty"`
+	Port     int              `json:"port"`
+	Listen   string           `json:"listen"`
+	Protocol string           `json:"protocol"`
+	Settings *InboundSettings `json:"settings,omitempty"`
+	Sniffing *SniffingConfig  `json:"sniffing,omitempty"`
+}
+
+type SniffingConfig struct {
+	Enabled      bool     `json:"enabled"`
+	DestOverride []string `json:"destOverride,omitempty"`
+}
+
+// UnmarshalJSON accepts hand-written inbounds, whose port may be a string.
+func (c *InboundConfig) UnmarshalJSON(data []byte) error {
+	type plain InboundConfig
+	var raw struct {
+		plain
+		Port json.RawMessage `json:"port"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	port, err := unmarshalInt(raw.Port)
+	if err != nil {
+		return fmt.Errorf("inbound port: %w", err)
+	}
+	*c = InboundConfig(raw.plain)
+	c.Port = port
+	return nil
 }
 
 type OutboundConfig struct {
-	Protocol       string                 `json:"protocol"`
-	Settings       map[string]interface{} `json:"settings"`
-	StreamSettings StreamSettings         `json:"streamSettings,omitempty"`
-	Tag            string                 `json:"tag,omitempty"`
+	Protocol       string           `json:"protocol"`
+	Settings       OutboundSettings `json:"settings,omitempty"`
+	StreamSettings StreamSettings   `json:"streamSettings,omitempty"`
+	Tag            string           `json:"tag,omitempty"`
 }
 
 type StreamSettings struct {
-	Network         string             `json:"network,omitempty"`
-	Security        string             `json:"security,omitempty"`
-	TLSSettings     *TLSSettings       `json:"tlsSettings,omitempty"`
-	RealitySettings *RealitySettings   `json:"realitySettings,omitempty"`
-	WSSettings      *WebSocketSettings `json:"wsSettings,omitempty"`
-	GRPCSettings    *GRPCSettings      `json:"grpcSettings,omitempty"`
-	HTTPSettings    *HTTPSettings      `json:"httpSettings,omitempty"`
+	Network         string               `json:"network,omitempty"`
+	Security        string               `json:"security,omitempty"`
+	TLSSettings     *TLSSettings         `json:"tlsSettings,omitempty"`
+	RealitySettings *RealitySettings     `json:"realitySettings,omitempty"`
+	TCPSettings     *TCPSettings         `json:"tcpSettings,omitempty"`
+	WSSettings      *WebSocketSettings   `json:"wsSettings,omitempty"`
+	GRPCSettings    *GRPCSettings        `json:"grpcSettings,omitempty"`
+	HTTPSettings    *HTTPSettings        `json:"httpSettings,omitempty"`
+	XHTTPSettings   *XHTTPSettings       `json:"xhttpSettings,omitempty"`
+	HTTPUpgrade     *HTTPUpgradeSettings `json:"httpupgradeSettings,omitempty"`
+	KCPSettings     *KCPSettings         `json:"kcpSettings,omitempty"`
+	QUICSettings    *QUICSettings        `json:"quicSettings,omitempty"`
 }
 
 type TLSSettings struct {
@@ -47,8 +104,12 @@ type TLSSettings struct {
 }
 
 type RealitySettings struct {
-	ServerName  string `json:"serverName,omitempty"`
-	Fingerprint string `json:"fingerprint,omitempty"`
+	ServerName    string `json:"serverName,omitempty"`
+	Fingerprint   string `json:"fingerprint,omitempty"`
+	PublicKey     string `json:"publicKey"`
+	ShortID       string `json:"shortId,omitempty"`
+	SpiderX       string `json:"spiderX,omitempty"`
+	MLDSA65Verify string `json:"mldsa65Verify,omitempty"`
 }
 
 type WebSocketSettings struct {
@@ -58,6 +119,63 @@ type WebSocketSettings struct {
 
 type GRPCSettings struct {
 	ServiceName string `json:"serviceName,omitempty"`
+	MultiMode   bool   `json:"multiMode,omitempty"`
+	Authority   string `json:"authority,omitempty"`
+}
+
+// TCPSettings carries the HTTP header obfuscation of raw TCP.
+type TCPSettings struct {
+	Header *TCPHeader `json:"header,omitempty"`
+}
+
+type TCPHeader struct {
+	Type    string            `json:"type"`
+	Request *TCPHeaderRequest `json:"request,omitempty"`
+}
+
+type TCPHeaderRequest struct {
+	Path    stringOrList            `json:"path,omitempty"`
+	Headers map[string]stringOrList `json:"headers,omitempty"`
+}
+
+type XHTTPSettings struct {
+	Path  string          `json:"path,omitempty"`
+	Host  string          `json:"host,omitempty"`
+	Mode  string          `json:"mode,omitempty"`
+	Extra json.RawMessage `json:"extra,omitempty"`
+}
+
+type HTTPUpgradeSettings struct {
+	Path string `json:"path,omitempty"`
+	Host string `json:"host,omitempty"`
+}
+
+type KCPSettings struct {
+	Header *HeaderSettings `json:"header,omitempty"`
+	Seed   string          `json:"seed,omitempty"`
+}
+
+type QUICSettings struct {
+	Security string          `json:"security,omitempty"`
+	Key      string          `json:"key,omitempty"`
+	Header   *HeaderSettings `json:"header,omitempty"`
+}
+
+// HeaderSettings selects the packet header disguise of mKCP and QUIC.
+type HeaderSettings struct {
+	Type string `json:"type"`
+}
+
+// stringOrList accepts a single string or a list of strings.
+type stringOrList []string
+
+func (l *stringOrList) UnmarshalJSON(data []byte) error {
+	list, err := unmarshalStringList(data)
+	if err != nil {
+		return err
+	}
+	*l = list
+	return nil
 }
 
 type HTTPSettings struct {
@@ -65,9 +183,30 @@ type HTTPSettings struct {
 	Host []string `json:"host,omitempty"`
 }
 
+// UnmarshalJSON accepts path and host as a single string or a list.
+func (s *HTTPSettings) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Path json.RawMessage `json:"path"`
+		Host json.RawMessage `json:"host"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	path, err := unmarshalStringList(raw.Path)
+	if err != nil {
+		return fmt.Errorf("http path: %w", err)
+	}
+	host, err := unmarshalStringList(raw.Host)
+	if err != nil {
+		return fmt.Errorf("http host: %w", err)
+	}
+	*s = HTTPSettings{Path: path, Host: host}
+	return nil
+}
+
 func BuildXrayConfig(link Link) (XrayConfig, error) {
-	if link.Address == "" || link.Port == 0 || link.UUID == "" {
-		return XrayConfig{}, errors.New("missing required link fields")
+	if err := validateLink(link); err != nil {
+		return XrayConfig{}, err
 	}
 
 	outbound, err := buildOutbound(link)
@@ -79,20 +218,15 @@ func BuildXrayConfig(link Link) (XrayConfig, error) {
 		Log: LogConfig{
 			LogLevel: "warning",
 		},
-		Inbounds: []InboundConfig{
-			{
-				Port:     10808,
-				Listen:   "127.0.0.1",
-				Protocol: "socks",
-				Settings: map[string]string{
-					"udp": "true",
-				},
-			},
-		},
+		Inbounds: XrayInbounds(InboundOptions{UDP: true}),
 		Outbounds: []OutboundConfig{outbound, {
 			Protocol: "freedom",
-			Tag:      "direct",
-			Settings: map[string]interface{}{},
+			Tag:      OutboundDirect,
+			Settings: FreedomSettings{},
+		}, {
+			Protocol: "blackhole",
+			Tag:      OutboundBlock,
+			Settings: RawSettings(nil),
 		}},
 		Routing: DefaultRouting(),
 	}
@@ -102,70 +236,207 @@ func BuildXrayConfig(link Link) (XrayConfig, error) {
 	return config, nil
 }
 
+func validateLink(link Link) error {
+	if link.Address == "" || link.Port == 0 {
+		return errors.New("missing required link fields")
+	}
+	if err := validateTransportParams(link); err != nil {
+		return err
+	}
+	if normalizeSecurity(link.Security) == "reality" {
+		if link.PublicKey == "" {
+			return fmt.Errorf("reality server %s:%d is missing its public key (pbk)", link.Address, link.Port)
+		}
+		if err := validateRealityParams(link); err != nil {
+			return err
+		}
+	}
+	switch link.Protocol {
+	case "vless":
+		if link.UUID == "" {
+			return errors.New("missing uuid")
+		}
+	case "vmess":
+		if link.UUID == "" {
+			return errors.New("missing uuid")
+		}
+		if !slices.Contains(vmessCiphers, link.Cipher) {
+			return fmt.Errorf("unsupported vmess cipher %q", link.Cipher)
+		}
+		if link.AlterID < 0 || link.AlterID > 65535 {
+			return fmt.Errorf("invalid vmess alterId %d", link.AlterID)
+		}
+	case "trojan":
+		if link.Password == "" {
+			return errors.New("missing password")
+		}
+	case "shadowsocks":
+		if link.Password == "" {
+			return errors.New("missing password")
+		}
+		if !xrayShadowsocksMethods[link.Method] {
+			return fmt.Errorf("unsupported shadowsocks method %q", link.Method)
+		}
+	case "hysteria2":
+		switch link.Obfs {
+		case "":
+		case "salamander":
+			if link.ObfsPassword == "" {
+				return errors.New("missing obfs password")
+			}
+		default:
+			return fmt.Errorf("unsupported hysteria2 obfs %q", link.Obfs)
+		}
+	case "wireguard":
+		return validateWireGuard(link)
+	case "tuic":
+		if link.UUID == "" || link.Password == "" {
+			return errors.New("missing uuid or password")
+		}
+		switch link.CongestionControl {
+		case "", "cubic", "new_reno", "bbr":
+		default:
+			return fmt.Errorf("unsupported tuic congestion control %q", link.CongestionControl)
+		}
+		switch link.UDPRelayMode {
+		case "", "native", "quic":
+		default:
+			return fmt.Errorf("unsupported tuic udp relay mode %q", link.UDPRelayMode)
+		}
+	}
+	return nil
+}
+
 func buildOutbound(link Link) (OutboundConfig, error) {
 	switch link.Protocol {
 	case "vless":
 		return buildVLESSOutbound(link), nil
 	case "vmess":
 		return buildVMessOutbound(link), nil
+	case "trojan":
+		return buildTrojanOutbound(link), nil
+	case "shadowsocks":
+		return buildShadowsocksOutbound(link)
+	case "wireguard":
+		return buildWireGuardOutbound(link), nil
+	case "hysteria2", "tuic":
+		return OutboundConfig{}, fmt.Errorf("%w: %s is not supported by xray, use the sing-box core", ErrUnsupportedProtocol, link.Protocol)
 	default:
 		return OutboundConfig{}, ErrUnsupportedProtocol
 	}
 }
 
 func buildVLESSOutbound(link Link) OutboundConfig {
-	settings := map[string]interface{}{
-		"vnext": []map[string]interface{}{
-			{
-				"address": link.Address,
-				"port":    link.Port,
-				"users": []map[string]interface{}{
-					{
-						"id":         link.UUID,
-						"encryption": firstNonEmpty(link.Encryption, "none"),
-						"flow":       link.Flow,
-					},
-				},
-			},
+	return OutboundConfig{
+		Protocol: "vless",
+		Settings: VNextSettings{
+			VNext: []VNextServer{{
+				Address: link.Address,
+				Port:    link.Port,
+				Users: []VNextUser{{
+					ID:         link.UUID,
+					Encryption: firstNonEmpty(link.Encryption, "none"),
+					Flow:       link.Flow,
+				}},
+			}},
 		},
+		StreamSettings: buildStreamSettings(link),
+		Tag:            "proxy",
 	}
+}
 
+func buildVMessOutbound(link Link) OutboundConfig {
 	return OutboundConfig{
-		Protocol:       "vless",
-		Settings:       settings,
+		Protocol: "vmess",
+		Settings: VNextSettings{
+			VNext: []VNextServer{{
+				Address: link.Address,
+				Port:    link.Port,
+				Users: []VNextUser{{
+					ID:       link.UUID,
+					AlterID:  link.AlterID,
+					Security: firstNonEmpty(link.Cipher, "auto"),
+				}},
+			}},
+		},
 		StreamSettings: buildStreamSettings(link),
 		Tag:            "proxy",
 	}
 }
 
-func buildVMessOutbound(link Link) OutboundConfig {
-	settings := map[string]interface{}{
-		"vnext": []map[string]interface{}{
-			{
-				"address": link.Address,
-				"port":    link.Port,
-				"users": []map[string]interface{}{
-					{
-						"id":       link.UUID,
-						"alterId":  link.Encryption,
-						"security": "auto",
-					},
-				},
-			},
+func buildTrojanOutbound(link Link) OutboundConfig {
+	return OutboundConfig{
+		Protocol: "trojan",
+		Settings: ServersSettings{
+			Servers: []OutboundServer{{
+				Address:  link.Address,
+				Port:     link.Port,
+				Password: link.Password,
+				Flow:     link.Flow,
+			}},
 		},
+		StreamSettings: buildStreamSettings(link),
+		Tag:            "proxy",
+	}
+}
+
+func buildShadowsocksOutbound(link Link) (OutboundConfig, error) {
+	streamLink, err := applyShadowsocksPlugin(link)
+	if err != nil {
+		return OutboundConfig{}, err
 	}
 
 	return OutboundConfig{
-		Protocol:       "vmess",
-		Settings:       settings,
-		StreamSettings: buildStreamSettings(link),
+		Protocol: "shadowsocks",
+		Settings: ServersSettings{
+			Servers: []OutboundServer{{
+				Address:  link.Address,
+				Port:     link.Port,
+				Method:   link.Method,
+				Password: link.Password,
+			}},
+		},
+		StreamSettings: buildStreamSettings(streamLink),
 		Tag:            "proxy",
+	}, nil
+}
+
+// applyShadowsocksPlugin maps SIP003 plugins onto Xray transports. Only
+// v2ray-plugin in websocket mode has an Xray equivalent; everything else
+// needs an external plugin binary that Xray cannot run.
+func applyShadowsocksPlugin(link Link) (Link, error) {
+	switch link.Plugin {
+	case "":
+		return link, nil
+	case "v2ray-plugin", "xray-plugin":
+	default:
+		return Link{}, fmt.Errorf("%w: %q cannot be run by xray", ErrUnsupportedPlugin, link.Plugin)
 	}
+
+	opts := map[string]string{}
+	for _, opt := range strings.Split(link.PluginOpts, ";") {
+		key, value, _ := strings.Cut(strings.TrimSpace(opt), "=")
+		if key != "" {
+			opts[key] = value
+		}
+	}
+	if mode := firstNonEmpty(opts["mode"], "websocket"); mode != "websocket" {
+		return Link{}, fmt.Errorf("%w: %s mode %q cannot be run by xray", ErrUnsupportedPlugin, link.Plugin, mode)
+	}
+
+	link.Transport = "ws"
+	link.Host = opts["host"]
+	link.Path = firstNonEmpty(opts["path"], "/")
+	if _, ok := opts["tls"]; ok {
+		link.Security = "tls"
+		link.SNI = link.Host
+	}
+	return link, nil
 }
 
 func buildStreamSettings(link Link) StreamSettings {
 	settings := StreamSettings{
-		Network: firstNonEmpty(link.Transport, "tcp"),
+		Network: normalizeTransport(link.Transport),
 	}
 
 	security := normalizeSecurity(link.Security)
@@ -174,6 +445,14 @@ func buildStreamSettings(link Link) StreamSettings {
 	}
 
 	switch settings.Network {
+	case "tcp":
+		if link.HeaderType == "http" {
+			request := &TCPHeaderRequest{Path: splitCSV(firstNonEmpty(link.Path, "/"))}
+			if hosts := splitCSV(link.Host); len(hosts) > 0 {
+				request.Headers = map[string]stringOrList{"Host": hosts}
+			}
+			settings.TCPSettings = &TCPSettings{Header: &TCPHeader{Type: "http", Request: request}}
+		}
 	case "ws":
 		wsHeaders := map[string]string{}
 		if link.Host != "" {
@@ -186,12 +465,39 @@ func buildStreamSettings(link Link) StreamSettings {
 	case "grpc":
 		settings.GRPCSettings = &GRPCSettings{
 			ServiceName: link.ServiceName,
+			MultiMode:   link.Mode == "multi",
+			Authority:   link.Authority,
 		}
 	case "h2", "http", "http2":
 		settings.HTTPSettings = &HTTPSettings{
 			Path: splitCSV(link.Path),
 			Host: splitCSV(link.Host),
 		}
+	case "xhttp":
+		settings.XHTTPSettings = &XHTTPSettings{
+			Path: link.Path,
+			Host: link.Host,
+			Mode: link.Mode,
+		}
+		if link.Extra != "" {
+			settings.XHTTPSettings.Extra = json.RawMessage(link.Extra)
+		}
+	case "httpupgrade":
+		settings.HTTPUpgrade = &HTTPUpgradeSettings{
+			Path: link.Path,
+			Host: link.Host,
+		}
+	case "kcp":
+		settings.KCPSettings = &KCPSettings{
+			Header: headerSettings(link.HeaderType),
+			Seed:   link.Seed,
+		}
+	case "quic":
+		settings.QUICSettings = &QUICSettings{
+			Security: link.QUICSecurity,
+			Key:      link.QUICKey,
+			Header:   headerSettings(link.HeaderType),
+		}
 	}
 
 	if security == "tls" {
@@ -199,14 +505,85 @@ func buildStreamSettings(link Link) StreamSettings {
 	}
 	if security == "reality" {
 		settings.RealitySettings = &RealitySettings{
-			ServerName:  link.SNI,
-			Fingerprint: link.Fingerprint,
+			ServerName:    link.SNI,
+			Fingerprint:   link.Fingerprint,
+			PublicKey:     link.PublicKey,
+			ShortID:       link.ShortID,
+			SpiderX:       link.SpiderX,
+			MLDSA65Verify: link.MLDSA65Verify,
 		}
 	}
 
 	return settings
 }
 
+func headerSettings(headerType string) *HeaderSettings {
+	if headerType == "" || headerType == "none" {
+		return nil
+	}
+	return &HeaderSettings{Type: headerType}
+}
+
+// normalizeTransport maps transport aliases onto the Xray network names.
+func normalizeTransport(transport string) string {
+	switch transport {
+	case "", "raw":
+		return "tcp"
+	case "splithttp":
+		return "xhttp"
+	case "mkcp":
+		return "kcp"
+	default:
+		return transport
+	}
+}
+
+var vmessCiphers = []string{"", "auto", "aes-128-gcm", "chacha20-poly1305", "none", "zero"}
+
+var (
+	xhttpModes        = []string{"", "auto", "packet-up", "stream-up", "stream-one"}
+	grpcModes         = []string{"", "gun", "multi"}
+	tcpHeaderTypes    = []string{"", "none", "http"}
+	packetHeaderTypes = []string{"", "none", "srtp", "utp", "wechat-video", "dtls", "wireguard"}
+	quicSecurities    = []string{"", "none", "aes-128-gcm", "chacha20-poly1305"}
+)
+
+// validateTransportParams checks the transport options that Xray would
+// otherwise reject at startup.
+func validateTransportParams(link Link) error {
+	switch transport := normalizeTransport(link.Transport); transport {
+	case "tcp":
+		if !slices.Contains(tcpHeaderTypes, link.HeaderType) {
+			return fmt.Errorf("unsupported tcp header type %q", link.HeaderType)
+		}
+	case "ws", "h2", "http", "http2", "httpupgrade":
+	case "grpc":
+		if !slices.Contains(grpcModes, link.Mode) {
+			return fmt.Errorf("unsupported grpc mode %q", link.Mode)
+		}
+	case "xhttp":
+		if !slices.Contains(xhttpModes, link.Mode) {
+			return fmt.Errorf("unsupported xhttp mode %q", link.Mode)
+		}
+		if link.Extra != "" {
+			var extra map[string]json.RawMessage
+			if err := json.Unmarshal([]byte(link.Extra), &extra); err != nil {
+				return fmt.Errorf("xhttp extra: expected a json object: %w", err)
+			}
+		}
+	case "kcp", "quic":
+		if !slices.Contains(packetHeaderTypes, link.HeaderType) {
+			return fmt.Errorf("unsupported %s header type %q", transport, link.HeaderType)
+		}
+		if transport == "quic" && !slices.Contains(quicSecurities, link.QUICSecurity) {
+			return fmt.Errorf("unsupported quic security %q", link.QUICSecurity)
+		}
+	default:
+		return fmt.Errorf("unsupported transport %q", link.Transport)
+	}
+	return nil
+}
+
 func buildTLSSettings(link Link) *TLSSettings {
 	if link.SNI == "" && len(link.ALPN) == 0 && link.Fingerprint == "" && !link.AllowInsecure {
 		return nil
@@ -229,3 +606,21 @@ func normalizeSecurity(value string) string {
 		return ""
 	}
 }
+
+func unmarshalStringList(data json.RawMessage) ([]string, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return nil, nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err == nil {
+		return list, nil
+	}
+	var single string
+	if err := json.Unmarshal(data, &single); err != nil {
+		return nil, err
+	}
+	if single == "" {
+		return nil, nil
+	}
+	return []string{single}, nil
+}
@@ -0,0 +1,116 @@
+package vpn
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuildStreamSettingsTransports(t *testing.T) {
+	tests := []struct {
+		name string
+		link Link
+		want StreamSettings
+	}{
+		{
+			name: "raw http header",
+			link: Link{Transport: "raw", HeaderType: "http", Host: "a.example.com,b.example.com"},
+			want: StreamSettings{Network: "tcp", TCPSettings: &TCPSettings{Header: &TCPHeader{
+				Type:    "http",
+				Request: &TCPHeaderRequest{Path: []string{"/"}, Headers: map[string]stringOrList{"Host": {"a.example.com", "b.example.com"}}},
+			}}},
+		},
+		{
+			name: "grpc multi",
+			link: Link{Transport: "grpc", ServiceName: "svc", Mode: "multi", Authority: "grpc.example.com"},
+			want: StreamSettings{Network: "grpc", GRPCSettings: &GRPCSettings{ServiceName: "svc", MultiMode: true, Authority: "grpc.example.com"}},
+		},
+		{
+			name: "splithttp",
+			link: Link{Transport: "splithttp", Path: "/x", Host: "cdn.example.com", Mode: "packet-up", Extra: `{"xPaddingBytes":"100-1000"}`},
+			want: StreamSettings{Network: "xhttp", XHTTPSettings: &XHTTPSettings{
+				Path: "/x", Host: "cdn.example.com", Mode: "packet-up", Extra: json.RawMessage(`{"xPaddingBytes":"100-1000"}`),
+			}},
+		},
+		{
+			name: "httpupgrade",
+			link: Link{Transport: "httpupgrade", Path: "/up", Host: "cdn.example.com"},
+			want: StreamSettings{Network: "httpupgrade", HTTPUpgrade: &HTTPUpgradeSettings{Path: "/up", Host: "cdn.example.com"}},
+		},
+		{
+			name: "mkcp",
+			link: Link{Transport: "mkcp", HeaderType: "wechat-video", Seed: "seed"},
+			want: StreamSettings{Network: "kcp", KCPSettings: &KCPSettings{Header: &HeaderSettings{Type: "wechat-video"}, Seed: "seed"}},
+		},
+		{
+			name: "quic",
+			link: Link{Transport: "quic", HeaderType: "none", QUICSecurity: "aes-128-gcm", QUICKey: "key"},
+			want: StreamSettings{Network: "quic", QUICSettings: &QUICSettings{Security: "aes-128-gcm", Key: "key"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := buildStreamSettings(tt.link); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("stream settings = %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateTransportParams(t *testing.T) {
+	base := "vless://" + testUUID + "@example.com:443?"
+	for _, test := range []struct {
+		query string
+		want  string
+	}{
+		{"type=tcp&headerType=srtp", `unsupported tcp header type "srtp"`},
+		{"type=grpc&mode=stream", `unsupported grpc mode "stream"`},
+		{"type=xhttp&mode=multi", `unsupported xhttp mode "multi"`},
+		{"type=xhttp&extra=%5B1%5D", "xhttp extra: expected a json object"},
+		{"type=kcp&headerType=http", `unsupported kcp header type "http"`},
+		{"type=quic&quicSecurity=aes-256-gcm", `unsupported quic security "aes-256-gcm"`},
+		{"type=meek", `unsupported transport "meek"`},
+	} {
+		if _, err := ParseLink(base + test.query); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("ParseLink(%s) = %v, want %q", test.query, err, test.want)
+		}
+	}
+
+	link := Link{Protocol: "trojan", Address: "example.com", Port: 443, Password: "secret", Transport: "grpc", Mode: "stream"}
+	if _, err := BuildXrayConfig(link); err == nil || !strings.Contains(err.Error(), "unsupported grpc mode") {
+		t.Errorf("BuildXrayConfig = %v, want an unsupported grpc mode", err)
+	}
+}
+
+func TestParseXrayJSONTransports(t *testing.T) {
+	imported, err := ParseXrayJSON([]byte(`{"outbounds": [
+		{"tag": "tcp-http", "protocol": "trojan", "settings": {"servers": [{"address": "a.example.com", "port": 443, "password": "secret"}]},
+		 "streamSettings": {"network": "raw", "tcpSettings": {"header": {"type": "http", "request": {"path": "/", "headers": {"host": ["a.example.com"]}}}}}},
+		{"tag": "xhttp", "protocol": "trojan", "settings": {"servers": [{"address": "b.example.com", "port": 443, "password": "secret"}]},
+		 "streamSettings": {"network": "splithttp", "xhttpSettings": {"path": "/x", "mode": "stream-one", "extra": {"noGRPCHeader": true}}}},
+		{"tag": "kcp", "protocol": "trojan", "settings": {"servers": [{"address": "c.example.com", "port": 443, "password": "secret"}]},
+		 "streamSettings": {"network": "kcp", "kcpSettings": {"seed": "seed", "header": {"type": "dtls"}}}},
+		{"tag": "grpc", "protocol": "trojan", "settings": {"servers": [{"address": "d.example.com", "port": 443, "password": "secret"}]},
+		 "streamSettings": {"network": "grpc", "grpcSettings": {"serviceName": "svc", "multiMode": true, "authority": "d.example.com"}}}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imported.Errors) != 0 || len(imported.Links) != 4 {
+		t.Fatalf("links = %+v, errors = %v", imported.Links, imported.Errors)
+	}
+	var got []string
+	for _, link := range imported.Links {
+		got = append(got, strings.Join([]string{link.Transport, link.HeaderType, link.Path, link.Host, link.Mode, link.Extra, link.Seed, link.Authority}, "|"))
+	}
+	want := []string{
+		"tcp|http|/|a.example.com||||",
+		`xhttp||/x||stream-one|{"noGRPCHeader": true}||`,
+		"kcp|dtls|||||seed|",
+		"grpc||||multi|||d.example.com",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("transports = %q\nwant %q", got, want)
+	}
+}
@@ -0,0 +1,241 @@
+package vpn
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type XrayImport struct {
+	ImportResult
+	// Routing is the routing section of the first config that has one, for
+	// callers that want to keep the user's rules.
+	Routing *RoutingConfig
+}
+
+// xrayImportConfig keeps the outbounds raw so that one malformed outbound
+// is reported without dropping the others.
+type xrayImportConfig struct {
+	Remarks   string            `json:"remarks"`
+	Outbounds []json.RawMessage `json:"outbounds"`
+	Routing   *RoutingConfig    `json:"routing"`
+}
+
+func isXrayJSON(text string) bool {
+	return json.Valid([]byte(text)) && (text[0] == '{' || text[0] == '[')
+}
+
+// ParseXrayJSON extracts the vless, vmess, trojan, shadowsocks and wireguard
+// outbounds of an Xray or V2Ray JSON document, either a single config or an
+// array of configs as served by some subscriptions.
+func ParseXrayJSON(data []byte) (XrayImport, error) {
+	data = bytes.TrimSpace(data)
+	var configs []xrayImportConfig
+	if len(data) > 0 && data[0] == '[' {
+		if err := json.Unmarshal(data, &configs); err != nil {
+			return XrayImport{}, fmt.Errorf("unmarshal xray configs: %w", err)
+		}
+	} else {
+		var config xrayImportConfig
+		if err := json.Unmarshal(data, &config); err != nil {
+			return XrayImport{}, fmt.Errorf("unmarshal xray config: %w", err)
+		}
+		configs = append(configs, config)
+	}
+
+	result := XrayImport{ImportResult: ImportResult{Links: []Link{}, Errors: []error{}}}
+	for configIdx, config := range configs {
+		if result.Routing == nil {
+			result.Routing = config.Routing
+		}
+		for outboundIdx, raw := range config.Outbounds {
+			var header struct {
+				Tag      string `json:"tag"`
+				Protocol string `json:"protocol"`
+			}
+			_ = json.Unmarshal(raw, &header)
+			switch header.Protocol {
+			case "vless", "vmess", "trojan", "shadowsocks", "wireguard":
+			default:
+				continue
+			}
+			label := fmt.Sprintf("config %d outbound %d", configIdx+1, outboundIdx+1)
+			if header.Tag != "" {
+				label = fmt.Sprintf("config %d outbound %q", configIdx+1, header.Tag)
+			}
+
+			var outbound OutboundConfig
+			if err := json.Unmarshal(raw, &outbound); err != nil {
+				result.Errors = append(result.Errors, fmt.Errorf("%s: %w", label, err))
+				continue
+			}
+			links, err := outboundLinks(outbound)
+			if err != nil {
+				result.Errors = append(result.Errors, fmt.Errorf("%s: %w", label, err))
+				continue
+			}
+			name := firstNonEmpty(config.Remarks, outbound.Tag)
+			for idx, link := range links {
+				link.Name = name
+				if name != "" && len(links) > 1 {
+					// Keep the servers of one outbound apart in the store.
+					link.Name += " #" + strconv.Itoa(idx+1)
+				}
+				result.Links = append(result.Links, link)
+			}
+		}
+	}
+	if len(result.Links) == 0 && len(result.Errors) == 0 {
+		return XrayImport{}, errors.New("no proxy outbounds in xray config")
+	}
+	return result, nil
+}
+
+// outboundLinks returns one link per server of the outbound.
+func outboundLinks(outbound OutboundConfig) ([]Link, error) {
+	base := streamLink(outbound.StreamSettings)
+	base.Protocol = outbound.Protocol
+
+	var links []Link
+	switch settings := outbound.Settings.(type) {
+	case VNextSettings:
+		for _, server := range settings.VNext {
+			for _, user := range server.Users {
+				link := base
+				link.Address = server.Address
+				link.Port = server.Port
+				link.UUID = user.ID
+				link.Flow = user.Flow
+				if outbound.Protocol == "vless" {
+					link.Encryption = user.Encryption
+				} else {
+					link.AlterID = user.AlterID
+					link.Cipher = user.Security
+				}
+				links = append(links, link)
+			}
+		}
+	case ServersSettings:
+		for _, server := range settings.Servers {
+			link := base
+			link.Address = server.Address
+			link.Port = server.Port
+			link.Password = server.Password
+			link.Flow = server.Flow
+			if outbound.Protocol == "shadowsocks" {
+				link.Method = server.Method
+				link.Transport = "tcp"
+			}
+			links = append(links, link)
+		}
+	case WireGuardSettings:
+		addresses, err := parseWireGuardAddresses(strings.Join(settings.Address, ","))
+		if err != nil {
+			return nil, err
+		}
+		for _, peer := range settings.Peers {
+			host, port, err := splitHostPort(peer.Endpoint, 0)
+			if err != nil {
+				return nil, fmt.Errorf("peer endpoint: %w", err)
+			}
+			links = append(links, Link{
+				Protocol:      "wireguard",
+				Address:       host,
+				Port:          port,
+				PrivateKey:    settings.SecretKey,
+				PeerPublicKey: peer.PublicKey,
+				PresharedKey:  peer.PreSharedKey,
+				LocalAddress:  addresses,
+				AllowedIPs:    peer.AllowedIPs,
+				Reserved:      settings.Reserved,
+				MTU:           settings.MTU,
+			})
+		}
+	default:
+		return nil, errors.New("missing settings")
+	}
+
+	for _, link := range links {
+		if err := validateLink(link); err != nil {
+			return nil, err
+		}
+	}
+	return links, nil
+}
+
+func streamLink(stream StreamSettings) Link {
+	link := Link{
+		Transport: normalizeTransport(stream.Network),
+		Security:  stream.Security,
+	}
+	if link.Security == "none" {
+		link.Security = ""
+	}
+	if stream.TLSSettings != nil {
+		link.SNI = stream.TLSSettings.ServerName
+		link.AllowInsecure = stream.TLSSettings.AllowInsecure
+		link.ALPN = stream.TLSSettings.ALPN
+		link.Fingerprint = stream.TLSSettings.Fingerprint
+	}
+	if reality := stream.RealitySettings; reality != nil {
+		link.SNI = reality.ServerName
+		link.Fingerprint = reality.Fingerprint
+		link.PublicKey = reality.PublicKey
+		link.ShortID = reality.ShortID
+		link.SpiderX = reality.SpiderX
+		link.MLDSA65Verify = reality.MLDSA65Verify
+	}
+	if ws := stream.WSSettings; ws != nil {
+		link.Path = ws.Path
+		link.Host = headerValue(ws.Headers, "Host")
+	}
+	if tcp := stream.TCPSettings; tcp != nil && tcp.Header != nil {
+		link.HeaderType = tcp.Header.Type
+		if request := tcp.Header.Request; request != nil {
+			link.Path = strings.Join(request.Path, ",")
+			for key, values := range request.Headers {
+				if strings.EqualFold(key, "Host") {
+					link.Host = strings.Join(values, ",")
+				}
+			}
+		}
+	}
+	if grpc := stream.GRPCSettings; grpc != nil {
+		link.ServiceName = grpc.ServiceName
+		link.Authority = grpc.Authority
+		if grpc.MultiMode {
+			link.Mode = "multi"
+		}
+	}
+	if xhttp := stream.XHTTPSettings; xhttp != nil {
+		link.Path = xhttp.Path
+		link.Host = xhttp.Host
+		link.Mode = xhttp.Mode
+		link.Extra = string(xhttp.Extra)
+	}
+	if upgrade := stream.HTTPUpgrade; upgrade != nil {
+		link.Path = upgrade.Path
+		link.Host = upgrade.Host
+	}
+	if kcp := stream.KCPSettings; kcp != nil {
+		link.Seed = kcp.Seed
+		if kcp.Header != nil {
+			link.HeaderType = kcp.Header.Type
+		}
+	}
+	if quic := stream.QUICSettings; quic != nil {
+		link.QUICSecurity = quic.Security
+		link.QUICKey = quic.Key
+		if quic.Header != nil {
+			link.HeaderType = quic.Header.Type
+		}
+	}
+	if http := stream.HTTPSettings; http != nil {
+		link.Host = strings.Join(http.Host, ",")
+		link.Path = strings.Join(http.Path, ",")
+	}
+	return link
+}
@@ -0,0 +1,249 @@
+package vpn
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testXrayConfig = `{
+  "remarks": "My config",
+  "inbounds": [{"port": 10808, "protocol": "socks"}],
+  "outbounds": [
+    {
+      "tag": "vless-reality",
+      "protocol": "vless",
+      "settings": {"vnext": [{"address": "vless.example.com", "port": 443, "users": [
+        {"id": "` + testUUID + `", "encryption": "none", "flow": "xtls-rprx-vision"}
+      ]}]},
+      "streamSettings": {"network": "tcp", "security": "reality", "realitySettings": {
+        "serverName": "www.microsoft.com", "fingerprint": "chrome",
+        "publicKey": "` + testRealityKey + `", "shortId": "6ba85179e30d4fc2", "spiderX": "/"
+      }}
+    },
+    {
+      "tag": "vmess-ws",
+      "protocol": "vmess",
+      "settings": {"vnext": [{"address": "vmess.example.com", "port": "8443", "users": [
+        {"id": "` + testUUID + `", "alterId": 4, "security": "aes-128-gcm"}
+      ]}]},
+      "streamSettings": {"network": "ws", "security": "tls",
+        "tlsSettings": {"serverName": "vmess.example.com", "allowInsecure": true, "alpn": ["h2"]},
+        "wsSettings": {"path": "/ws", "headers": {"host": "cdn.example.com"}}}
+    },
+    {
+      "tag": "trojan-grpc",
+      "protocol": "trojan",
+      "settings": {"servers": [{"address": "trojan.example.com", "port": 443, "password": "secret"}]},
+      "streamSettings": {"network": "grpc", "security": "tls", "grpcSettings": {"serviceName": "svc"}}
+    },
+    {
+      "tag": "ss",
+      "protocol": "shadowsocks",
+      "settings": {"servers": [{"address": "ss.example.com", "port": 8388, "method": "aes-128-gcm", "password": "secret"}]},
+      "streamSettings": {"security": "none"}
+    },
+    {"tag": "direct", "protocol": "freedom", "settings": {}},
+    {"tag": "block", "protocol": "blackhole", "settings": {}}
+  ],
+  "routing": {"domainStrategy": "IPIfNonMatch", "rules": [
+    {"type": "field", "domain": ["geosite:cn"], "outboundTag": "direct"}
+  ]}
+}`
+
+func TestParseXrayJSON(t *testing.T) {
+	imported, err := ParseXrayJSON([]byte(testXrayConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imported.Errors) != 0 {
+		t.Fatalf("errors = %v", imported.Errors)
+	}
+	want := []Link{
+		{
+			Protocol: "vless", Name: "My config", Address: "vless.example.com", Port: 443,
+			UUID: testUUID, Encryption: "none", Flow: "xtls-rprx-vision", Transport: "tcp",
+			Security: "reality", SNI: "www.microsoft.com", Fingerprint: "chrome",
+			PublicKey: testRealityKey, ShortID: "6ba85179e30d4fc2", SpiderX: "/",
+		},
+		{
+			Protocol: "vmess", Name: "My config", Address: "vmess.example.com", Port: 8443,
+			UUID: testUUID, AlterID: 4, Cipher: "aes-128-gcm", Transport: "ws", Security: "tls",
+			SNI: "vmess.example.com", AllowInsecure: true, ALPN: []string{"h2"},
+			Path: "/ws", Host: "cdn.example.com",
+		},
+		{
+			Protocol: "trojan", Name: "My config", Address: "trojan.example.com", Port: 443,
+			Password: "secret", Transport: "grpc", Security: "tls", ServiceName: "svc",
+		},
+		{
+			Protocol: "shadowsocks", Name: "My config", Address: "ss.example.com", Port: 8388,
+			Method: "aes-128-gcm", Password: "secret", Transport: "tcp",
+		},
+	}
+	if !reflect.DeepEqual(imported.Links, want) {
+		t.Errorf("links = %+v\nwant %+v", imported.Links, want)
+	}
+
+	wantRouting := &RoutingConfig{
+		DomainStrategy: "IPIfNonMatch",
+		Rules:          []RoutingRule{{Type: "field", Domain: []string{"geosite:cn"}, Outbound: "direct"}},
+	}
+	if !reflect.DeepEqual(imported.Routing, wantRouting) {
+		t.Errorf("routing = %+v, want %+v", imported.Routing, wantRouting)
+	}
+}
+
+func TestParseXrayJSONMultipleServers(t *testing.T) {
+	imported, err := ParseXrayJSON([]byte(`{"outbounds": [{
+		"tag": "pool",
+		"protocol": "vless",
+		"settings": {"vnext": [
+			{"address": "a.example.com", "port": 443, "users": [{"id": "` + testUUID + `"}, {"id": "11111111-2222-3333-4444-555555555555"}]},
+			{"address": "b.example.com", "port": 8443, "users": [{"id": "` + testUUID + `"}]}
+		]}
+	}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, link := range imported.Links {
+		got = append(got, link.Name+" "+link.Address+" "+link.UUID)
+	}
+	want := []string{
+		"pool #1 a.example.com " + testUUID,
+		"pool #2 a.example.com 11111111-2222-3333-4444-555555555555",
+		"pool #3 b.example.com " + testUUID,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("links = %v, want %v", got, want)
+	}
+}
+
+func TestParseXrayJSONArray(t *testing.T) {
+	imported, err := ParseXrayJSON([]byte(`[
+		{"remarks": "First", "outbounds": [{"protocol": "trojan", "settings": {"servers": [{"address": "a.example.com", "port": 443, "password": "secret"}]}}]},
+		{"remarks": "Second", "outbounds": [{"protocol": "trojan", "settings": {"address": "b.example.com", "port": 443, "password": "secret"}}],
+		 "routing": {"rules": [{"type": "field", "ip": ["geoip:private"], "outboundTag": "direct"}]}}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imported.Links) != 2 || imported.Links[0].Name != "First" || imported.Links[1].Name != "Second" ||
+		imported.Links[1].Address != "b.example.com" {
+		t.Errorf("links = %+v", imported.Links)
+	}
+	if imported.Routing == nil || len(imported.Routing.Rules) != 1 || imported.Routing.Rules[0].IP[0] != "geoip:private" {
+		t.Errorf("routing = %+v", imported.Routing)
+	}
+}
+
+func TestParseXrayJSONErrors(t *testing.T) {
+	imported, err := ParseXrayJSON([]byte(`{"outbounds": [
+		{"tag": "no-uuid", "protocol": "vless", "settings": {"vnext": [{"address": "example.com", "port": 443, "users": [{}]}]}},
+		{"tag": "bad-port", "protocol": "trojan", "settings": {"servers": [{"address": "example.com", "port": "https", "password": "secret"}]}},
+		{"protocol": "vmess", "settings": {"vnext": 1}},
+		{"tag": "ok", "protocol": "trojan", "settings": {"servers": [{"address": "example.com", "port": 443, "password": "secret"}]}}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imported.Links) != 1 || imported.Links[0].Name != "ok" {
+		t.Errorf("links = %+v", imported.Links)
+	}
+	wantErrors := []string{`outbound "no-uuid": missing uuid`, `outbound "bad-port":`, "config 1 outbound 3:"}
+	if len(imported.Errors) != len(wantErrors) {
+		t.Fatalf("errors = %v, want %d", imported.Errors, len(wantErrors))
+	}
+	for idx, want := range wantErrors {
+		if !strings.Contains(imported.Errors[idx].Error(), want) {
+			t.Errorf("error %d = %v, want %q", idx, imported.Errors[idx], want)
+		}
+	}
+
+	for name, data := range map[string]string{
+		"invalid json":  `{"outbounds": [`,
+		"no proxies":    `{"outbounds": [{"protocol": "freedom"}]}`,
+		"invalid array": `[{"outbounds": 1}]`,
+	} {
+		if _, err := ParseXrayJSON([]byte(data)); err == nil {
+			t.Errorf("%s: ParseXrayJSON succeeded", name)
+		}
+	}
+}
+
+func TestParseLinksFromTextDetectsXrayJSON(t *testing.T) {
+	result := ParseLinksFromText(testXrayConfig)
+	if len(result.Links) != 4 || len(result.Errors) != 0 {
+		t.Errorf("got %d links and errors %v", len(result.Links), result.Errors)
+	}
+}
+
+func TestXrayJSONLenientFields(t *testing.T) {
+	var rule RoutingRule
+	if err := json.Unmarshal([]byte(`{"type": "field", "domain": "discord.com", "ip": ["1.1.1.1"], "port": 443, "outboundTag": "proxy"}`), &rule); err != nil {
+		t.Fatal(err)
+	}
+	wantRule := RoutingRule{Type: "field", Domain: []string{"discord.com"}, IP: []string{"1.1.1.1"}, Port: "443", Outbound: "proxy"}
+	if !reflect.DeepEqual(rule, wantRule) {
+		t.Errorf("rule = %+v, want %+v", rule, wantRule)
+	}
+
+	var http HTTPSettings
+	if err := json.Unmarshal([]byte(`{"path": "/h2", "host": ["a.example.com", "b.example.com"]}`), &http); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(http, HTTPSettings{Path: []string{"/h2"}, Host: []string{"a.example.com", "b.example.com"}}) {
+		t.Errorf("http settings = %+v", http)
+	}
+
+	var inbound InboundConfig
+	if err := json.Unmarshal([]byte(`{"port": "1080", "listen": "127.0.0.1", "protocol": "socks", "settings": {"udp": "true", "timeout": "30", "auth": "noauth"}}`), &inbound); err != nil {
+		t.Fatal(err)
+	}
+	wantInbound := InboundConfig{Port: 1080, Listen: "127.0.0.1", Protocol: "socks", Settings: &InboundSettings{Auth: "noauth", UDP: true, Timeout: 30}}
+	if !reflect.DeepEqual(inbound, wantInbound) {
+		t.Errorf("inbound = %+v, want %+v", inbound, wantInbound)
+	}
+
+	for name, data := range map[string]string{
+		"rule port":    `{"port": true}`,
+		"rule domain":  `{"domain": 1}`,
+		"inbound port": `{"port": "http"}`,
+	} {
+		var err error
+		if strings.HasPrefix(name, "rule") {
+			err = json.Unmarshal([]byte(data), &RoutingRule{})
+		} else {
+			err = json.Unmarshal([]byte(data), &InboundConfig{})
+		}
+		if err == nil {
+			t.Errorf("%s: %s was accepted", name, data)
+		}
+	}
+}
+
+func TestParseXrayJSONWireGuardAddresses(t *testing.T) {
+	result, err := ParseXrayJSON([]byte(`{
+  "outbounds": [{
+    "protocol": "wireguard",
+    "tag": "warp",
+    "settings": {
+      "secretKey": "` + testWireGuardKey1 + `",
+      "address": ["172.16.0.2", "2606:4700::2", "10.0.0.2/24"],
+      "peers": [{"publicKey": "` + testWireGuardKey2 + `", "endpoint": "engage.cloudflareclient.com:2408"}]
+    }
+  }]
+}`))
+	if err != nil {
+		t.Fatalf("ParseXrayJSON: %v", err)
+	}
+	if len(result.Links) != 1 {
+		t.Fatalf("got %d links, errors %v", len(result.Links), result.Errors)
+	}
+	want := []string{"172.16.0.2/32", "2606:4700::2/128", "10.0.0.2/24"}
+	if got := result.Links[0].LocalAddress; !reflect.DeepEqual(got, want) {
+		t.Errorf("local address = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,304 @@
+package vpn
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// InboundSettings holds the settings of socks, http and mixed inbounds.
+// Auth, UDP and IP only apply to socks and mixed, AllowTransparent and
+// Timeout only to http.
+type InboundSettings struct {
+	Auth             string           `json:"auth,omitempty"`
+	Accounts         []InboundAccount `json:"accounts,omitempty"`
+	UDP              bool             `json:"udp,omitempty"`
+	IP               string           `json:"ip,omitempty"`
+	AllowTransparent bool             `json:"allowTransparent,omitempty"`
+	Timeout          int              `json:"timeout,omitempty"`
+}
+
+type InboundAccount struct {
+	User string `json:"user"`
+	Pass string `json:"pass"`
+}
+
+// UnmarshalJSON accepts udp as a string, as written by older versions.
+func (s *InboundSettings) UnmarshalJSON(data []byte) error {
+	type plain InboundSettings
+	var raw struct {
+		plain
+		UDP     json.RawMessage `json:"udp"`
+		Timeout json.RawMessage `json:"timeout"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	udp, err := unmarshalBool(raw.UDP)
+	if err != nil {
+		return fmt.Errorf("inbound udp: %w", err)
+	}
+	timeout, err := unmarshalInt(raw.Timeout)
+	if err != nil {
+		return fmt.Errorf("inbound timeout: %w", err)
+	}
+	*s = InboundSettings(raw.plain)
+	s.UDP = udp
+	s.Timeout = timeout
+	return nil
+}
+
+// OutboundSettings is the protocol specific part of an outbound:
+// VNextSettings for vless and vmess, ServersSettings for trojan and
+// shadowsocks, WireGuardSettings for wireguard, FreedomSettings for freedom
+// and RawSettings for the rest.
+type OutboundSettings interface {
+	isOutboundSettings()
+}
+
+type VNextSettings struct {
+	VNext []VNextServer `json:"vnext"`
+}
+
+type VNextServer struct {
+	Address string      `json:"address"`
+	Port    int         `json:"port"`
+	Users   []VNextUser `json:"users"`
+}
+
+type VNextUser struct {
+	ID         string `json:"id"`
+	Encryption string `json:"encryption,omitempty"`
+	Flow       string `json:"flow,omitempty"`
+	AlterID    int    `json:"alterId,omitempty"`
+	Security   string `json:"security,omitempty"`
+}
+
+type ServersSettings struct {
+	Servers []OutboundServer `json:"servers"`
+}
+
+type OutboundServer struct {
+	Address  string `json:"address"`
+	Port     int    `json:"port"`
+	Password string `json:"password"`
+	Method   string `json:"method,omitempty"`
+	Flow     string `json:"flow,omitempty"`
+}
+
+type WireGuardSettings struct {
+	SecretKey string          `json:"secretKey"`
+	Address   []string        `json:"address,omitempty"`
+	Peers     []WireGuardPeer `json:"peers"`
+	MTU       int             `json:"mtu,omitempty"`
+	Reserved  []int           `json:"reserved,omitempty"`
+}
+
+type WireGuardPeer struct {
+	PublicKey    string   `json:"publicKey"`
+	PreSharedKey string   `json:"preSharedKey,omitempty"`
+	Endpoint     string   `json:"endpoint"`
+	AllowedIPs   []string `json:"allowedIPs,omitempty"`
+	KeepAlive    int      `json:"keepAlive,omitempty"`
+}
+
+type FreedomSettings struct {
+	DomainStrategy string `json:"domainStrategy,omitempty"`
+}
+
+// RawSettings keeps the settings of protocols without a typed counterpart.
+type RawSettings json.RawMessage
+
+func (VNextSettings) isOutboundSettings()     {}
+func (ServersSettings) isOutboundSettings()   {}
+func (WireGuardSettings) isOutboundSettings() {}
+func (FreedomSettings) isOutboundSettings()   {}
+func (RawSettings) isOutboundSettings()       {}
+
+func (s RawSettings) MarshalJSON() ([]byte, error) {
+	if len(s) == 0 {
+		return []byte("{}"), nil
+	}
+	return s, nil
+}
+
+// UnmarshalJSON also accepts the flattened single server newer Xray allows.
+func (s *VNextSettings) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		VNext []VNextServer `json:"vnext"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if raw.VNext != nil {
+		*s = VNextSettings{VNext: raw.VNext}
+		return nil
+	}
+
+	var server VNextServer
+	if err := json.Unmarshal(data, &server); err != nil {
+		return err
+	}
+	var user VNextUser
+	if err := json.Unmarshal(data, &user); err != nil {
+		return err
+	}
+	server.Users = []VNextUser{user}
+	*s = VNextSettings{VNext: []VNextServer{server}}
+	return nil
+}
+
+// UnmarshalJSON accepts the port as a number or a string.
+func (s *VNextServer) UnmarshalJSON(data []byte) error {
+	type plain VNextServer
+	var raw struct {
+		plain
+		Port json.RawMessage `json:"port"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	port, err := unmarshalInt(raw.Port)
+	if err != nil {
+		return fmt.Errorf("server port: %w", err)
+	}
+	*s = VNextServer(raw.plain)
+	s.Port = port
+	return nil
+}
+
+// UnmarshalJSON accepts alterId as a number or a string.
+func (u *VNextUser) UnmarshalJSON(data []byte) error {
+	type plain VNextUser
+	var raw struct {
+		plain
+		AlterID json.RawMessage `json:"alterId"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	alterID, err := unmarshalInt(raw.AlterID)
+	if err != nil {
+		return fmt.Errorf("user alterId: %w", err)
+	}
+	*u = VNextUser(raw.plain)
+	u.AlterID = alterID
+	return nil
+}
+
+// UnmarshalJSON also accepts a flattened single server.
+func (s *ServersSettings) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Servers []OutboundServer `json:"servers"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if raw.Servers != nil {
+		*s = ServersSettings{Servers: raw.Servers}
+		return nil
+	}
+
+	var server OutboundServer
+	if err := json.Unmarshal(data, &server); err != nil {
+		return err
+	}
+	*s = ServersSettings{Servers: []OutboundServer{server}}
+	return nil
+}
+
+// UnmarshalJSON accepts the port as a number or a string.
+func (s *OutboundServer) UnmarshalJSON(data []byte) error {
+	type plain OutboundServer
+	var raw struct {
+		plain
+		Port json.RawMessage `json:"port"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	port, err := unmarshalInt(raw.Port)
+	if err != nil {
+		return fmt.Errorf("server port: %w", err)
+	}
+	*s = OutboundServer(raw.plain)
+	s.Port = port
+	return nil
+}
+
+// UnmarshalJSON decodes the settings into the typed struct matching the
+// protocol.
+func (c *OutboundConfig) UnmarshalJSON(data []byte) error {
+	type plain OutboundConfig
+	var raw struct {
+		plain
+		Settings json.RawMessage `json:"settings"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*c = OutboundConfig(raw.plain)
+	if len(raw.Settings) == 0 || string(raw.Settings) == "null" {
+		return nil
+	}
+
+	var err error
+	switch c.Protocol {
+	case "vless", "vmess":
+		var settings VNextSettings
+		err = json.Unmarshal(raw.Settings, &settings)
+		c.Settings = settings
+	case "trojan", "shadowsocks":
+		var settings ServersSettings
+		err = json.Unmarshal(raw.Settings, &settings)
+		c.Settings = settings
+	case "wireguard":
+		var settings WireGuardSettings
+		err = json.Unmarshal(raw.Settings, &settings)
+		c.Settings = settings
+	case "freedom":
+		var settings FreedomSettings
+		err = json.Unmarshal(raw.Settings, &settings)
+		c.Settings = settings
+	default:
+		c.Settings = RawSettings(append([]byte(nil), raw.Settings...))
+	}
+	if err != nil {
+		return fmt.Errorf("%s settings: %w", c.Protocol, err)
+	}
+	return nil
+}
+
+func unmarshalInt(data json.RawMessage) (int, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return 0, nil
+	}
+	var number int
+	if err := json.Unmarshal(data, &number); err == nil {
+		return number, nil
+	}
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return 0, err
+	}
+	if text = strings.TrimSpace(text); text == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(text)
+}
+
+func unmarshalBool(data json.RawMessage) (bool, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return false, nil
+	}
+	var value bool
+	if err := json.Unmarshal(data, &value); err == nil {
+		return value, nil
+	}
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return false, err
+	}
+	return strconv.ParseBool(strings.TrimSpace(text))
+}
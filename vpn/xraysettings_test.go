@@ -0,0 +1,147 @@
+package vpn
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestOutboundSettingsUnmarshal(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want OutboundSettings
+	}{
+		{
+			name: "vnext",
+			data: `{"protocol": "vless", "settings": {"vnext": [{"address": "example.com", "port": 443, "users": [
+				{"id": "` + testUUID + `", "encryption": "none", "flow": "xtls-rprx-vision"}]}]}}`,
+			want: VNextSettings{VNext: []VNextServer{{
+				Address: "example.com", Port: 443,
+				Users: []VNextUser{{ID: testUUID, Encryption: "none", Flow: "xtls-rprx-vision"}},
+			}}},
+		},
+		{
+			name: "vnext string port and alterId",
+			data: `{"protocol": "vmess", "settings": {"vnext": [{"address": "example.com", "port": "8443", "users": [
+				{"id": "` + testUUID + `", "alterId": "4", "security": "auto"}]}]}}`,
+			want: VNextSettings{VNext: []VNextServer{{
+				Address: "example.com", Port: 8443,
+				Users: []VNextUser{{ID: testUUID, AlterID: 4, Security: "auto"}},
+			}}},
+		},
+		{
+			name: "vnext flattened",
+			data: `{"protocol": "vmess", "settings": {"address": "example.com", "port": 443, "id": "` + testUUID + `", "alterId": 2}}`,
+			want: VNextSettings{VNext: []VNextServer{{
+				Address: "example.com", Port: 443,
+				Users: []VNextUser{{ID: testUUID, AlterID: 2}},
+			}}},
+		},
+		{
+			name: "servers",
+			data: `{"protocol": "shadowsocks", "settings": {"servers": [
+				{"address": "example.com", "port": 8388, "method": "aes-128-gcm", "password": "secret"}]}}`,
+			want: ServersSettings{Servers: []OutboundServer{{Address: "example.com", Port: 8388, Method: "aes-128-gcm", Password: "secret"}}},
+		},
+		{
+			name: "servers flattened string port",
+			data: `{"protocol": "trojan", "settings": {"address": "example.com", "port": "443", "password": "secret"}}`,
+			want: ServersSettings{Servers: []OutboundServer{{Address: "example.com", Port: 443, Password: "secret"}}},
+		},
+		{
+			name: "wireguard",
+			data: `{"protocol": "wireguard", "settings": {"secretKey": "key", "address": ["10.0.0.2/32"], "mtu": 1280,
+				"reserved": [1, 2, 3], "peers": [{"publicKey": "peer", "endpoint": "example.com:51820", "keepAlive": 25}]}}`,
+			want: WireGuardSettings{
+				SecretKey: "key", Address: []string{"10.0.0.2/32"}, MTU: 1280, Reserved: []int{1, 2, 3},
+				Peers: []WireGuardPeer{{PublicKey: "peer", Endpoint: "example.com:51820", KeepAlive: 25}},
+			},
+		},
+		{
+			name: "freedom",
+			data: `{"protocol": "freedom", "settings": {"domainStrategy": "UseIPv4"}}`,
+			want: FreedomSettings{DomainStrategy: "UseIPv4"},
+		},
+		{
+			name: "raw",
+			data: `{"protocol": "blackhole", "settings": {"response": {"type": "http"}}}`,
+			want: RawSettings(`{"response": {"type": "http"}}`),
+		},
+		{
+			name: "no settings",
+			data: `{"protocol": "dns"}`,
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var outbound OutboundConfig
+			if err := json.Unmarshal([]byte(tt.data), &outbound); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(outbound.Settings, tt.want) {
+				t.Errorf("settings = %#v\nwant %#v", outbound.Settings, tt.want)
+			}
+		})
+	}
+}
+
+func TestOutboundSettingsUnmarshalErrors(t *testing.T) {
+	for name, data := range map[string]string{
+		"vnext port":    `{"protocol": "vless", "settings": {"vnext": [{"address": "example.com", "port": "https"}]}}`,
+		"alterId":       `{"protocol": "vmess", "settings": {"vnext": [{"address": "example.com", "port": 443, "users": [{"alterId": "x"}]}]}}`,
+		"servers port":  `{"protocol": "trojan", "settings": {"servers": [{"address": "example.com", "port": true}]}}`,
+		"freedom":       `{"protocol": "freedom", "settings": []}`,
+		"inbound udp":   `{"protocol": "socks", "settings": {"udp": "maybe"}}`,
+		"inbound delay": `{"protocol": "http", "settings": {"timeout": "soon"}}`,
+	} {
+		var err error
+		if name == "inbound udp" || name == "inbound delay" {
+			err = json.Unmarshal([]byte(data), &InboundConfig{})
+		} else {
+			err = json.Unmarshal([]byte(data), &OutboundConfig{})
+		}
+		if err == nil {
+			t.Errorf("%s: %s was accepted", name, data)
+		}
+	}
+}
+
+func TestOutboundSettingsMarshal(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings OutboundSettings
+		want     string
+	}{
+		{"empty raw", RawSettings(nil), `{"protocol":"blackhole","settings":{},"streamSettings":{}}`},
+		{"raw", RawSettings(`{"response":{"type":"http"}}`), `{"protocol":"blackhole","settings":{"response":{"type":"http"}},"streamSettings":{}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(OutboundConfig{Protocol: "blackhole", Settings: tt.settings})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("got %s, want %s", data, tt.want)
+			}
+		})
+	}
+
+	// Typed settings survive a marshal and unmarshal round trip.
+	outbound := OutboundConfig{Protocol: "vmess", Tag: "proxy", Settings: VNextSettings{VNext: []VNextServer{{
+		Address: "example.com", Port: 443, Users: []VNextUser{{ID: testUUID, AlterID: 4, Security: "auto"}},
+	}}}}
+	data, err := json.Marshal(outbound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded OutboundConfig
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, outbound) {
+		t.Errorf("decoded = %+v, want %+v", decoded, outbound)
+	}
+}